@@ -1,10 +1,7 @@
 package app
 
 import (
-	"bufio"
 	"fmt"
-	"net"
-	"strconv"
 	"time"
 
 	"github.com/Singert/xjtu_cnlab/core/config"
@@ -14,42 +11,31 @@ import (
 
 // HandleAdminReload 处理远程配置热重载
 func HandleAdminReload(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
-	err := config.ReloadConfig()
-	body := ""
-
-	if err != nil {
-		body = fmt.Sprintf("配置重载失败: %v", err)
-		writer.WriteString("HTTP/1.1 500 Internal Server Error\r\n")
-	} else {
-		body = "配置已成功热重载"
-		writer.WriteString("HTTP/1.1 200 OK\r\n")
+	if err := config.ReloadConfig(); err != nil {
+		ctx.Text(500, fmt.Sprintf("配置重载失败: %v", err))
+		return
 	}
-
-	writer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.WriteString(body)
-	writer.Flush()
+	ctx.Text(200, "配置已成功热重载")
 }
 
+// HandleDownloadLogs 以附件形式流式下载最近的日志
 func HandleDownloadLogs(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
+	w := ctx.Writer
 	logs := talklog.GetRecentLogs()
 
 	// 构造文件名，比如 logs-20250429.txt
 	fileName := fmt.Sprintf("logs-%s.txt", time.Now().Format("20060102-150405"))
 
 	// 写HTTP响应头
-	fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\n")
-	fmt.Fprintf(conn, "Content-Type: application/octet-stream\r\n")
-	fmt.Fprintf(conn, "Content-Disposition: attachment; filename=\"%s\"\r\n", fileName)
-	fmt.Fprintf(conn, "\r\n")
-
-	// 写日志内容
-	for _, line := range logs {
-		fmt.Fprintf(conn, "%s\n", line)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+	w.WriteHeader(200)
+
+	// 写日志内容，分批刷新避免整体缓冲
+	for i, line := range logs {
+		fmt.Fprintf(w, "%s\n", line)
+		if (i+1)%256 == 0 {
+			w.Flush()
+		}
 	}
 }
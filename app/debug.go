@@ -1,108 +1,72 @@
 package app
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
-	"net"
+	"html"
+	"io"
 	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/filecache"
 	"github.com/Singert/xjtu_cnlab/core/router"
 	"github.com/Singert/xjtu_cnlab/core/talklog"
 )
 
 // HandleDebugRoutes 输出当前所有路由
 func HandleDebugRoutes(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
 	routes := ctx.RouterAware.GetRouter().ListRoutes()
+	ctx.HTML(200, renderRoutesHTML(routes, "[Discrption:", "] </li>"))
+}
 
+// renderRoutesHTML 生成路由列表页面
+func renderRoutesHTML(routes []router.RouteEntry, descOpen, descClose string) string {
 	var sb strings.Builder
 	sb.WriteString("<html><head><title>路由列表</title></head><body>")
 	sb.WriteString("<h1>当前注册路由表</h1><ul>")
 
 	for _, r := range routes {
 		sb.WriteString("<li><b>")
-		sb.WriteString(r.Method)
+		sb.WriteString(html.EscapeString(r.Method))
 		sb.WriteString("</b> ")
-		sb.WriteString(r.Pattern)
-		sb.WriteString(" [Discrption:")
-		sb.WriteString(r.Description)
-		sb.WriteString("] </li>")
+		sb.WriteString(html.EscapeString(r.Pattern))
+		sb.WriteString(" " + descOpen)
+		sb.WriteString(html.EscapeString(r.Description))
+		sb.WriteString(descClose)
 	}
 
 	sb.WriteString("</ul></body></html>")
-
-	body := sb.String()
-
-	// 写HTTP响应头
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: text/html; charset=utf-8\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.WriteString(body)
-	writer.Flush()
+	return sb.String()
 }
 
-func HandleDebugRoutesJSON(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
-	// 构造只包含可序列化字段的新切片
-	var routeJSON []struct {
-		Method      string `json:"method"`
-		Pattern     string `json:"pattern"`
-		Discription string `json:"description"`
-	}
-	for _, r := range ctx.RouterAware.GetRouter().ListRoutes() {
-		routeJSON = append(routeJSON, struct {
-			Method      string `json:"method"`
-			Pattern     string `json:"pattern"`
-			Discription string `json:"description"`
-		}{
+// routesToJSON 构造只包含可序列化字段的新切片
+func routesToJSON(routes []router.RouteEntry) []router.RouteEntryJSON {
+	routeJSON := make([]router.RouteEntryJSON, 0, len(routes))
+	for _, r := range routes {
+		routeJSON = append(routeJSON, router.RouteEntryJSON{
 			Method:      r.Method,
 			Pattern:     r.Pattern,
-			Discription: r.Description,
+			Description: r.Description,
+			Middlewares: r.Middlewares,
 		})
 	}
+	return routeJSON
+}
 
-	bodyBytes, err := json.MarshalIndent(routeJSON, "", "  ")
-	if err != nil {
-		bodyBytes = []byte(`{"error": "failed to encode routes"}`)
-	}
-
-	body := string(bodyBytes)
-
-	// 写HTTP响应头
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: application/json; charset=utf-8\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.WriteString(body)
-	writer.Flush()
+func HandleDebugRoutesJSON(ctx *router.Context) {
+	ctx.JSON(200, routesToJSON(ctx.RouterAware.GetRouter().ListRoutes()))
 }
 
 func HandleDebugRoutesSmart(ctx *router.Context) {
-	con := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(con)
 	routes := ctx.RouterAware.GetRouter().ListRoutes()
 
 	accept := ctx.Headers["Accept"]
 
-	query := ctx.Query
-	var (
-		body        string
-		contentType string
-	)
-
 	// 优先根据 URL 参数判断
 	format := ""
-	if v, ok := query["content-type"]; ok {
+	if v, ok := ctx.Query["content-type"]; ok {
 		format = strings.ToLower(v)
 	} else {
 		// 如果URL参数没有，再根据Accept头推测
@@ -114,84 +78,30 @@ func HandleDebugRoutesSmart(ctx *router.Context) {
 	}
 
 	if format == "json" {
-		// 构造只包含可序列化字段的新切片
-		var routeJSON []struct {
-			Method      string `json:"method"`
-			Pattern     string `json:"pattern"`
-			Description string `json:"description"`
-		}
-		for _, r := range routes {
-			routeJSON = append(routeJSON, struct {
-				Method      string `json:"method"`
-				Pattern     string `json:"pattern"`
-				Description string `json:"description"`
-			}{
-				Method:      r.Method,
-				Pattern:     r.Pattern,
-				Description: r.Description,
-			})
-		}
-
-		bodyBytes, err := json.MarshalIndent(routeJSON, "", "  ")
-		if err != nil {
-			bodyBytes = []byte(`{"error": "failed to encode routes"}`)
-		}
-
-		body = string(bodyBytes)
-		contentType = "application/json; charset=utf-8"
-	} else {
-		var sb strings.Builder
-		sb.WriteString("<html><head><title>路由列表</title></head><body>")
-		sb.WriteString("<h1>当前注册路由表</h1><ul>")
-
-		for _, r := range routes {
-			sb.WriteString("<li><b>")
-			sb.WriteString(r.Method)
-			sb.WriteString("</b> ")
-			sb.WriteString(r.Pattern)
-			sb.WriteString(" [Description: ")
-			sb.WriteString(r.Description)
-			sb.WriteString("]</li>")
-		}
-
-		sb.WriteString("</ul></body></html>")
-		body = sb.String()
-		contentType = "text/html; charset=utf-8"
+		ctx.JSON(200, routesToJSON(routes))
+		return
 	}
-
-	// 写HTTP响应头
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: " + contentType + "\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.WriteString(body)
-	writer.Flush()
+	ctx.HTML(200, renderRoutesHTML(routes, "[Description: ", "]</li>"))
 }
 
+// HandleLogs 以纯文本流式输出最近的日志
 func HandleLogs(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
+	w := ctx.Writer
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
 
 	logs := talklog.GetRecentLogs()
-	body := strings.Join(logs, "\n")
-
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n")
-	writer.WriteString(body)
-	writer.Flush()
+	for i, line := range logs {
+		if i > 0 {
+			io.WriteString(w, "\n")
+		}
+		io.WriteString(w, line)
+	}
 }
 
 // 热更新路由
 func HandleUpdateRoute(ctx *router.Context) {
-	con := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(con)
-
 	query := ctx.Query
-	var (
-		body        string
-		contentType string
-	)
 
 	var handlerRegistry = map[string]router.HandlerFunc{}
 
@@ -209,14 +119,7 @@ func HandleUpdateRoute(ctx *router.Context) {
 		parttern = v
 	}
 	if method == "" || parttern == "" {
-		body = "method or pattern is empty"
-		contentType = "text/plain; charset=utf-8"
-		writer.WriteString("HTTP/1.1 400 Bad Request\r\n")
-		writer.WriteString("Content-Type: " + contentType + "\r\n")
-		writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-		writer.WriteString("\r\n")
-		writer.WriteString(body)
-		writer.Flush()
+		ctx.Text(400, "method or pattern is empty")
 		return
 	}
 
@@ -231,23 +134,11 @@ func HandleUpdateRoute(ctx *router.Context) {
 	}
 
 	ctx.RouterAware.GetRouter().Update(method, parttern, description, newHandler)
-	body = "路由更新成功"
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: " + contentType + "\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.WriteString(body)
-	writer.Flush()
+	ctx.Text(200, "路由更新成功")
 }
 
 // /debug/info 返回服务器运行配置
 func HandleDebugInfo(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-	var (
-		body        string
-		contentType string
-	)
 	// 获取服务器配置
 	info := map[string]interface{}{
 		"enable_tls": config.Cfg.Server.EnableTLS,
@@ -258,31 +149,16 @@ func HandleDebugInfo(ctx *router.Context) {
 		"workdir":    config.Cfg.Server.Workdir,
 		"is_dual":    config.Cfg.Server.IsDualStack,
 	}
-
-	bodyBytes, err := json.MarshalIndent(info, "", "  ")
-	if err != nil {
-		bodyBytes = []byte(`{"error": "failed to encode info"}`)
+	if c := filecache.Default(); c != nil {
+		info["file_cache"] = c.Stats()
 	}
-	body = string(bodyBytes)
-	contentType = "application/json; charset=utf-8"
-	// 写HTTP响应头
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: " + contentType + "\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.WriteString(body)
-	writer.Flush()
-	talklog.Info(talklog.GID(), "Debug info requested: %s", body)
+
+	ctx.JSON(200, info)
+	talklog.Info(talklog.GID(), "Debug info requested: %v", info)
 }
 
 // /debug/uptime 返回服务器运行时间
 func HandleUptime(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-	var (
-		body        string
-		contentType string
-	)
 	// 获取服务器运行时间
 	uptime := time.Since(config.Cfg.StartTime)
 
@@ -291,70 +167,21 @@ func HandleUptime(ctx *router.Context) {
 		"since":  config.Cfg.StartTime.Format(time.RFC3339),
 	}
 
-	bodyBytes, err := json.MarshalIndent(result, "", "  ")
-	if err != nil {
-		bodyBytes = []byte(`{"error": "failed to encode uptime"}`)
-	}
-	body = string(bodyBytes)
-	contentType = "application/json; charset=utf-8"
-	// 写HTTP响应头
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: " + contentType + "\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.WriteString(body)
-	writer.Flush()
+	ctx.JSON(200, result)
 	// 记录日志
 
 	talklog.Info(0, "Uptime requested: %s", uptime)
 }
 
 func HandleConnCounts(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
-	counts := config.GetConnCount()
-
-	bodyBytes, err := json.MarshalIndent(counts, "", "  ")
-	if err != nil {
-		bodyBytes = []byte(`{"error": "failed to encode counts"}`)
-	}
-	body := string(bodyBytes)
-
-	// 写HTTP响应头
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: application/json; charset=utf-8\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.WriteString(body)
-	writer.Flush()
+	ctx.JSON(200, config.GetConnCount())
 }
 
 func HandleGortnCounts(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
-	counts := runtime.NumGoroutine()
-
-	bodyBytes, err := json.MarshalIndent(counts, "", "  ")
-	if err != nil {
-		bodyBytes = []byte(`{"error": "failed to encode counts"}`)
-	}
-	body := string(bodyBytes)
-
-	// 写HTTP响应头
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: application/json; charset=utf-8\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.WriteString(body)
-	writer.Flush()
+	ctx.JSON(200, runtime.NumGoroutine())
 }
 
 func HandleDebugMeta(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
 	uptime := time.Since(config.Cfg.StartTime)
 
 	html := `<html>
@@ -395,19 +222,12 @@ func HandleDebugMeta(ctx *router.Context) {
 </body>
 </html>`
 
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: text/html; charset=utf-8\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(html)) + "\r\n\r\n")
-	writer.WriteString(html)
-	writer.Flush()
+	ctx.HTML(200, html)
 
 	talklog.Info(talklog.GID(), "Debug meta page requested")
 }
 
 func HandleDebugDashboard(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
 	routes := ctx.RouterAware.GetRouter().ListRoutes()
 	uptime := time.Since(config.Cfg.StartTime)
 
@@ -474,9 +294,10 @@ func HandleDebugDashboard(ctx *router.Context) {
 	`)
 
 	// 路由表
-	sb.WriteString(`<details open><summary><h2>🟩 路由表</h2></summary><table><tr><th>Method</th><th>Pattern</th><th>Description</th></tr>`)
+	sb.WriteString(`<details open><summary><h2>🟩 路由表</h2></summary><table><tr><th>Method</th><th>Pattern</th><th>Description</th><th>Middlewares</th></tr>`)
 	for _, r := range routes {
-		sb.WriteString("<tr><td>" + r.Method + "</td><td>" + r.Pattern + "</td><td>" + r.Description + "</td></tr>")
+		sb.WriteString("<tr><td>" + html.EscapeString(r.Method) + "</td><td>" + html.EscapeString(r.Pattern) + "</td><td>" + html.EscapeString(r.Description) +
+			"</td><td>" + html.EscapeString(strings.Join(r.Middlewares, " → ")) + "</td></tr>")
 	}
 	sb.WriteString("</table></details>")
 
@@ -495,6 +316,24 @@ func HandleDebugDashboard(ctx *router.Context) {
 	sb.WriteString(fmt.Sprintf(`<tr><td>当前连接总数</td><td>%d</td></tr>`, config.GetConnCount()))
 	sb.WriteString("</table></details>")
 
+	// 静态文件缓存
+	sb.WriteString(`<details open><summary><h2>🟨 静态文件缓存</h2></summary><table>`)
+	if c := filecache.Default(); c != nil {
+		st := c.Stats()
+		hitRate := 0.0
+		if total := st.Hits + st.Misses; total > 0 {
+			hitRate = float64(st.Hits) * 100 / float64(total)
+		}
+		sb.WriteString(fmt.Sprintf(`<tr><td>命中 / 未命中</td><td>%d / %d（%.1f%%）</td></tr>`, st.Hits, st.Misses, hitRate))
+		sb.WriteString(fmt.Sprintf(`<tr><td>条目数</td><td>%d</td></tr>`, st.Entries))
+		sb.WriteString(fmt.Sprintf(`<tr><td>内容占用</td><td>%d 字节</td></tr>`, st.Bytes))
+		sb.WriteString(fmt.Sprintf(`<tr><td>淘汰 / 失效</td><td>%d / %d</td></tr>`, st.Evictions, st.Invalidations))
+		sb.WriteString(fmt.Sprintf(`<tr><td>监视目录数</td><td>%d</td></tr>`, st.WatchedDirs))
+	} else {
+		sb.WriteString(`<tr><td>状态</td><td>未启用（config.yml 中 cache.Enabled）</td></tr>`)
+	}
+	sb.WriteString("</table></details>")
+
 	// 日志搜索 + 日志区域
 	sb.WriteString(`<details open><summary><h2>🟥 实时日志（可搜索）</h2></summary>
 	<input type="text" id="logFilter" placeholder="输入关键词过滤日志..." oninput="filterLogs()">
@@ -503,12 +342,7 @@ func HandleDebugDashboard(ctx *router.Context) {
 
 	sb.WriteString("</body></html>")
 
-	html := sb.String()
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: text/html; charset=utf-8\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(html)) + "\r\n\r\n")
-	writer.WriteString(html)
-	writer.Flush()
+	ctx.HTML(200, sb.String())
 
 	talklog.Info(talklog.GID(), "访问了 /debug/dashboard 调试面板")
 }
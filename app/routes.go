@@ -1,15 +1,16 @@
 package app
 
 import (
-	"bufio"
-	"net"
-	"strconv"
+	"fmt"
 
 	"github.com/Singert/xjtu_cnlab/core/router"
+	"github.com/Singert/xjtu_cnlab/core/tus"
 )
 
 // RegisterAppRoutes 注册应用路由
 func RegisterAppRoutes(r *router.Router) {
+	r.Use(router.Recovery(), router.Logger())
+
 	r.RegisterRoute("GET", "/hello", "discription", HandleHello)
 	r.RegisterRoute("POST", "/upload", "discription", HandleUpload)
 
@@ -18,8 +19,9 @@ func RegisterAppRoutes(r *router.Router) {
 		g.RegisterRoute("GET", "/download-logs", "discription", HandleDownloadLogs)
 
 	})
-	r.RegisterRoute("GET", "logs", "discription", HandleLogs)
+	r.RegisterRoute("GET", "/logs", "discription", HandleLogs)
 	r.RegisterGroupRoute("/debug", func(g *router.Group) {
+		g.Use(router.Timing())
 		g.RegisterRoute("GET", "/", "discription", HandleDebugRoutes)
 		g.RegisterRoute("GET", "/json", "discription", HandleDebugRoutesJSON)
 		g.RegisterRoute("GET", "/routes", "discription", HandleDebugRoutesSmart)
@@ -29,60 +31,47 @@ func RegisterAppRoutes(r *router.Router) {
 		g.RegisterRoute("GET", "/download-logs", "discription", HandleDownloadLogs)
 	})
 
+	// tus 断点续传端点（tus.Enabled 时挂载）
+	tus.Register(r)
 }
 
 func HandleHello(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
-	body := []byte("Hello World!")
-
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: text/plain\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.Write(body)
-	writer.Flush()
+	ctx.Text(200, "Hello World!")
 }
 
+// HandleUpload 接收 multipart 表单，返回收到的字段和文件信息
 func HandleUpload(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
-	body := []byte("Upload successful (dummy)")
-
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: text/plain\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.Write(body)
-	writer.Flush()
+	form, err := ctx.ParseMultipartForm(router.DefaultMaxMemory)
+	if err == router.ErrBodyTooLarge {
+		ctx.Text(413, "Request body too large")
+		return
+	}
+	if err != nil {
+		ctx.Text(400, fmt.Sprintf("Invalid multipart form: %v", err))
+		return
+	}
+
+	type uploadedFile struct {
+		Field    string `json:"field"`
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+	}
+	files := make([]uploadedFile, 0)
+	for field, headers := range form.File {
+		for _, fh := range headers {
+			files = append(files, uploadedFile{Field: field, Filename: fh.Filename, Size: fh.Size})
+		}
+	}
+	ctx.JSON(200, map[string]any{
+		"fields": form.Value,
+		"files":  files,
+	})
 }
 
 func HandleLogin(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
-	body := []byte("Login successful (dummy)")
-
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: text/plain\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.Write(body)
-	writer.Flush()
+	ctx.Text(200, "Login successful (dummy)")
 }
 
 func HandleRegister(ctx *router.Context) {
-	conn := ctx.Conn.(net.Conn)
-	writer := bufio.NewWriter(conn)
-
-	body := []byte("Register successful (dummy)")
-
-	writer.WriteString("HTTP/1.1 200 OK\r\n")
-	writer.WriteString("Content-Type: text/plain\r\n")
-	writer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
-	writer.WriteString("\r\n")
-	writer.Write(body)
-	writer.Flush()
+	ctx.Text(200, "Register successful (dummy)")
 }
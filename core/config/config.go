@@ -5,6 +5,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -30,6 +31,102 @@ type Config struct {
 		CertFile       string
 		KeyFile        string
 		ForceIPV4      bool
+		MaxBodySize    int64    // 路由处理器与 CGI 一次性读取请求体的上限（字节）
+		Docroot        []string // 静态文件的来源，如 "dir:./www"、"zip:site.zip#dist"、"tar:site.tar.gz"、"embed:testbench"；多个时按顺序叠加，为空时使用 Workdir
+	}
+
+	Mounts []Mount // 路径别名：把 URL 前缀映射到独立的文档根，按最长前缀匹配，优先于路由
+
+	Cache struct {
+		Enabled     bool
+		MaxEntries  int   // 最多缓存的路径数
+		MaxBytes    int64 // 文件内容与压缩变体占用的内存上限
+		MaxFileSize int64 // 超过该大小的文件只缓存元数据
+	}
+
+	Compression struct {
+		Level         int      // 压缩级别，0 表示默认级别
+		MinSize       int64    // 小于该大小的文件不压缩，0 表示默认 1024
+		Types         []string // 允许压缩的 MIME 类型，支持 "text/*"，为空时使用默认列表
+		Precompressed bool     // 存在 foo.js.gz 时直接发送预压缩文件
+	}
+
+	ETag struct {
+		Hash        bool  // 使用文件内容的 SHA-256 生成 ETag（结果按 inode/大小/修改时间缓存）
+		HashMaxSize int64 // 超过该大小的文件仍使用 inode/大小/修改时间生成 ETag
+	}
+
+	Security struct {
+		Symlinks string   // 符号链接策略：deny、within-root（默认，解析后必须仍在工作目录内）或 allow
+		Deny     []string // 禁止访问的 glob 模式：不含 / 的模式匹配任意一级名称（如 ".*"、"*.key"），含 / 的匹配整个相对路径
+	}
+
+	Listing struct {
+		ShowHidden bool // 目录列表中显示以 . 开头的文件
+	}
+
+	Templates struct {
+		Enabled   bool   // 以 html/template 渲染扩展名为 Extension 的静态文件
+		Extension string // 模板文件的扩展名，默认 ".tmpl"
+		Dir       string // 片段目录（相对 Workdir），其中的模板可用 {{template "name.tmpl" .}} 引用
+	}
+
+	Errors struct {
+		Pages       string // 错误页模板目录（相对 Workdir），按 404.html、4xx.html、error.html 的顺序查找，为空时使用内置页面
+		ProblemJSON bool   // 偏好 JSON 的客户端（Accept: application/json）得到 RFC 9457 application/problem+json
+	}
+
+	Static struct {
+		Index    []string // 目录的索引文件，按顺序查找，为空时为 index.html、index.htm、index
+		TryFiles []string // 请求的文件不存在时依次尝试的 URL 路径，"$uri" 代表请求路径，如 ["$uri.html", "$uri/"]
+		SPA      []SPA    // 单页应用：前缀下不存在且没有扩展名的路径返回指定页面
+	}
+
+	Upload struct {
+		MaxFileSize       int64    // 单个文件的大小上限，0 表示默认 100 MiB
+		MaxRequestSize    int64    // 单个上传请求的大小上限，0 表示默认 256 MiB
+		Overwrite         string   // 目标已存在时：reject（409）、rename（另存为 "a (1).txt"）或 replace
+		AllowedExtensions []string // 允许的扩展名，为空表示不限制
+		AllowedTypes      []string // 允许的 MIME 类型，支持 "image/*"，为空表示不限制
+	}
+
+	Write struct {
+		Enabled    bool     // 允许 PUT/DELETE 静态文件
+		Paths      []string // 允许写入的 URL 路径前缀，如 "/artifacts/"；为空时不允许任何路径
+		CreateDirs bool     // PUT 时自动创建不存在的父目录，否则应答 409
+		DeleteDirs bool     // DELETE 可以删除空目录
+		MaxSize    int64    // PUT 请求体上限，0 表示与 upload.MaxFileSize 相同
+	}
+
+	WebDAV struct {
+		Enabled            bool // 以 WebDAV（class 1/2）方式提供工作目录，可用文件管理器或 davfs2 挂载
+		ReadOnly           bool // 只允许 PROPFIND 等只读方法
+		MaxPropfindEntries int  // PROPFIND 单次应答的资源数上限，0 表示默认 10000
+	}
+
+	Tus struct {
+		Enabled    bool
+		Path       string // 挂载路径，默认 /tus/，不要与静态文件的目录重名
+		UploadDir  string // 未完成上传的暂存目录，默认系统临时目录下的 xjtu_cnlab-tus
+		TargetDir  string // 完成的文件保存到工作目录下的该子目录，空表示工作目录本身
+		MaxSize    int64  // 单个上传的大小上限，0 表示不限制
+		Expiration int    // 未完成的上传保留的秒数，0 表示默认 24 小时
+	}
+
+	Archive struct {
+		Enabled      bool  // 允许通过 ?archive=zip|tar.gz 打包下载目录
+		MaxTotalSize int64 // 打包文件的总字节数上限，0 表示默认 1 GiB
+		MaxFiles     int   // 打包的文件与目录数上限，0 表示默认 10000
+	}
+
+	Cors struct {
+		Enabled          bool
+		AllowOrigins     []string // "*"、精确来源、"https://*.example.com" 或 "regex:..."
+		AllowMethods     []string
+		AllowHeaders     []string
+		ExposeHeaders    []string
+		AllowCredentials bool
+		MaxAge           int
 	}
 
 	Logger struct {
@@ -40,9 +137,38 @@ type Config struct {
 	StartTime time.Time
 }
 
+// Mount 一个路径别名及其独立的静态文件策略
+type Mount struct {
+	Prefix  string   // URL 前缀，如 "/static"
+	Source  string   // 文档根，写法与 server.Docroot 的一项相同，如 "/srv/assets"、"zip:docs.zip#html"
+	Listing *bool    // 没有索引文件时是否列出目录，默认列出
+	Index   []string // 目录的索引文件，为空时使用全局设置
+	Cache   *bool    // 是否使用文件缓存，默认跟随 cache.Enabled
+	Upload  bool     // 允许 POST 上传到该挂载（仅本地目录）
+	CGI     bool     // 把其中的可执行文件作为 CGI 脚本运行（需要 server.IsCgi，仅本地目录）
+}
+
+// SPA 一个单页应用的回退规则
+type SPA struct {
+	Prefix string // URL 前缀，如 "/app"
+	Index  string // 回退页面的 URL 路径，默认为前缀下的 index.html
+}
+
 var Cfg Config
 var GlobalConnCount atomic.Int32
 
+var (
+	reloadMu    sync.Mutex
+	reloadHooks []func()
+)
+
+// OnReload 注册配置热重载成功后要执行的回调，用于清理依赖配置的缓存
+func OnReload(fn func()) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadHooks = append(reloadHooks, fn)
+}
+
 func IncConn()            { GlobalConnCount.Add(1) }
 func DecConn()            { GlobalConnCount.Add(-1) }
 func GetConnCount() int32 { return GlobalConnCount.Load() }
@@ -76,6 +202,13 @@ func ReloadConfig() error {
 		fmt.Printf("Unable to decode into struct: %v\n", err)
 		return err
 	}
+
+	reloadMu.Lock()
+	hooks := append([]func(){}, reloadHooks...)
+	reloadMu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
 	return nil
 }
 
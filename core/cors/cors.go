@@ -0,0 +1,238 @@
+package cors
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+)
+
+// Policy 跨域资源共享策略
+//
+// AllowOrigins 中的每一项可以是：
+//   - "*"                     允许任意来源
+//   - "https://example.com"   精确匹配（忽略大小写）
+//   - "https://*.example.com" 通配子域名
+//   - "regex:https://.*\.example\.org"  正则匹配，必须匹配整个来源
+//
+// "*" 不能与 AllowCredentials 同时使用，否则任意网站都能带着用户的凭据读取响应
+type Policy struct {
+	AllowOrigins     []string
+	AllowMethods     []string // 为空时使用 DefaultMethods
+	AllowHeaders     []string // 为空时回显预检请求中的 Access-Control-Request-Headers
+	ExposeHeaders    []string
+	AllowCredentials bool
+	MaxAge           int // 预检结果缓存秒数，0 表示不发送
+
+	anyOrigin bool
+	exact     map[string]bool
+	patterns  []*regexp.Regexp
+}
+
+// DefaultMethods 未配置 AllowMethods 时允许的方法
+var DefaultMethods = []string{"GET", "HEAD", "POST"}
+
+// New 编译策略中的来源匹配规则
+func New(p Policy) (*Policy, error) {
+	p.exact = make(map[string]bool)
+	p.patterns = nil
+	p.anyOrigin = false
+	for _, origin := range p.AllowOrigins {
+		origin = strings.TrimSpace(origin)
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.HasPrefix(origin, "regex:"):
+			re, err := regexp.Compile("^(?:" + strings.TrimPrefix(origin, "regex:") + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("cors: bad origin pattern %q: %v", origin, err)
+			}
+			p.patterns = append(p.patterns, re)
+		case strings.Contains(origin, "*"):
+			// 通配符只代表一段或多段子域名，其余部分按字面量匹配
+			quoted := regexp.QuoteMeta(strings.ToLower(origin))
+			re := regexp.MustCompile("^" + strings.ReplaceAll(quoted, `\*`, `[a-z0-9-]+(\.[a-z0-9-]+)*`) + "$")
+			p.patterns = append(p.patterns, re)
+		case origin != "":
+			p.exact[strings.ToLower(origin)] = true
+		}
+	}
+	if p.anyOrigin && p.AllowCredentials {
+		return nil, fmt.Errorf("cors: origin \"*\" cannot be combined with AllowCredentials")
+	}
+	if len(p.AllowMethods) == 0 {
+		p.AllowMethods = DefaultMethods
+	}
+	p.AllowMethods = append([]string(nil), p.AllowMethods...)
+	for i, m := range p.AllowMethods {
+		p.AllowMethods[i] = strings.ToUpper(strings.TrimSpace(m))
+	}
+	return &p, nil
+}
+
+// MustNew 同 New，规则错误时 panic，便于在注册路由时直接使用
+func MustNew(p Policy) *Policy {
+	policy, err := New(p)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+// AllowOrigin 报告是否允许该来源
+func (p *Policy) AllowOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if p.anyOrigin {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if p.exact[lower] {
+		return true
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(origin) || re.MatchString(lower) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowMethod 报告是否允许该方法，AllowMethods 中的 "*" 允许任意方法
+func (p *Policy) allowMethod(method string) bool {
+	for _, m := range p.AllowMethods {
+		if m == "*" || m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders 检查预检请求的请求头是否全部允许，返回要发送的 Access-Control-Allow-Headers
+func (p *Policy) allowHeaders(requested string) (string, bool) {
+	if len(p.AllowHeaders) == 0 {
+		return requested, true
+	}
+	allowed := make(map[string]bool)
+	for _, h := range p.AllowHeaders {
+		if h == "*" {
+			return requested, true
+		}
+		allowed[strings.ToLower(strings.TrimSpace(h))] = true
+	}
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" && !allowed[h] {
+			return "", false
+		}
+	}
+	return strings.Join(p.AllowHeaders, ", "), true
+}
+
+// VaryOrigin 报告响应是否随 Origin 变化：回显具体来源的策略下，
+// 不论来源是否允许（包括没有 Origin 的请求）响应都要带 Vary: Origin，
+// 否则共享缓存可能把不含 Access-Control-Allow-Origin 的响应返回给允许的来源
+func (p *Policy) VaryOrigin() bool {
+	return !p.anyOrigin
+}
+
+// originHeaders 返回所有跨域响应都需要的头，Vary 由调用方按 VaryOrigin 发送
+func (p *Policy) originHeaders(origin string) [][2]string {
+	var headers [][2]string
+	if p.anyOrigin {
+		headers = append(headers, [2]string{"Access-Control-Allow-Origin", "*"})
+	} else {
+		headers = append(headers, [2]string{"Access-Control-Allow-Origin", origin})
+	}
+	if p.AllowCredentials {
+		headers = append(headers, [2]string{"Access-Control-Allow-Credentials", "true"})
+	}
+	return headers
+}
+
+// Preflight 评估预检请求，返回应答所需的响应头；不允许时 ok 为 false
+func (p *Policy) Preflight(origin, method, requestHeaders string) (headers [][2]string, ok bool) {
+	if !p.AllowOrigin(origin) || !p.allowMethod(strings.ToUpper(method)) {
+		return nil, false
+	}
+	allowHeaders, ok := p.allowHeaders(requestHeaders)
+	if !ok {
+		return nil, false
+	}
+	headers = p.originHeaders(origin)
+	headers = append(headers, [2]string{"Access-Control-Allow-Methods", strings.Join(p.AllowMethods, ", ")})
+	if allowHeaders != "" {
+		headers = append(headers, [2]string{"Access-Control-Allow-Headers", allowHeaders})
+	}
+	if p.MaxAge > 0 {
+		headers = append(headers, [2]string{"Access-Control-Max-Age", strconv.Itoa(p.MaxAge)})
+	}
+	return headers, true
+}
+
+// ResponseHeaders 返回实际跨域请求的响应需要附加的头；来源不允许时返回 nil
+func (p *Policy) ResponseHeaders(origin string) [][2]string {
+	if !p.AllowOrigin(origin) {
+		return nil
+	}
+	headers := p.originHeaders(origin)
+	if len(p.ExposeHeaders) > 0 {
+		headers = append(headers, [2]string{"Access-Control-Expose-Headers", strings.Join(p.ExposeHeaders, ", ")})
+	}
+	return headers
+}
+
+// IsPreflight 报告请求是否为 CORS 预检请求
+func IsPreflight(method string, headers map[string]string) bool {
+	return method == "OPTIONS" && headers["Origin"] != "" && headers["Access-Control-Request-Method"] != ""
+}
+
+var (
+	globalMu     sync.Mutex
+	globalPolicy *Policy
+	globalLoaded bool
+)
+
+func init() {
+	config.OnReload(func() {
+		globalMu.Lock()
+		globalLoaded = false
+		globalMu.Unlock()
+	})
+}
+
+// Global 返回 config.yml 中配置的全局策略，未启用时返回 nil
+// 配置热重载后会重新构建
+func Global() *Policy {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	if globalLoaded {
+		return globalPolicy
+	}
+	globalLoaded = true
+	globalPolicy = nil
+
+	c := config.Cfg.Cors
+	if !c.Enabled {
+		return nil
+	}
+	policy, err := New(Policy{
+		AllowOrigins:     c.AllowOrigins,
+		AllowMethods:     c.AllowMethods,
+		AllowHeaders:     c.AllowHeaders,
+		ExposeHeaders:    c.ExposeHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	})
+	if err != nil {
+		talklog.Error(talklog.GID(), "Invalid CORS config: %v", err)
+		return nil
+	}
+	globalPolicy = policy
+	return globalPolicy
+}
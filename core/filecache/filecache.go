@@ -0,0 +1,438 @@
+// Package filecache 静态文件的内存缓存
+//
+// 按路径缓存文件元数据（包括“不存在”）、小文件内容及其压缩后的变体，
+// 以 LRU 方式限制条目数与内容总字节数。缓存的文件所在目录通过 fsnotify
+// 监视，目录中任何文件发生变化都会使对应条目及目录本身的条目失效；
+// 无法监视的目录不会被缓存，保证不会返回过期内容。
+package filecache
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/fsnotify/fsnotify"
+)
+
+// 默认上限
+const (
+	DefaultMaxEntries  = 4096
+	DefaultMaxBytes    = 64 << 20
+	DefaultMaxFileSize = 256 << 10
+	maxWatchedDirs     = 1024
+)
+
+// testHookRead 测试用：Stat 与 ReadFile 在锁外读取文件之后调用，用于模拟读取期间发生的失效
+var testHookRead func(path string)
+
+// Options 缓存上限
+type Options struct {
+	MaxEntries  int   // 最多缓存的路径数
+	MaxBytes    int64 // 缓存内容（含压缩变体）的总字节数上限
+	MaxFileSize int64 // 超过该大小的文件只缓存元数据
+}
+
+// Stats 缓存统计
+type Stats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Evictions     int64 `json:"evictions"`
+	Invalidations int64 `json:"invalidations"`
+	Entries       int   `json:"entries"`
+	Bytes         int64 `json:"bytes"`
+	WatchedDirs   int   `json:"watched_dirs"`
+}
+
+type entry struct {
+	path     string
+	info     os.FileInfo // nil 表示文件不存在
+	data     []byte      // 小文件内容，nil 表示未缓存
+	variants map[string][]byte
+	bytes    int64         // 计入 MaxBytes 的字节数
+	dirs     []*watchedDir // 条目依赖的被监视目录：所在目录，目录条目还包括自身
+}
+
+// watchedDir 被监视的目录，refs 为依赖它的条目数（包括正在读取、尚未保存的条目）
+type watchedDir struct {
+	path string
+	refs int
+}
+
+// Cache 静态文件缓存，并发安全
+type Cache struct {
+	opts Options
+
+	mu      sync.Mutex
+	ll      *list.List // 最近使用的在前
+	items   map[string]*list.Element
+	bytes   int64
+	watched map[string]*watchedDir
+	watcher *fsnotify.Watcher
+	gen     uint64 // 每次失效加一，用于丢弃失效前读取到的结果
+
+	hits, misses, evictions, invalidations atomic.Int64
+}
+
+// New 创建缓存并启动文件监视
+func New(opts Options) (*Cache, error) {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = DefaultMaxFileSize
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		opts:    opts,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+		watched: make(map[string]*watchedDir),
+		watcher: watcher,
+	}
+	go c.watch(watcher)
+	return c, nil
+}
+
+// Close 停止文件监视并清空缓存
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+	c.mu.Unlock()
+	return c.watcher.Close()
+}
+
+// watch 处理文件系统事件
+func (c *Cache) watch(w *fsnotify.Watcher) {
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			// 文件本身及其所在目录（目录条目缓存了索引文件的探测结果）都失效
+			c.Invalidate(ev.Name)
+			c.Invalidate(filepath.Dir(ev.Name))
+			if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+				c.unwatch(ev.Name)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			// 可能丢失了事件（如队列溢出），保守起见清空缓存
+			talklog.Warn(talklog.GID(), "File cache watcher error, purging: %v", err)
+			c.Purge()
+		}
+	}
+}
+
+// watchDir 监视 dir 并增加其引用，失败时返回 nil（调用方不应缓存该目录下的文件）
+// 引用随条目一起释放；没有保存条目时调用方要立即 release，调用方持有锁
+func (c *Cache) watchDir(dir string) *watchedDir {
+	if w, ok := c.watched[dir]; ok {
+		w.refs++
+		return w
+	}
+	if len(c.watched) >= maxWatchedDirs {
+		return nil
+	}
+	if err := c.watcher.Add(dir); err != nil {
+		return nil
+	}
+	w := &watchedDir{path: dir, refs: 1}
+	c.watched[dir] = w
+	return w
+}
+
+// release 释放条目对目录的引用，目录的最后一个条目被删除后停止监视，调用方持有锁
+func (c *Cache) release(dirs []*watchedDir) {
+	for _, w := range dirs {
+		w.refs--
+		if w.refs == 0 && c.watched[w.path] == w {
+			delete(c.watched, w.path)
+			c.watcher.Remove(w.path)
+		}
+	}
+}
+
+// unwatch 被删除或移走的目录不再监视，依赖它的条目一并失效
+func (c *Cache) unwatch(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.watched[dir]
+	if !ok {
+		return
+	}
+	delete(c.watched, dir)
+	c.watcher.Remove(dir)
+	c.gen++
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		for _, d := range el.Value.(*entry).dirs {
+			if d == w {
+				c.remove(el)
+				c.invalidations.Add(1)
+				break
+			}
+		}
+		el = next
+	}
+}
+
+// Stat 返回文件信息，结果（包括文件不存在）会被缓存
+func (c *Cache) Stat(path string) (os.FileInfo, error) {
+	path = filepath.Clean(path)
+	c.mu.Lock()
+	if el, ok := c.items[path]; ok {
+		c.ll.MoveToFront(el)
+		info := el.Value.(*entry).info
+		c.mu.Unlock()
+		c.hits.Add(1)
+		if info == nil {
+			return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+		}
+		return info, nil
+	}
+	// 先建立监视再读取，保证之后的变化一定能使条目失效
+	parent := c.watchDir(filepath.Dir(path))
+	gen := c.gen
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	info, err := os.Stat(path)
+	if testHookRead != nil {
+		testHookRead(path)
+	}
+	if parent == nil {
+		return info, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &entry{path: path, info: info, dirs: []*watchedDir{parent}}
+	// 权限等错误不缓存
+	cacheable := err == nil || errors.Is(err, fs.ErrNotExist)
+	// 目录还要监视自身（子项变化影响索引文件的探测结果）
+	if cacheable && info != nil && info.IsDir() {
+		if self := c.watchDir(path); self != nil {
+			e.dirs = append(e.dirs, self)
+		} else {
+			cacheable = false
+		}
+	}
+	if _, ok := c.items[path]; ok || !cacheable || gen != c.gen {
+		c.release(e.dirs)
+		return info, err
+	}
+	c.add(e)
+	return info, err
+}
+
+// ReadFile 返回小文件的内容，超过 MaxFileSize 时返回 ok == false
+// info 为调用方通过 Stat 得到的信息，用于判断大小
+func (c *Cache) ReadFile(path string, info os.FileInfo) ([]byte, bool) {
+	if info == nil || !info.Mode().IsRegular() || info.Size() > c.opts.MaxFileSize {
+		return nil, false
+	}
+	path = filepath.Clean(path)
+	c.mu.Lock()
+	el, ok := c.items[path]
+	if ok {
+		if e := el.Value.(*entry); e.data != nil {
+			c.ll.MoveToFront(el)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return e.data, true
+		}
+	}
+	gen := c.gen
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, c.opts.MaxFileSize+1))
+	if testHookRead != nil {
+		testHookRead(path)
+	}
+	if err != nil || int64(len(data)) != info.Size() {
+		// 读取期间文件发生了变化
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 只有元数据条目仍然有效（期间没有被失效）时才保存内容
+	if el, ok := c.items[path]; ok {
+		e := el.Value.(*entry)
+		if gen == c.gen && e.info != nil && e.data == nil && e.info.ModTime().Equal(info.ModTime()) {
+			e.data = data
+			c.grow(e, int64(len(data)))
+		}
+	}
+	return data, true
+}
+
+// Variant 返回缓存的压缩变体（如 "gzip"）
+func (c *Cache) Variant(path, coding string) ([]byte, bool) {
+	path = filepath.Clean(path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[path]; ok {
+		if data, ok := el.Value.(*entry).variants[coding]; ok {
+			c.ll.MoveToFront(el)
+			c.hits.Add(1)
+			return data, true
+		}
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+// SetVariant 保存压缩变体，只有文件内容已被缓存时才保存
+func (c *Cache) SetVariant(path, coding string, data []byte) {
+	path = filepath.Clean(path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[path]
+	if !ok {
+		return
+	}
+	e := el.Value.(*entry)
+	if e.data == nil {
+		return
+	}
+	if e.variants == nil {
+		e.variants = make(map[string][]byte)
+	}
+	if old, ok := e.variants[coding]; ok {
+		c.grow(e, -int64(len(old)))
+	}
+	e.variants[coding] = data
+	c.grow(e, int64(len(data)))
+}
+
+// Invalidate 删除路径对应的条目
+func (c *Cache) Invalidate(path string) {
+	path = filepath.Clean(path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if el, ok := c.items[path]; ok {
+		c.remove(el)
+		c.invalidations.Add(1)
+	}
+}
+
+// Purge 清空所有条目
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		c.release(el.Value.(*entry).dirs)
+	}
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// Stats 返回统计信息
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Entries:       len(c.items),
+		Bytes:         c.bytes,
+		WatchedDirs:   len(c.watched),
+	}
+}
+
+// add 插入新条目并按上限淘汰，调用方持有锁
+func (c *Cache) add(e *entry) {
+	c.items[e.path] = c.ll.PushFront(e)
+	c.evict()
+}
+
+// grow 调整条目占用的字节数并按上限淘汰，调用方持有锁
+func (c *Cache) grow(e *entry, n int64) {
+	e.bytes += n
+	c.bytes += n
+	c.evict()
+}
+
+func (c *Cache) evict() {
+	for c.ll.Len() > 0 && (c.ll.Len() > c.opts.MaxEntries || c.bytes > c.opts.MaxBytes) {
+		c.remove(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.path)
+	c.bytes -= e.bytes
+	c.release(e.dirs)
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultCache  *Cache
+	defaultLoaded bool
+)
+
+func init() {
+	config.OnReload(func() {
+		defaultMu.Lock()
+		defer defaultMu.Unlock()
+		if defaultCache != nil {
+			defaultCache.Close()
+		}
+		defaultCache, defaultLoaded = nil, false
+	})
+}
+
+// Default 返回按 config.yml 的 cache 段创建的全局缓存，未启用时返回 nil
+// 配置热重载后会重新创建
+func Default() *Cache {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultLoaded {
+		return defaultCache
+	}
+	defaultLoaded = true
+	cfg := config.Cfg.Cache
+	if !cfg.Enabled {
+		return nil
+	}
+	c, err := New(Options{MaxEntries: cfg.MaxEntries, MaxBytes: cfg.MaxBytes, MaxFileSize: cfg.MaxFileSize})
+	if err != nil {
+		talklog.Error(talklog.GID(), "File cache disabled, cannot start watcher: %v", err)
+		return nil
+	}
+	defaultCache = c
+	talklog.Info(talklog.GID(), "File cache enabled: %d entries, %d bytes", c.opts.MaxEntries, c.opts.MaxBytes)
+	return defaultCache
+}
@@ -0,0 +1,272 @@
+package filecache
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newCache(t *testing.T, opts Options) *Cache {
+	t.Helper()
+	c, err := New(opts)
+	if err != nil {
+		t.Skipf("fsnotify not available: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func writeFile(t *testing.T, name, data string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eventually 等待文件监视的通知生效，直到 cond 成立
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// cached 报告 path 是否有缓存条目
+func (c *Cache) cached(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[filepath.Clean(path)]
+	return ok
+}
+
+func TestInvalidateOnChange(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	writeFile(t, name, "one")
+	c := newCache(t, Options{})
+
+	fi, err := c.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := c.ReadFile(name, fi); !ok || string(data) != "one" {
+		t.Fatalf("ReadFile = %q, %v", data, ok)
+	}
+	hits := c.Stats().Hits
+	if _, err := c.Stat(name); err != nil || c.Stats().Hits != hits+1 {
+		t.Fatal("second Stat should be served from the cache")
+	}
+
+	t.Run("write", func(t *testing.T) {
+		writeFile(t, name, "three")
+		eventually(t, "the write to invalidate the entry", func() bool {
+			fi, err := c.Stat(name)
+			return err == nil && fi.Size() == 5
+		})
+		fi, _ := c.Stat(name)
+		if data, ok := c.ReadFile(name, fi); !ok || string(data) != "three" {
+			t.Fatalf("ReadFile after write = %q, %v", data, ok)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		renamed := filepath.Join(dir, "b.txt")
+		if _, err := c.Stat(renamed); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("Stat(b.txt) error = %v, want ErrNotExist", err)
+		}
+		if !c.cached(renamed) {
+			t.Fatal("a missing file should be cached")
+		}
+		if err := os.Rename(name, renamed); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, "the rename to invalidate both names", func() bool {
+			_, errOld := c.Stat(name)
+			_, errNew := c.Stat(renamed)
+			return errors.Is(errOld, fs.ErrNotExist) && errNew == nil
+		})
+		name = renamed
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		if err := os.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, "the removal to invalidate the entry", func() bool {
+			_, err := c.Stat(name)
+			return errors.Is(err, fs.ErrNotExist)
+		})
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		sub := filepath.Join(dir, "sub")
+		if err := os.Mkdir(sub, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := c.Stat(sub); err != nil || !fi.IsDir() {
+			t.Fatalf("Stat(sub) = %v, %v", fi, err)
+		}
+		// 目录条目依赖其中的子项（索引文件的探测结果），新增文件也使它失效
+		writeFile(t, filepath.Join(sub, "index.html"), "x")
+		eventually(t, "a new child to invalidate its directory", func() bool { return !c.cached(sub) })
+
+		// 被删除的目录停止监视，依赖它的条目一并失效
+		child := filepath.Join(sub, "index.html")
+		c.Stat(sub)
+		c.Stat(child)
+		if err := os.RemoveAll(sub); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, "the removed directory to be unwatched", func() bool {
+			c.mu.Lock()
+			_, watched := c.watched[sub]
+			c.mu.Unlock()
+			return !watched && !c.cached(child)
+		})
+	})
+}
+
+func TestStaleStatDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	writeFile(t, name, "one")
+	c := newCache(t, Options{})
+
+	// 锁外读取到旧的信息之后文件被修改并失效，读到的结果不能保存
+	testHookRead = func(path string) {
+		testHookRead = nil
+		writeFile(t, name, "three")
+		c.Invalidate(path)
+	}
+	t.Cleanup(func() { testHookRead = nil })
+	if fi, err := c.Stat(name); err != nil || fi.Size() != 3 {
+		t.Fatalf("first Stat = %v, %v; want the old size", fi, err)
+	}
+	if c.cached(name) {
+		t.Fatal("a stat that raced with an invalidation should not be cached")
+	}
+	if fi, err := c.Stat(name); err != nil || fi.Size() != 5 {
+		t.Fatalf("Stat after invalidation = %v, %v; want the new size", fi, err)
+	}
+}
+
+func TestStaleReadDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	writeFile(t, name, "one")
+	c := newCache(t, Options{})
+	fi, err := c.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 读取内容后文件被替换为大小与修改时间都相同的新内容，失效后元数据条目又被重新缓存：
+	// 只有失效计数能区分读到的旧内容
+	testHookRead = func(path string) {
+		testHookRead = nil
+		writeFile(t, name, "two")
+		if err := os.Chtimes(name, fi.ModTime(), fi.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+		c.Invalidate(path)
+		if _, err := c.Stat(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { testHookRead = nil })
+	if data, ok := c.ReadFile(name, fi); !ok || string(data) != "one" {
+		t.Fatalf("first ReadFile = %q, %v", data, ok)
+	}
+	if data, ok := c.ReadFile(name, fi); !ok || string(data) != "two" {
+		t.Fatalf("ReadFile after invalidation = %q, %v; want the new content", data, ok)
+	}
+}
+
+func TestWatchRefcounts(t *testing.T) {
+	base := t.TempDir()
+	var files []string
+	for _, d := range []string{"a", "b", "c"} {
+		dir := filepath.Join(base, d)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(dir, "f.txt")
+		writeFile(t, name, d)
+		files = append(files, name)
+	}
+	c := newCache(t, Options{MaxEntries: 2, MaxBytes: 1 << 20})
+	watched := func() int { return c.Stats().WatchedDirs }
+
+	// 同一目录中的两个条目共享一个监视
+	c.Stat(files[0])
+	c.Stat(filepath.Join(base, "a", "missing"))
+	if n := watched(); n != 1 {
+		t.Fatalf("watched dirs = %d, want 1", n)
+	}
+	// 按条目数淘汰：a 中的两个条目都被淘汰后不再监视 a
+	c.Stat(files[1])
+	c.Stat(files[2])
+	if n := watched(); n != 2 {
+		t.Fatalf("watched dirs after eviction = %d, want 2", n)
+	}
+	c.mu.Lock()
+	_, watchingA := c.watched[filepath.Join(base, "a")]
+	c.mu.Unlock()
+	if watchingA || c.Stats().Evictions != 2 {
+		t.Fatalf("a should be unwatched after its entries are evicted (evictions %d)", c.Stats().Evictions)
+	}
+
+	// 目录条目还监视目录本身，失效后两个引用都释放
+	c.Invalidate(files[1])
+	c.Invalidate(files[2])
+	if n := watched(); n != 0 {
+		t.Fatalf("watched dirs after invalidation = %d, want 0", n)
+	}
+	c.Stat(filepath.Join(base, "b"))
+	if n := watched(); n != 2 {
+		t.Fatalf("watched dirs for a directory entry = %d, want the parent and itself", n)
+	}
+	c.Purge()
+	if n := watched(); n != 0 {
+		t.Fatalf("watched dirs after Purge = %d, want 0", n)
+	}
+}
+
+func TestEvictBySize(t *testing.T) {
+	dir := t.TempDir()
+	c := newCache(t, Options{MaxBytes: 10, MaxFileSize: 8})
+	read := func(base, data string) {
+		name := filepath.Join(dir, base)
+		writeFile(t, name, data)
+		fi, err := c.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.ReadFile(name, fi); !ok {
+			t.Fatalf("ReadFile(%s) not cached", base)
+		}
+	}
+	read("a", "aaaaaa")
+	read("b", "bbbbbb")
+	if c.cached(filepath.Join(dir, "a")) || !c.cached(filepath.Join(dir, "b")) {
+		t.Fatal("the least recently used entry should be evicted when MaxBytes is exceeded")
+	}
+	if s := c.Stats(); s.Bytes != 6 || s.Evictions != 1 {
+		t.Fatalf("stats = %+v, want 6 bytes after one eviction", s)
+	}
+
+	// 超过 MaxFileSize 的文件只缓存元数据
+	name := filepath.Join(dir, "big")
+	writeFile(t, name, "0123456789")
+	fi, _ := c.Stat(name)
+	if _, ok := c.ReadFile(name, fi); ok {
+		t.Fatal("files larger than MaxFileSize should not be read into the cache")
+	}
+}
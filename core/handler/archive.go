@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+// 目录打包的默认上限
+const (
+	defaultArchiveMaxTotalSize = 1 << 30
+	defaultArchiveMaxFiles     = 10000
+)
+
+// ArchiveFormats 支持的 ?archive= 取值及其 MIME 类型
+var ArchiveFormats = map[string]string{
+	"zip":    "application/zip",
+	"tar.gz": "application/gzip",
+}
+
+var errArchiveTooLarge = errors.New("directory exceeds archive limits")
+
+// archiveEntry 打包时的一项，name 为归档内以 / 分隔的相对路径
+type archiveEntry struct {
+	file staticFile
+	name string
+	info os.FileInfo
+}
+
+// archiveResponse 记录 SendHead 选定的目录打包响应，由 SendBody 流式输出
+type archiveResponse struct {
+	format  string
+	entries []archiveEntry
+	chunked bool
+}
+
+// archiveLimits 返回打包的总大小与文件数上限
+func archiveLimits() (maxSize int64, maxFiles int) {
+	maxSize, maxFiles = config.Cfg.Archive.MaxTotalSize, config.Cfg.Archive.MaxFiles
+	if maxSize <= 0 {
+		maxSize = defaultArchiveMaxTotalSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultArchiveMaxFiles
+	}
+	return maxSize, maxFiles
+}
+
+// collectArchive 遍历目录，按目录列表相同的规则跳过隐藏文件与被拒绝的路径
+//
+// 本地目录中的符号链接按 security.Symlinks 策略跟随到目标，指向已访问目录的链接被跳过以避免循环；
+// 超过总大小或文件数上限时返回 errArchiveTooLarge。root 为文档根中的名称
+func (h *SimpleHTTPRequestHandler) collectArchive(root string) ([]archiveEntry, error) {
+	maxSize, maxFiles := archiveLimits()
+	var entries []archiveEntry
+	var total int64
+	visited := make(map[string]bool)
+
+	// enter 记录目录（本地目录取真实路径），已访问过时返回 false
+	enter := func(dir staticFile) bool {
+		key := dir.name
+		if dir.osPath != "" {
+			real, err := filepath.EvalSymlinks(dir.osPath)
+			if err != nil {
+				return false
+			}
+			key = real
+		}
+		if visited[key] {
+			return false
+		}
+		visited[key] = true
+		return true
+	}
+
+	var walk func(dir, prefix string) error
+	walk = func(dir, prefix string) error {
+		list, err := fs.ReadDir(h.FS, dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range list {
+			name := e.Name()
+			if isHidden(name) {
+				continue
+			}
+			sf, err := h.locateStatic(path.Join(dir, name))
+			if err != nil {
+				continue
+			}
+			fi, err := h.statStatic(sf)
+			if err != nil {
+				// 悬空的符号链接等无法访问的项与普通访问一样视为不存在
+				continue
+			}
+			switch {
+			case fi.IsDir():
+				if !enter(sf) {
+					talklog.Warn(talklog.GID(), "Skipping symlink loop in archive: %s", sf.name)
+					continue
+				}
+				entries = append(entries, archiveEntry{file: sf, name: prefix + name + "/", info: fi})
+				if err := walk(sf.name, prefix+name+"/"); err != nil {
+					return err
+				}
+			case fi.Mode().IsRegular():
+				total += fi.Size()
+				entries = append(entries, archiveEntry{file: sf, name: prefix + name, info: fi})
+			default:
+				continue
+			}
+			if len(entries) > maxFiles || total > maxSize {
+				return errArchiveTooLarge
+			}
+		}
+		return nil
+	}
+	if rootFile, err := h.locateStatic(root); err == nil {
+		enter(rootFile)
+	}
+	if err := walk(root, ""); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// archiveName 返回下载文件名（不含扩展名），根目录使用 "root"
+func archiveName(urlPath string) string {
+	name := path.Base(strings.TrimSuffix(urlPath, "/"))
+	if name == "" || name == "/" || name == "." {
+		return "root"
+	}
+	return name
+}
+
+// SendArchiveHead 处理 ?archive= 请求：遍历目录并发送打包下载的响应头，返回是否已经应答
+// 未开启 archive.Enabled 时返回 false，按普通目录处理
+func (h *SimpleHTTPRequestHandler) SendArchiveHead(dir, format string) bool {
+	if !config.Cfg.Archive.Enabled {
+		return false
+	}
+	// 打包下载与目录列表一样暴露目录的内容，挂载禁止列出目录时同样不能打包
+	if h.mount != nil && !h.mount.listingAllowed() {
+		h.SendError(utils.FORBIDDEN, "Directory listing is disabled")
+		return true
+	}
+	contentType, ok := ArchiveFormats[format]
+	if !ok {
+		h.SendError(utils.BAD_REQUEST, "Unsupported archive format")
+		return true
+	}
+	entries, err := h.collectArchive(dir)
+	if err == errArchiveTooLarge {
+		maxSize, maxFiles := archiveLimits()
+		talklog.Warn(talklog.GID(), "Archive of %s exceeds limits (%d bytes, %d files)", dir, maxSize, maxFiles)
+		h.SendError(utils.FORBIDDEN, "Directory is too large to download as an archive")
+		return true
+	}
+	if err != nil {
+		talklog.Error(talklog.GID(), "Failed to walk %s for archive: %v", dir, err)
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Error reading directory")
+		return true
+	}
+
+	filename := archiveName(h.Path) + "." + format
+	plan := &archiveResponse{format: format, entries: entries, chunked: h.RequestVersion >= "HTTP/1.1"}
+	h.archivePlan = plan
+	h.SendResponse(utils.OK, "")
+	h.SendHeader("Content-Type", contentType)
+	h.SendHeader("Content-Disposition",
+		`attachment; filename="`+strings.ReplaceAll(filename, `"`, "_")+`"; filename*=UTF-8''`+url.PathEscape(filename))
+	if plan.chunked {
+		h.SendHeader("Transfer-Encoding", "chunked")
+	} else {
+		h.SendHeader("Connection", "close")
+	}
+	h.SendHeader("Cache-Control", "no-store")
+	h.EndHeaders()
+	talklog.Info(talklog.GID(), "Streaming %s archive of %s (%d entries)", format, dir, len(entries))
+	return true
+}
+
+// sendArchiveBody 边读文件边写出归档
+func (h *SimpleHTTPRequestHandler) sendArchiveBody(plan *archiveResponse) error {
+	return h.writeStream(plan.chunked, func(w io.Writer) error {
+		if plan.format == "zip" {
+			return h.writeZip(w, plan.entries)
+		}
+		return h.writeTarGz(w, plan.entries)
+	})
+}
+
+// writeStream 调用 fn 写出长度未知的响应体，chunked 时合并零碎的输出再分块
+func (h *SimpleHTTPRequestHandler) writeStream(chunked bool, fn func(w io.Writer) error) error {
+	if !chunked {
+		return fn(h.WFile)
+	}
+	cw := NewChunkedWriter(h.WFile)
+	bw := bufio.NewWriterSize(cw, compressBufferSize)
+	if err := fn(bw); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// copyEntry 复制文件内容，只复制遍历时记录的大小，文件在此期间被截断时返回错误
+func (h *SimpleHTTPRequestHandler) copyEntry(w io.Writer, e archiveEntry) error {
+	f, err := h.openStatic(e.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(w, f, e.info.Size())
+	return err
+}
+
+func (h *SimpleHTTPRequestHandler) writeZip(w io.Writer, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		hdr, err := zip.FileInfoHeader(e.info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.name
+		if e.info.IsDir() {
+			if _, err := zw.CreateHeader(hdr); err != nil {
+				return err
+			}
+			continue
+		}
+		hdr.Method = zip.Deflate
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if err := h.copyEntry(fw, e); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (h *SimpleHTTPRequestHandler) writeTarGz(w io.Writer, entries []archiveEntry) error {
+	gw, err := newEncoder("gzip", w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr, err := tar.FileInfoHeader(e.info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.name
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !e.info.IsDir() {
+			if err := h.copyEntry(tw, e); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
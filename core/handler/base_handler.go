@@ -3,7 +3,6 @@ package handler
 import (
 	"bufio"
 	"fmt"
-	"html"
 	"io"
 	"net"
 	"net/textproto"
@@ -14,9 +13,12 @@ import (
 	"time"
 
 	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/cors"
+	"github.com/Singert/xjtu_cnlab/core/router"
 	"github.com/Singert/xjtu_cnlab/core/server"
 	"github.com/Singert/xjtu_cnlab/core/talklog"
 	"github.com/Singert/xjtu_cnlab/core/utils"
+	"github.com/Singert/xjtu_cnlab/core/vfs"
 )
 
 type ProcessMethod interface {
@@ -26,7 +28,7 @@ type ProcessMethod interface {
 	DoPUT()
 	DoDELETE()
 	DoOPTIONS()
-	SendHead() (*os.File, error)
+	SendHead() (vfs.File, error)
 }
 
 // BaseHTTPRequestHandler 实现基本的HTTP请求处理器
@@ -51,11 +53,37 @@ type BaseHTTPRequestHandler struct {
 	DefaultRequestVersion string            // 默认请求版本
 	HeadersBuffer         [][]byte          // 响应头缓冲区
 	ProcessMethod         ProcessMethod     // 处理方法接口
-	IsGzip                bool              // 是否启用gzip
+	IsGzip                bool              // 客户端是否接受gzip
+	ContentEncoding       string            // 协商出的内容编码（gzip/deflate），空表示不压缩
+	Body                  io.Reader         // 解码后的请求体（Content-Length 或 chunked）
+	ContentLength         int64             // 请求体长度，-1 表示未知（chunked）
+	Trailers              map[string]string // chunked 请求体的尾部字段，读完请求体后有效
+	ExtraHeaders          [][2]string       // 随下一个响应一起发送的附加响应头
+	Methods               map[string]func() // HandleMethod 注册的方法处理器，优先于 ProcessMethod
+	RequestID             string            // 当前请求的 ID，随 X-Request-ID 响应头返回
+
+	doneHooks  []func() // OnRequestDone 登记的函数，当前请求结束时调用
+	forceClose bool     // 请求的帧有歧义（如同时带 Transfer-Encoding 与 Content-Length），响应后必须关闭连接
 
 	Server *server.HTTPServer // 服务器实例
 }
 
+// 请求体相关的上限
+const (
+	MaxBufferedBody = 64 << 20 // ReadBody 默认允许缓冲的最大请求体
+	maxDiscardBody  = 1 << 18  // 处理完请求后最多丢弃的剩余请求体
+)
+
+var ErrBodyTooLarge = router.ErrBodyTooLarge
+
+// MaxBodySize 返回允许一次性读入内存的请求体上限
+func MaxBodySize() int64 {
+	if config.Cfg.Server.MaxBodySize > 0 {
+		return config.Cfg.Server.MaxBodySize
+	}
+	return MaxBufferedBody
+}
+
 // 替代 h.WFile.Write() 并统计写入的字节数
 
 // NewBaseHTTPRequestHandler 创建一个新的基本HTTP请求处理器
@@ -107,9 +135,14 @@ func (h *BaseHTTPRequestHandler) HandleOneRequest() {
 	 * 9. 处理连接关闭
 	 */
 	try := func() {
+		defer h.requestDone()
 		gid := talklog.GID()
 		talklog.SetPrefix(gid, "HTTP")
 		talklog.Info(gid, "New request from %s", h.ClientAddress)
+		h.ExtraHeaders = nil
+		h.RequestID = ""
+		h.ErrorMessageFormat = utils.DefaultErrorMessageFormat
+		h.ErrorContentType = utils.DefaultErrorContentType
 		if h.RFile == nil {
 			talklog.Error(gid, "RFile is nil")
 			h.CloseConnection = true
@@ -150,7 +183,14 @@ func (h *BaseHTTPRequestHandler) HandleOneRequest() {
 		for k, v := range h.Headers {
 			talklog.Hdr(gid, k, v)
 		}
+		h.RequestID = requestID(h.Headers["X-Request-Id"])
 		talklog.Req(gid, h.Command, h.Path, h.RequestVersion)
+		// 跨域预检请求在这里直接应答
+		if h.HandleCORS() {
+			h.DiscardBody()
+			h.WFile.Flush()
+			return
+		}
 		// 根据请求命令调用相应的处理方法
 		mname := "Do" + h.Command
 		method := h.GetMethod(mname)
@@ -161,6 +201,8 @@ func (h *BaseHTTPRequestHandler) HandleOneRequest() {
 
 		// 调用处理方法
 		method()
+		// 丢弃处理器未读完的请求体，避免污染下一个 keep-alive 请求
+		h.DiscardBody()
 		// 刷新响应
 		h.WFile.Flush()
 
@@ -180,10 +222,39 @@ func (h *BaseHTTPRequestHandler) HandleOneRequest() {
 	try()
 }
 
+// OnRequestDone 登记在当前请求结束（响应已写出）时调用的函数，用于释放请求期间持有的资源
+func (h *BaseHTTPRequestHandler) OnRequestDone(fn func()) {
+	h.doneHooks = append(h.doneHooks, fn)
+}
+
+// requestDone 按登记的相反顺序调用 OnRequestDone 登记的函数
+func (h *BaseHTTPRequestHandler) requestDone() {
+	hooks := h.doneHooks
+	h.doneHooks = nil
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
+
+// ExtensionMethodHandler 可选接口：处理标准方法以外的请求方法（PATCH 及扩展方法）
+type ExtensionMethodHandler interface {
+	DoExtension()
+}
+
+// HandleMethod 为请求方法注册处理器（如 WebDAV 的 PROPFIND），可覆盖标准方法
+func (h *BaseHTTPRequestHandler) HandleMethod(method string, fn func()) {
+	if h.Methods == nil {
+		h.Methods = make(map[string]func())
+	}
+	h.Methods[method] = fn
+}
+
 // 子类重写 GetMethod
+// 查找顺序：HandleMethod 注册的处理器、ProcessMethod 的标准方法、DoExtension
 func (h *BaseHTTPRequestHandler) GetMethod(name string) func() {
-	// print name
-	// fmt.Println(name)
+	if fn, ok := h.Methods[strings.TrimPrefix(name, "Do")]; ok {
+		return fn
+	}
 	switch name {
 	case "DoGET":
 		return h.ProcessMethod.DoGET
@@ -198,14 +269,35 @@ func (h *BaseHTTPRequestHandler) GetMethod(name string) func() {
 	case "DoOPTIONS":
 		return h.ProcessMethod.DoOPTIONS
 	}
+	if em, ok := h.ProcessMethod.(ExtensionMethodHandler); ok && IsToken(strings.TrimPrefix(name, "Do")) {
+		return em.DoExtension
+	}
 	return nil
 }
 
+// IsToken 报告 s 是否为合法的 HTTP token（RFC 9110 5.6.2），用于校验方法名
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // ParseRequest 解析HTTP请求
 func (h *BaseHTTPRequestHandler) ParseRequest(requestLine string) bool {
 	h.Command = "" // 设置为空，以防解析第一行出错
 	h.RequestVersion = h.DefaultRequestVersion
 	h.CloseConnection = true
+	h.forceClose = false
 
 	// 去除请求行末尾的回车换行符
 	requestLine = strings.TrimRight(requestLine, "\r\n")
@@ -292,15 +384,18 @@ func (h *BaseHTTPRequestHandler) ParseRequest(requestLine string) bool {
 
 	h.Command, h.Path = command, path
 	h.RawURL = path
+	h.QueryRaw = ""
 
-	// 解析查询字符串
-	u, err := url.ParseRequestURI(h.RawURL)
-	if err != nil {
-		h.SendError(utils.BAD_REQUEST, fmt.Sprintf("Bad request URI (%s)", h.RawURL))
-		return false
+	// 解析查询字符串（OPTIONS * 使用 asterisk-form，没有路径可解析）
+	if !(command == "OPTIONS" && path == "*") {
+		u, err := url.ParseRequestURI(h.RawURL)
+		if err != nil {
+			h.SendError(utils.BAD_REQUEST, fmt.Sprintf("Bad request URI (%s)", h.RawURL))
+			return false
+		}
+		h.Path = u.Path
+		h.QueryRaw = u.RawQuery
 	}
-	h.Path = u.Path
-	h.QueryRaw = u.RawQuery
 
 	// 防止开放重定向攻击
 	if strings.HasPrefix(h.Path, "//") {
@@ -331,6 +426,11 @@ func (h *BaseHTTPRequestHandler) ParseRequest(requestLine string) bool {
 		}
 	}
 
+	// 确定请求体的边界
+	if !h.SetupBody() {
+		return false
+	}
+
 	// 检查Connection头
 	connType := h.Headers["Connection"]
 	if strings.ToLower(connType) == "close" {
@@ -338,26 +438,22 @@ func (h *BaseHTTPRequestHandler) ParseRequest(requestLine string) bool {
 	} else if strings.ToLower(connType) == "keep-alive" && h.ProtocolVersion >= "HTTP/1.1" {
 		h.CloseConnection = false
 	}
+	// 帧有歧义时 keep-alive 不能让连接继续使用
+	if h.forceClose {
+		h.CloseConnection = true
+	}
 
-	// gzip逻辑：
-	// 检查客户端是否支持 Gzip:
-	// 	- 解析 Accept-Encoding 请求头。
-	// 	- 检查是否包含 gzip。
-	// 如果支持，则设置 h.IsGzip 为 true。
-	// 否则，设置为 false。
-
+	// 内容编码协商：
+	// 	- 按 Accept-Encoding 中的 q 值在 gzip、deflate 与 identity 之间选择，q=0 表示拒绝。
+	// 	- h.ContentEncoding 为协商结果，空字符串表示不压缩。
+	// 	- h.IsGzip 表示客户端是否接受 gzip（用于预压缩的 .gz 文件）。
 	acceptEncoding := h.Headers["Accept-Encoding"]
-	if strings.Contains(acceptEncoding, "gzip") {
-		h.IsGzip = true
-		talklog.Info(talklog.GID(), "客户端支持 gzip 压缩，已启用")
-
+	h.ContentEncoding = NegotiateEncoding(acceptEncoding, SupportedEncodings)
+	h.IsGzip = AcceptsEncoding(acceptEncoding, "gzip")
+	if h.ContentEncoding != "" {
+		talklog.Info(talklog.GID(), "协商内容编码：%s", h.ContentEncoding)
 	} else {
-		h.IsGzip = false
-		if h.IsGzip {
-			talklog.Info(talklog.GID(), "客户端支持 gzip 压缩，已启用")
-		} else {
-			talklog.Info(talklog.GID(), "客户端不支持 gzip 压缩")
-		}
+		talklog.Info(talklog.GID(), "客户端不接受压缩编码")
 	}
 
 	// 处理Expect头
@@ -371,6 +467,157 @@ func (h *BaseHTTPRequestHandler) ParseRequest(requestLine string) bool {
 	return true
 }
 
+// SetupBody 根据 Transfer-Encoding / Content-Length 构造请求体读取器 (RFC 9112 6.3)
+func (h *BaseHTTPRequestHandler) SetupBody() bool {
+	h.Trailers = nil
+	h.ContentLength = 0
+	h.Body = io.LimitReader(h.RFile, 0)
+
+	if te, ok := h.Headers["Transfer-Encoding"]; ok {
+		codings := strings.Split(te, ",")
+		last := strings.ToLower(strings.TrimSpace(codings[len(codings)-1]))
+		if last != "chunked" {
+			// chunked 必须是最后一个编码，否则无法确定请求体长度
+			h.SendError(utils.BAD_REQUEST, fmt.Sprintf("Bad Transfer-Encoding (%s)", te))
+			return false
+		}
+		if len(codings) > 1 {
+			h.SendError(utils.NOT_IMPLEMENTED, fmt.Sprintf("Unsupported Transfer-Encoding (%s)", te))
+			return false
+		}
+		if h.RequestVersion < "HTTP/1.1" {
+			h.SendError(utils.BAD_REQUEST, "Transfer-Encoding is not allowed in HTTP/1.0 requests")
+			return false
+		}
+		if _, hasLength := h.Headers["Content-Length"]; hasLength {
+			// 同时出现时以 Transfer-Encoding 为准，并在响应后关闭连接以防请求走私
+			delete(h.Headers, "Content-Length")
+			h.forceClose = true
+			talklog.Warn(talklog.GID(), "Both Transfer-Encoding and Content-Length present, ignoring Content-Length")
+		}
+		h.ContentLength = -1
+		h.Body = NewChunkedReader(h.RFile)
+		return true
+	}
+
+	if cl, ok := h.Headers["Content-Length"]; ok {
+		length, err := strconv.ParseInt(strings.TrimSpace(cl), 10, 64)
+		if err != nil || length < 0 {
+			h.SendError(utils.BAD_REQUEST, fmt.Sprintf("Bad Content-Length (%s)", cl))
+			return false
+		}
+		h.ContentLength = length
+		h.Body = io.LimitReader(h.RFile, length)
+	}
+	return true
+}
+
+// HasBody 报告请求是否携带请求体
+func (h *BaseHTTPRequestHandler) HasBody() bool {
+	return h.ContentLength != 0
+}
+
+// ReadBody 读取完整的请求体，超过 limit 字节时返回 ErrBodyTooLarge
+func (h *BaseHTTPRequestHandler) ReadBody(limit int64) ([]byte, error) {
+	if h.Body == nil {
+		return nil, nil
+	}
+	if h.ContentLength > limit {
+		return nil, ErrBodyTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(h.Body, limit+1))
+	if err != nil {
+		h.CloseConnection = true
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		h.CloseConnection = true
+		return nil, ErrBodyTooLarge
+	}
+	h.collectTrailers()
+	return data, nil
+}
+
+// DiscardBody 丢弃未读取的请求体
+// 剩余数据过多或请求体格式错误时直接关闭连接，而不是继续读取
+func (h *BaseHTTPRequestHandler) DiscardBody() {
+	if h.Body == nil {
+		return
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(h.Body, maxDiscardBody+1))
+	if err != nil || n > maxDiscardBody {
+		talklog.Warn(talklog.GID(), "Unread request body could not be drained (%d bytes, err=%v), closing connection", n, err)
+		h.CloseConnection = true
+	}
+	h.collectTrailers()
+	h.Body = nil
+}
+
+// collectTrailers 在 chunked 请求体读完后记录 trailer 字段
+func (h *BaseHTTPRequestHandler) collectTrailers() {
+	if h.Trailers != nil {
+		return
+	}
+	if cr, ok := h.Body.(*ChunkedReader); ok && cr.Trailers() != nil {
+		h.Trailers = cr.Trailers()
+		for k, v := range h.Trailers {
+			talklog.Hdr(talklog.GID(), k, v)
+		}
+	}
+}
+
+// CORSPolicy 返回当前请求适用的跨域策略：路由分组的策略优先，其次是全局配置
+func (h *BaseHTTPRequestHandler) CORSPolicy() *cors.Policy {
+	method := h.Command
+	if cors.IsPreflight(h.Command, h.Headers) {
+		method = strings.ToUpper(h.Headers["Access-Control-Request-Method"])
+	}
+	if h.Server != nil && h.Server.Router != nil {
+		if policy := h.Server.Router.CORSPolicy(method, h.Path); policy != nil {
+			return policy
+		}
+	}
+	return cors.Global()
+}
+
+// HandleCORS 处理带 Origin 的跨域请求
+// 预检请求直接应答并返回 true；其他请求登记跨域响应头后返回 false，
+// 由后续的静态文件、路由或 CGI 响应一并发送；适用的策略回显具体来源时，所有响应都带 Vary: Origin
+func (h *BaseHTTPRequestHandler) HandleCORS() bool {
+	policy := h.CORSPolicy()
+	if policy == nil {
+		return false
+	}
+	if policy.VaryOrigin() {
+		h.AddResponseHeader("Vary", "Origin")
+	}
+	origin := h.Headers["Origin"]
+	if origin == "" {
+		return false
+	}
+
+	if !cors.IsPreflight(h.Command, h.Headers) {
+		for _, kv := range policy.ResponseHeaders(origin) {
+			h.AddResponseHeader(kv[0], kv[1])
+		}
+		return false
+	}
+
+	headers, ok := policy.Preflight(origin, h.Headers["Access-Control-Request-Method"], h.Headers["Access-Control-Request-Headers"])
+	if !ok {
+		talklog.Warn(talklog.GID(), "CORS preflight rejected: origin %s, method %s",
+			origin, h.Headers["Access-Control-Request-Method"])
+		h.SendError(utils.FORBIDDEN, "", "Cross-origin request not allowed")
+		return true
+	}
+	for _, kv := range headers {
+		h.AddResponseHeader(kv[0], kv[1])
+	}
+	h.SendResponse(utils.NO_CONTENT, "")
+	h.EndHeaders()
+	return true
+}
+
 // HandleExpect100 处理Expect: 100-continue头
 func (h *BaseHTTPRequestHandler) HandleExpect100() bool {
 	h.SendResponseOnly(utils.CONTINUE, "")
@@ -394,7 +641,11 @@ func (h *BaseHTTPRequestHandler) SendError(code utils.HTTPStatus, message string
 		message = shortMsg
 	}
 
-	talklog.Error(talklog.GID(), "错误响应: code %d, message %s", code, message)
+	if h.RequestID == "" {
+		// 请求头解析完成之前的错误
+		h.RequestID = requestID("")
+	}
+	talklog.Error(talklog.GID(), "错误响应: code %d, message %s, request %s", code, message, h.RequestID)
 
 	// 发送响应
 	h.SendResponse(code, message)
@@ -404,21 +655,14 @@ func (h *BaseHTTPRequestHandler) SendError(code utils.HTTPStatus, message string
 	// 某些状态码不需要消息体
 	var body []byte
 	if code >= 200 && code != utils.NO_CONTENT && code != utils.RESET_CONTENT && code != utils.NOT_MODIFIED {
-		// HTML编码以防止跨站脚本攻击
 		explain := longMsg
 		if len(args) > 0 {
 			explain = args[0]
 		}
 
-		content := fmt.Sprintf(h.ErrorMessageFormat,
-			code,
-			html.EscapeString(message),
-			code,
-			html.EscapeString(explain),
-		)
-
-		body = []byte(content)
-		h.SendHeader("Content-Type", h.ErrorContentType)
+		var contentType string
+		body, contentType = h.errorBody(code, shortMsg, message, explain)
+		h.SendHeader("Content-Type", contentType)
 		h.SendHeader("Content-Length", strconv.Itoa(len(body)))
 	}
 
@@ -442,6 +686,16 @@ func (h *BaseHTTPRequestHandler) SendResponse(code utils.HTTPStatus, message str
 	h.SendResponseOnly(code, message)
 	h.SendHeader("Server", h.VersionString())
 	h.SendHeader("Date", h.DateTimeString())
+	if h.RequestID != "" {
+		h.SendHeader("X-Request-ID", h.RequestID)
+	}
+	for _, kv := range h.ExtraHeaders {
+		h.SendHeader(kv[0], kv[1])
+	}
+	h.ExtraHeaders = nil
+	if h.forceClose && h.RequestVersion != "HTTP/0.9" {
+		h.HeadersBuffer = append(h.HeadersBuffer, []byte("Connection: close\r\n"))
+	}
 
 	talklog.Resp(talklog.GID(), int(code))
 }
@@ -468,8 +722,18 @@ func (h *BaseHTTPRequestHandler) SendResponseOnly(code utils.HTTPStatus, message
 
 }
 
+// AddResponseHeader 登记一个附加响应头，由下一次 SendResponse 发送
+// 用于 Allow、CORS 等需要附加到任意响应（包括 SendError）上的头
+func (h *BaseHTTPRequestHandler) AddResponseHeader(keyword, value string) {
+	h.ExtraHeaders = append(h.ExtraHeaders, [2]string{keyword, value})
+}
+
 // SendHeader 发送HTTP头
 func (h *BaseHTTPRequestHandler) SendHeader(keyword, value string) {
+	if h.forceClose && strings.EqualFold(keyword, "connection") {
+		// SendResponse 已经发送了 Connection: close，不能被覆盖
+		return
+	}
 	if h.RequestVersion != "HTTP/0.9" {
 		if h.HeadersBuffer == nil {
 			h.HeadersBuffer = make([][]byte, 0)
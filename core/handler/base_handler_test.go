@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+// serveRaw 把原始请求写入连接并运行 Handle，返回读到的响应与处理器被调用的次数
+func serveRaw(t *testing.T, raw string) ([]*http.Response, int) {
+	t.Helper()
+	server, client := net.Pipe()
+	h := NewBaseHTTPRequestHandler(server)
+	h.ProtocolVersion = "HTTP/1.1"
+	calls := 0
+	handle := func() {
+		calls++
+		io.Copy(io.Discard, h.Body)
+		h.SendResponse(utils.OK, "")
+		h.SendHeader("Content-Length", "0")
+		h.EndHeaders()
+	}
+	h.HandleMethod("GET", handle)
+	h.HandleMethod("POST", handle)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Handle()
+		server.Close()
+	}()
+	go client.Write([]byte(raw))
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	var responses []*http.Response
+	br := bufio.NewReader(client)
+	for {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			break
+		}
+		io.Copy(io.Discard, resp.Body)
+		responses = append(responses, resp)
+	}
+	client.Close()
+	<-done
+	return responses, calls
+}
+
+func TestKeepAlivePipelined(t *testing.T) {
+	responses, calls := serveRaw(t, "GET /a HTTP/1.1\r\nHost: x\r\n\r\n"+
+		"GET /b HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+	if calls != 2 || len(responses) != 2 {
+		t.Fatalf("served %d requests, %d responses; want 2", calls, len(responses))
+	}
+}
+
+func TestConflictingFramingClosesConnection(t *testing.T) {
+	// Content-Length 覆盖了后面“走私”的请求；以 Transfer-Encoding 为准时它是第二个请求
+	smuggled := "GET /smuggled HTTP/1.1\r\nHost: x\r\n\r\n"
+	raw := "POST / HTTP/1.1\r\nHost: x\r\n" +
+		"Transfer-Encoding: chunked\r\nContent-Length: 40\r\nConnection: keep-alive\r\n\r\n" +
+		"0\r\n\r\n" + smuggled
+	responses, calls := serveRaw(t, raw)
+	if calls != 1 || len(responses) != 1 {
+		t.Fatalf("served %d requests, %d responses; want only the first", calls, len(responses))
+	}
+	// ReadResponse 把 Connection: close 记录在 Close 中并从头部删除
+	if !responses[0].Close {
+		t.Fatal("response should carry Connection: close")
+	}
+}
@@ -3,7 +3,6 @@ package handler
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"net"
 	_ "net/url"
 	"os"
@@ -18,6 +17,7 @@ import (
 	"github.com/Singert/xjtu_cnlab/core/server"
 	"github.com/Singert/xjtu_cnlab/core/talklog"
 	"github.com/Singert/xjtu_cnlab/core/utils"
+	"github.com/Singert/xjtu_cnlab/core/vfs"
 )
 
 // CGIHTTPRequestHandler 实现CGI HTTP请求处理器
@@ -53,6 +53,14 @@ func (h *CGIHTTPRequestHandler) IsCGIScript() bool {
 	// 原始路径
 	originalPath := h.Path
 
+	// 0️⃣ 挂载路径只在挂载开启 CGI 时作为脚本运行，TranslatePath 会转换到挂载目录
+	if m, _ := matchMount(originalPath); m != nil {
+		if !m.CGI {
+			return false
+		}
+		isCGIScript = true
+	}
+
 	// 1️⃣ 原始路径中是否包含CGI目录
 	for _, dir := range h.CGIDirectoriesList {
 		containCheck := "/" + dir + "/"
@@ -65,9 +73,11 @@ func (h *CGIHTTPRequestHandler) IsCGIScript() bool {
 	// 2️⃣ 如果不是原始CGI路径，尝试映射：/xxx → /cgi-bin/xxx
 	if !isCGIScript && len(h.CGIDirectoriesList) > 0 {
 		mapped := filepath.Join("/", h.CGIDirectoriesList[0], originalPath)
-		filePath := filepath.Join(config.Cfg.Server.Workdir, mapped)
 
-		if fileInfo, err := os.Stat(filePath); err == nil {
+		// 与静态文件使用同一个解析器，拒绝越界与被禁止的路径
+		if filePath, err := h.TranslatePath(mapped); err != nil {
+			talklog.Warn(talklog.GID(), "Rejected CGI path %q: %v", mapped, err)
+		} else if fileInfo, err := os.Stat(filePath); err == nil {
 			if fileInfo.Mode()&0111 != 0 && !fileInfo.IsDir() {
 				// 是合法的可执行文件
 				h.Path = mapped
@@ -79,7 +89,11 @@ func (h *CGIHTTPRequestHandler) IsCGIScript() bool {
 	// 3️⃣ 保留原始逻辑结构：检查是否为可运行文件
 	if isCGIScript {
 		// check if the file is executable
-		filePath := filepath.Join(config.Cfg.Server.Workdir, h.Path)
+		filePath, err := h.TranslatePath(h.Path)
+		if err != nil {
+			talklog.Warn(talklog.GID(), "Rejected CGI path %q: %v", h.Path, err)
+			return false
+		}
 		fileInfo, err := os.Stat(filePath)
 		if err != nil {
 			return false
@@ -117,7 +131,7 @@ func (h *CGIHTTPRequestHandler) DoPOST() {
 }
 
 // sendCGIHeaders 发送CGI响应头
-func (h *CGIHTTPRequestHandler) SendHead() (*os.File, error) {
+func (h *CGIHTTPRequestHandler) SendHead() (vfs.File, error) {
 	// fmt.Println("SendHead in CGI!!!")
 	if h.IsCGIScript() {
 		h.RunCGI()
@@ -141,9 +155,27 @@ func (h *CGIHTTPRequestHandler) RunCGI() {
 	// 准备CGI环境变量
 	env := h.prepareCGIEnvironment(pathInfo)
 
+	// 读取请求体（Content-Length 或 chunked 解码后），CGI 需要确定的 CONTENT_LENGTH
+	var postData []byte
+	if h.HasBody() {
+		data, err := h.ReadBody(MaxBodySize())
+		if err == ErrBodyTooLarge {
+			talklog.Error(gid, "CGI request body exceeds %d bytes", MaxBodySize())
+			h.SendError(utils.REQUEST_ENTITY_TOO_LARGE, "Request body too large")
+			return
+		}
+		if err != nil {
+			talklog.Error(gid, "Error reading request body: %v", err)
+			h.SendError(utils.BAD_REQUEST, "Failed to read request body")
+			return
+		}
+		postData = data
+		talklog.Info(gid, "Read %d bytes of request body", len(postData))
+	}
+
 	// 添加 Content-Length 和 Content-Type 环境变量 (重要 for POST)
-	if contentLength, ok := h.Headers["Content-Length"]; ok {
-		env = append(env, fmt.Sprintf("CONTENT_LENGTH=%s", contentLength))
+	if h.HasBody() {
+		env = append(env, fmt.Sprintf("CONTENT_LENGTH=%d", len(postData)))
 	}
 	if contentType, ok := h.Headers["Content-Type"]; ok {
 		env = append(env, fmt.Sprintf("CONTENT_TYPE=%s", contentType))
@@ -153,44 +185,9 @@ func (h *CGIHTTPRequestHandler) RunCGI() {
 	talklog.Info(gid, "Executing CGI script: %s", scriptFile)
 	cmd := exec.Command(scriptFile)
 	cmd.Env = env
-
-	// --- Start: Handle POST data ---
-	var postData bytes.Buffer // Buffer to hold POST data if any
-	if h.Command == "POST" {
-		contentLengthStr := h.Headers["Content-Length"]
-		if contentLengthStr != "" {
-			contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
-			if err != nil {
-				talklog.Error(gid, "Invalid Content-Length: %v", err)
-				h.SendError(utils.BAD_REQUEST, "Invalid Content-Length header")
-				return
-			}
-			if contentLength > 0 {
-				// Read the POST data from the request body (h.RFile)
-				// Use io.LimitReader to avoid reading more than specified
-				lr := io.LimitReader(h.RFile, contentLength)
-				bytesRead, err := io.Copy(&postData, lr)
-				if err != nil && err != io.EOF {
-					talklog.Error(gid, "Error reading POST data: %v", err)
-					h.SendError(utils.INTERNAL_SERVER_ERROR, "Failed to read request body")
-					return
-				}
-				if bytesRead != contentLength {
-					talklog.Warn(gid, "POST data read (%d) differs from Content-Length (%d)", bytesRead, contentLength)
-					// Decide how to handle this: error or proceed? For now, proceed.
-				}
-				talklog.Info(gid, "Read %d bytes of POST data", bytesRead)
-				// Set the command's standard input to the buffered POST data
-				cmd.Stdin = &postData
-			}
-		} else {
-			// Handle POST requests with no Content-Length (e.g., chunked - less common for CGI)
-			// For simplicity, we might disallow this or read until EOF, which could be risky.
-			// Currently, we'll assume Content-Length is present for CGI POST.
-			talklog.Warn(gid, "POST request received without Content-Length")
-		}
+	if postData != nil {
+		cmd.Stdin = bytes.NewReader(postData)
 	}
-	// --- End ---
 
 	// 修改: 不直接写入到连接，而是捕获输出
 	output, err := cmd.CombinedOutput() // CombinedOutput reads stdout and stderr
@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// 分块编码解析时的各项上限，防止恶意客户端耗尽内存
+const (
+	maxChunkLineLength = 4096    // 单个 chunk-size 行（含扩展）的最大长度
+	maxTrailerBytes    = 1 << 16 // trailer 部分的最大总长度
+)
+
+var (
+	ErrMalformedChunk = errors.New("malformed chunked encoding")
+	ErrChunkLineLong  = errors.New("chunk size line too long")
+	ErrTrailerTooLong = errors.New("chunked trailer too large")
+)
+
+// ChunkedReader 解码 Transfer-Encoding: chunked 的请求体 (RFC 9112 7.1)
+//
+//	chunked-body = *chunk last-chunk trailer-section CRLF
+//	chunk        = chunk-size [ chunk-ext ] CRLF chunk-data CRLF
+//
+// 读到 last-chunk 后会继续解析 trailer，之后 Read 返回 io.EOF。
+type ChunkedReader struct {
+	r          *bufio.Reader
+	remaining  int64             // 当前 chunk 剩余未读字节
+	extensions map[string]string // 当前 chunk 的扩展参数
+	trailers   map[string]string // 请求尾部字段
+	total      int64             // 已解码的字节数
+	done       bool
+	err        error
+}
+
+// NewChunkedReader 创建分块解码器
+func NewChunkedReader(r *bufio.Reader) *ChunkedReader {
+	return &ChunkedReader{r: r}
+}
+
+// Read 实现 io.Reader，返回解码后的数据
+func (cr *ChunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.done {
+		return 0, io.EOF
+	}
+	if cr.remaining == 0 {
+		if err := cr.beginChunk(); err != nil {
+			cr.err = err
+			return 0, err
+		}
+		if cr.done {
+			return 0, io.EOF
+		}
+	}
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.remaining -= int64(n)
+	cr.total += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && cr.remaining == 0 {
+		// chunk-data 之后必须紧跟 CRLF
+		err = cr.readCRLF()
+	}
+	if err != nil {
+		cr.err = err
+	}
+	return n, err
+}
+
+// Extensions 返回当前（最近一次读取的）chunk 的扩展参数
+func (cr *ChunkedReader) Extensions() map[string]string {
+	return cr.extensions
+}
+
+// Trailers 返回 trailer 字段，只有在读到 io.EOF 之后才完整
+func (cr *ChunkedReader) Trailers() map[string]string {
+	return cr.trailers
+}
+
+// Total 返回目前已解码的字节数
+func (cr *ChunkedReader) Total() int64 {
+	return cr.total
+}
+
+// beginChunk 读取 chunk-size 行，遇到 last-chunk 时解析 trailer
+func (cr *ChunkedReader) beginChunk() error {
+	line, err := cr.readLine()
+	if err != nil {
+		return err
+	}
+
+	sizeStr, ext, _ := strings.Cut(line, ";")
+	sizeStr = strings.TrimRight(sizeStr, " \t")
+	if sizeStr == "" || len(sizeStr) > 16 || !isHex(sizeStr) {
+		return ErrMalformedChunk
+	}
+	size, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil || size < 0 {
+		return ErrMalformedChunk
+	}
+	cr.extensions, err = parseChunkExtensions(ext)
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		cr.done = true
+		return cr.readTrailers()
+	}
+	cr.remaining = size
+	return nil
+}
+
+// readLine 读取一行并去掉 CRLF，超过 maxChunkLineLength 视为错误
+func (cr *ChunkedReader) readLine() (string, error) {
+	var sb strings.Builder
+	for {
+		frag, isPrefix, err := cr.r.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		sb.Write(frag)
+		if sb.Len() > maxChunkLineLength {
+			return "", ErrChunkLineLong
+		}
+		if !isPrefix {
+			return sb.String(), nil
+		}
+	}
+}
+
+// readCRLF 消费 chunk-data 之后的 CRLF
+func (cr *ChunkedReader) readCRLF() error {
+	b, err := cr.r.ReadByte()
+	if err == nil && b == '\r' {
+		b, err = cr.r.ReadByte()
+	}
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if b != '\n' {
+		return ErrMalformedChunk
+	}
+	return nil
+}
+
+// readTrailers 解析 last-chunk 之后的 trailer-section
+// 逐行直接从 cr.r 读取，避免额外缓冲吞掉下一个 keep-alive 请求的数据
+func (cr *ChunkedReader) readTrailers() error {
+	cr.trailers = make(map[string]string)
+	size := 0
+	for {
+		line, err := cr.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+		size += len(line)
+		if size > maxTrailerBytes {
+			return ErrTrailerTooLong
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return ErrMalformedChunk
+		}
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		if forbiddenTrailer(key) {
+			// 禁止在 trailer 中出现的字段直接丢弃
+			continue
+		}
+		cr.trailers[key] = strings.TrimSpace(value)
+	}
+}
+
+// parseChunkExtensions 解析 chunk-ext = *( BWS ";" BWS ext-name [ BWS "=" BWS ext-val ] )
+func parseChunkExtensions(ext string) (map[string]string, error) {
+	if strings.TrimSpace(ext) == "" {
+		return nil, nil
+	}
+	result := make(map[string]string)
+	for _, item := range splitChunkExtensions(ext) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if name == "" {
+			return nil, ErrMalformedChunk
+		}
+		if strings.HasPrefix(value, "\"") {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad chunk extension %q", ErrMalformedChunk, item)
+			}
+			value = unquoted
+		}
+		result[strings.ToLower(name)] = value
+	}
+	return result, nil
+}
+
+// splitChunkExtensions 按分号切分扩展，忽略引号内的分号
+func splitChunkExtensions(s string) []string {
+	var parts []string
+	inQuote, escaped := false, false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && inQuote:
+			escaped = true
+		case c == '"':
+			inQuote = !inQuote
+		case c == ';' && !inQuote:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// forbiddenTrailer 报告该字段是否不允许出现在 trailer 中
+func forbiddenTrailer(key string) bool {
+	switch key {
+	case "Transfer-Encoding", "Content-Length", "Host", "Content-Type",
+		"Content-Encoding", "Content-Range", "Trailer", "Authorization",
+		"Cache-Control", "Expect", "Range", "Te", "Connection":
+		return true
+	}
+	return false
+}
+
+// ChunkedWriter 以 Transfer-Encoding: chunked 格式写出响应体
+// 每次 Write 产生一个 chunk，Close 写出 last-chunk
+type ChunkedWriter struct {
+	w io.Writer
+}
+
+// NewChunkedWriter 创建分块编码写入器
+func NewChunkedWriter(w io.Writer) *ChunkedWriter {
+	return &ChunkedWriter{w: w}
+}
+
+// Write 将 p 作为一个 chunk 写出，空切片不产生输出（避免被当作 last-chunk）
+func (cw *ChunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(cw.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := cw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(cw.w, "\r\n")
+	return n, err
+}
+
+// Close 写出 last-chunk 与空的 trailer-section
+func (cw *ChunkedWriter) Close() error {
+	_, err := io.WriteString(cw.w, "0\r\n\r\n")
+	return err
+}
+
+// isHex 报告 s 是否只由十六进制数字组成；ParseInt 会接受 "+1a" 这样带符号的写法
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+)
+
+// SupportedEncodings 服务器可以动态生成的内容编码，按优先顺序排列
+var SupportedEncodings = []string{"gzip", "deflate"}
+
+// 压缩相关的默认配置
+const (
+	defaultCompressMinSize = 1024
+	compressBufferSize     = 32 << 10
+)
+
+// DefaultCompressTypes 未配置 compression.Types 时允许压缩的 MIME 类型
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// parseAcceptEncoding 解析 Accept-Encoding，返回各编码的 q 值（编码名小写，x-gzip 视为 gzip）
+func parseAcceptEncoding(s string) map[string]float64 {
+	prefs := make(map[string]float64)
+	for _, item := range strings.Split(s, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && v >= 0 && v <= 1 {
+					q = v
+				} else {
+					q = 0
+				}
+			}
+		}
+		prefs[coding] = q
+	}
+	return prefs
+}
+
+// encodingQ 返回编码的 q 值，未列出时使用 * 的 q 值；ok 表示是否被显式或通过 * 提及
+func encodingQ(prefs map[string]float64, coding string) (float64, bool) {
+	if q, ok := prefs[coding]; ok {
+		return q, true
+	}
+	q, ok := prefs["*"]
+	return q, ok
+}
+
+// AcceptsEncoding 报告客户端是否接受指定编码（q > 0）
+func AcceptsEncoding(acceptEncoding, coding string) bool {
+	if acceptEncoding == "" {
+		return false
+	}
+	q, ok := encodingQ(parseAcceptEncoding(acceptEncoding), coding)
+	return ok && q > 0
+}
+
+// NegotiateEncoding 按 q 值从 offers 中选择内容编码，返回空字符串表示使用 identity
+//
+// q 值相同时按 offers 的顺序优先；identity 被显式给出更高的 q 值时不压缩
+func NegotiateEncoding(acceptEncoding string, offers []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	prefs := parseAcceptEncoding(acceptEncoding)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q, ok := encodingQ(prefs, offer); ok && q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	if q, ok := prefs["identity"]; ok && q > bestQ {
+		return ""
+	}
+	return best
+}
+
+// compressMinSize 返回值得压缩的最小文件大小
+func compressMinSize() int64 {
+	if config.Cfg.Compression.MinSize > 0 {
+		return config.Cfg.Compression.MinSize
+	}
+	return defaultCompressMinSize
+}
+
+// compressibleType 报告 MIME 类型是否在压缩白名单中，支持 "text/*" 形式的通配
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := config.Cfg.Compression.Types
+	if len(types) == 0 {
+		types = DefaultCompressTypes
+	}
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// newEncoder 创建内容编码器；HTTP 的 deflate 编码实际是 zlib 格式
+func newEncoder(coding string, w io.Writer) (io.WriteCloser, error) {
+	level := config.Cfg.Compression.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if coding == "deflate" {
+		return zlib.NewWriterLevel(w, level)
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// encodedResponse 记录 SendHead 选定的动态压缩响应，由 SendBody 流式输出
+type encodedResponse struct {
+	coding  string
+	chunked bool // HTTP/1.1 使用 chunked 分帧，HTTP/1.0 以关闭连接结束
+}
+
+// selectEncoding 为静态文件选择要发送的表示
+//
+//	coding  动态压缩使用的编码，空表示不动态压缩
+//	sidecar 存在可用的预压缩 .gz 文件时返回其位置与信息
+//
+// 区间请求、未开启压缩、类型不在白名单或文件太小时都发送原始内容
+func (h *SimpleHTTPRequestHandler) selectEncoding(sf staticFile, stat os.FileInfo) (coding string, sidecar *staticFile, sidecarStat os.FileInfo) {
+	if !config.Cfg.Server.IsGzip {
+		return "", nil, nil
+	}
+	// 区间只针对未压缩的原始内容，带 Range 的请求不压缩
+	if h.Command == "GET" && h.Headers["Range"] != "" {
+		return "", nil, nil
+	}
+	if h.IsGzip && config.Cfg.Compression.Precompressed {
+		if gz, err := h.locateStatic(sf.name + ".gz"); err == nil {
+			if gzStat, err := h.statStatic(gz); err == nil && gzStat.Mode().IsRegular() {
+				return "", &gz, gzStat
+			}
+		}
+	}
+	if h.ContentEncoding == "" || stat.Size() < compressMinSize() || !compressibleType(h.GuessType(sf.name)) {
+		return "", nil, nil
+	}
+	return h.ContentEncoding, nil, nil
+}
+
+// sendEncodedBody 边读文件边压缩输出
+func (h *SimpleHTTPRequestHandler) sendEncodedBody(src io.Reader, plan *encodedResponse) error {
+	// chunked 时合并编码器的零碎输出，避免产生大量小 chunk
+	return h.writeStream(plan.chunked, func(w io.Writer) error {
+		enc, err := newEncoder(plan.coding, w)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(enc, src); err != nil {
+			return err
+		}
+		return enc.Close()
+	})
+}
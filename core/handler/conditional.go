@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+// errResponseSent 表示 SendHead 已经发送了完整响应（如 304/412），调用方无需再输出
+var errResponseSent = errors.New("response already sent")
+
+// 内容哈希 ETag 的默认大小上限
+const defaultETagHashMaxSize = 16 << 20
+
+// etagKey 内容哈希缓存的键，文件被修改后 inode/大小/修改时间至少有一项变化
+type etagKey struct {
+	path  string
+	inode uint64
+	size  int64
+	mtime int64
+}
+
+var (
+	etagCacheMu sync.Mutex
+	etagCache   = make(map[etagKey]string)
+)
+
+func init() {
+	config.OnReload(func() {
+		etagCacheMu.Lock()
+		etagCache = make(map[etagKey]string)
+		etagCacheMu.Unlock()
+	})
+}
+
+// FileETag 生成静态文件的强 ETag
+// 默认由 inode、大小、修改时间组成；配置 etag.Hash 后使用内容的 SHA-256（缓存结果）
+func FileETag(path string, fi os.FileInfo) string {
+	key := etagKey{path: path, inode: fileInode(fi), size: fi.Size(), mtime: fi.ModTime().UnixNano()}
+	statTag := statETag(key.inode, fi)
+
+	maxSize := config.Cfg.ETag.HashMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultETagHashMaxSize
+	}
+	if !config.Cfg.ETag.Hash || fi.Size() > maxSize {
+		return statTag
+	}
+
+	etagCacheMu.Lock()
+	tag, ok := etagCache[key]
+	etagCacheMu.Unlock()
+	if ok {
+		return tag
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return statTag
+	}
+	defer f.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		talklog.Warn(talklog.GID(), "Hashing %s for ETag failed: %v", path, err)
+		return statTag
+	}
+	tag = `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`
+
+	etagCacheMu.Lock()
+	// 同一路径只保留最新的一条记录
+	for k := range etagCache {
+		if k.path == path {
+			delete(etagCache, k)
+		}
+	}
+	etagCache[key] = tag
+	etagCacheMu.Unlock()
+	return tag
+}
+
+// statETag 由 inode、大小与修改时间组成 ETag
+func statETag(inode uint64, fi os.FileInfo) string {
+	return `"` + strconv.FormatUint(inode, 16) + "-" +
+		strconv.FormatInt(fi.Size(), 16) + "-" + strconv.FormatInt(fi.ModTime().UnixNano(), 16) + `"`
+}
+
+// WeakETag 根据生成的内容计算弱 ETag，用于目录列表等动态内容
+func WeakETag(content []byte) string {
+	sum := fnv.New64a()
+	sum.Write(content)
+	return `W/"` + strconv.FormatUint(sum.Sum64(), 16) + `"`
+}
+
+// VariantETag 为内容编码后的表示生成不同的 ETag，如 "abc" -> "abc-gzip"
+func VariantETag(etag, coding string) string {
+	if etag == "" || coding == "" {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + coding + `"`
+}
+
+// ParseHTTPTime 解析 HTTP 日期，兼容本服务器 Last-Modified 使用的 RFC1123 UTC 格式
+func ParseHTTPTime(s string) (time.Time, error) {
+	t, err := http.ParseTime(s)
+	if err != nil {
+		t, err = time.Parse(time.RFC1123, s)
+	}
+	return t.UTC(), err
+}
+
+// parseETagList 解析 If-Match / If-None-Match 中以逗号分隔的实体标签
+func parseETagList(s string) []string {
+	var tags []string
+	for s = strings.TrimLeft(s, " \t"); s != ""; s = strings.TrimLeft(s, " \t") {
+		if s[0] == ',' {
+			s = s[1:]
+			continue
+		}
+		if s[0] == '*' {
+			tags = append(tags, "*")
+			s = s[1:]
+			continue
+		}
+		start := 0
+		if strings.HasPrefix(s, "W/") {
+			start = 2
+		}
+		if len(s) <= start || s[start] != '"' {
+			// 格式错误，丢弃剩余部分
+			break
+		}
+		end := strings.IndexByte(s[start+1:], '"')
+		if end < 0 {
+			break
+		}
+		end += start + 2
+		tags = append(tags, s[:end])
+		s = s[end:]
+	}
+	return tags
+}
+
+// etagStrongMatch 强比较：两者都不能是弱标签
+func etagStrongMatch(a, b string) bool {
+	return a == b && a != "" && !strings.HasPrefix(a, "W/")
+}
+
+// etagWeakMatch 弱比较：忽略 W/ 前缀
+func etagWeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/") && a != ""
+}
+
+// conditionResult 前置条件的评估结果
+type conditionResult int
+
+const (
+	condNone        conditionResult = iota // 继续正常处理
+	condNotModified                        // 应答 304
+	condFailed                             // 应答 412
+)
+
+// EvaluatePreconditions 按 RFC 9110 13.2.2 的顺序评估条件请求头
+//
+//  1. If-Match，不成立时 412
+//  2. 无 If-Match 时 If-Unmodified-Since，不成立时 412
+//  3. If-None-Match，不成立时 GET/HEAD 为 304，其他方法为 412
+//  4. 无 If-None-Match 的 GET/HEAD 评估 If-Modified-Since，不成立时 304
+//
+// etag 为空表示资源不存在或没有 ETag；modTime 为零值表示没有修改时间
+// If-Range 由区间处理单独评估
+func (h *BaseHTTPRequestHandler) EvaluatePreconditions(etag string, modTime time.Time) conditionResult {
+	modTime = modTime.UTC().Truncate(time.Second)
+	safe := h.Command == "GET" || h.Command == "HEAD"
+
+	if im, ok := h.Headers["If-Match"]; ok {
+		matched := false
+		for _, tag := range parseETagList(im) {
+			if tag == "*" && etag != "" || etagStrongMatch(tag, etag) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return condFailed
+		}
+	} else if ius := h.Headers["If-Unmodified-Since"]; ius != "" && !modTime.IsZero() {
+		if t, err := ParseHTTPTime(ius); err == nil && modTime.After(t) {
+			return condFailed
+		}
+	}
+
+	if inm, ok := h.Headers["If-None-Match"]; ok {
+		for _, tag := range parseETagList(inm) {
+			if tag == "*" && etag != "" || etagWeakMatch(tag, etag) {
+				if safe {
+					return condNotModified
+				}
+				return condFailed
+			}
+		}
+	} else if ims := h.Headers["If-Modified-Since"]; ims != "" && safe && !modTime.IsZero() {
+		if t, err := ParseHTTPTime(ims); err == nil && !modTime.After(t) {
+			return condNotModified
+		}
+	}
+	return condNone
+}
+
+// SendPreconditionResult 应答 304/412，返回是否已经应答
+// 304 只携带校验器及 Vary，不包含表示的元数据
+func (h *BaseHTTPRequestHandler) SendPreconditionResult(result conditionResult, etag string, vary string) bool {
+	switch result {
+	case condNotModified:
+		h.SendResponse(utils.NOT_MODIFIED, "")
+		if etag != "" {
+			h.SendHeader("ETag", etag)
+		}
+		if vary != "" {
+			h.SendHeader("Vary", vary)
+		}
+		h.EndHeaders()
+		talklog.Info(talklog.GID(), "Not modified: %s", h.Path)
+		return true
+	case condFailed:
+		if etag != "" {
+			h.AddResponseHeader("ETag", etag)
+		}
+		h.SendError(utils.PRECONDITION_FAILED, "")
+		return true
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+	"github.com/Singert/xjtu_cnlab/core/vfs"
+)
+
+// sharedRoot 已打开的文档根或挂载的文件系统
+// 请求经由 useRoot 持有引用，配置变化后被替换的文件系统等到引用全部释放才关闭，
+// 避免正在发送的归档文件被关闭（Content-Length 已经发出，响应会被截断）
+type sharedRoot struct {
+	fsys    fs.FS
+	name    string // 用于日志
+	refs    int
+	retired bool
+}
+
+// rootsMu 保护所有 sharedRoot 的 refs 与 retired
+var rootsMu sync.Mutex
+
+// acquire 增加引用；调用方持有选出该文件系统时使用的锁（docrootMu 或 mountsMu），保证它尚未被替换
+func (r *sharedRoot) acquire() {
+	rootsMu.Lock()
+	r.refs++
+	rootsMu.Unlock()
+}
+
+// release 释放引用，已被替换且没有其他引用时关闭
+func (r *sharedRoot) release() {
+	rootsMu.Lock()
+	r.refs--
+	done := r.retired && r.refs == 0
+	rootsMu.Unlock()
+	if done {
+		r.close()
+	}
+}
+
+// retire 标记文件系统已被替换，没有引用时立即关闭，否则由最后一个 release 关闭
+func (r *sharedRoot) retire() {
+	rootsMu.Lock()
+	r.retired = true
+	done := r.refs == 0
+	rootsMu.Unlock()
+	if done {
+		r.close()
+	}
+}
+
+func (r *sharedRoot) close() {
+	if err := vfs.Close(r.fsys); err != nil {
+		talklog.Warn(talklog.GID(), "Cannot close %s: %v", r.name, err)
+	}
+}
+
+var (
+	docrootMu       sync.Mutex
+	docrootKey      string
+	docrootRoot     *sharedRoot
+	docrootOverride *sharedRoot
+)
+
+// SetDocroot 以程序方式指定文档根（如测试中的 vfs.MemFS），nil 恢复使用配置
+// 指定的文件系统由调用方负责关闭
+func SetDocroot(fsys fs.FS) {
+	docrootMu.Lock()
+	defer docrootMu.Unlock()
+	docrootOverride = nil
+	if fsys != nil {
+		docrootOverride = &sharedRoot{fsys: fsys, name: "docroot"}
+	}
+}
+
+// Docroot 返回静态文件层使用的文档根
+// 按 server.Docroot 的来源叠加，为空时为 Workdir 目录；配置变化（含热加载）后重新打开，
+// 打开失败时记录错误并退回 Workdir 目录
+// 返回值不持有引用，配置变化后可能被关闭；处理请求时经由 useRoot 使用
+func Docroot() fs.FS {
+	docrootMu.Lock()
+	defer docrootMu.Unlock()
+	return currentDocroot().fsys
+}
+
+// acquireDocroot 返回文档根并增加其引用，用完后调用 release
+func acquireDocroot() *sharedRoot {
+	docrootMu.Lock()
+	defer docrootMu.Unlock()
+	r := currentDocroot()
+	r.acquire()
+	return r
+}
+
+// currentDocroot 返回当前的文档根，配置变化时替换旧的文档根，调用方持有 docrootMu
+func currentDocroot() *sharedRoot {
+	if docrootOverride != nil {
+		return docrootOverride
+	}
+	specs := config.Cfg.Server.Docroot
+	key := config.Cfg.Server.Workdir + "\x00" + strings.Join(specs, "\x00")
+	if docrootRoot != nil && key == docrootKey {
+		return docrootRoot
+	}
+	if docrootRoot != nil {
+		docrootRoot.retire()
+	}
+	docrootKey = key
+	docrootRoot = &sharedRoot{fsys: vfs.Dir(config.Cfg.Server.Workdir), name: "docroot"}
+	if len(specs) > 0 {
+		fsys, err := vfs.OpenLayers(specs)
+		if err != nil {
+			talklog.Error(talklog.GID(), "Cannot open docroot, serving %s instead: %v", config.Cfg.Server.Workdir, err)
+		} else {
+			docrootRoot.fsys = fsys
+			talklog.Info(talklog.GID(), "Docroot: %s", strings.Join(specs, " + "))
+		}
+	}
+	return docrootRoot
+}
+
+// staticFile 静态资源在文档根中的位置
+// name 为 fs.FS 中的名称；osPath 非空表示文件位于本地目录，可以使用 sendfile，cache 为真时还可以使用文件缓存
+type staticFile struct {
+	name   string
+	osPath string
+	cache  bool
+}
+
+// cachePath 返回用于文件缓存的路径，不能使用缓存时为空
+func (sf staticFile) cachePath() string {
+	if !sf.cache {
+		return ""
+	}
+	return sf.osPath
+}
+
+// fsName 把 URL 路径转换为 fs.FS 中的名称，根目录为 "."
+func fsName(urlPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// locateStatic 检查 name 并确定其本地位置：拒绝 deny 列表中的名称，本地文件还要符合符号链接策略
+func (h *SimpleHTTPRequestHandler) locateStatic(name string) (staticFile, error) {
+	if name != "." && pathDenied(name) {
+		return staticFile{}, ErrPathDenied
+	}
+	sf := staticFile{name: name}
+	if root, osPath, ok := vfs.Locate(h.FS, name); ok {
+		if err := checkSymlinks(root, osPath); err != nil {
+			return staticFile{}, err
+		}
+		sf.osPath = osPath
+		sf.cache = h.mount == nil || h.mount.cacheAllowed()
+	}
+	return sf, nil
+}
+
+// resolveStatic 把请求路径解析为文档根中的资源，失败时应答 400 或 403 并返回 false
+func (h *SimpleHTTPRequestHandler) resolveStatic(urlPath string) (staticFile, bool) {
+	var sf staticFile
+	err := ErrInvalidPath
+	if !strings.ContainsAny(urlPath, "\x00\\") {
+		sf, err = h.locateStatic(fsName(urlPath))
+	}
+	if err == nil {
+		return sf, true
+	}
+	talklog.Warn(talklog.GID(), "Rejected path %q: %v", urlPath, err)
+	if errors.Is(err, ErrInvalidPath) {
+		h.SendError(utils.BAD_REQUEST, "Invalid characters in path")
+	} else {
+		h.SendError(utils.FORBIDDEN, "Access denied")
+	}
+	return staticFile{}, false
+}
+
+// staticAllowed 报告目录项是否可以出现在列表与归档中
+func (h *SimpleHTTPRequestHandler) staticAllowed(name string) bool {
+	_, err := h.locateStatic(name)
+	return err == nil
+}
+
+// statStatic 返回资源信息（跟随符号链接），本地文件经由文件缓存
+func (h *SimpleHTTPRequestHandler) statStatic(sf staticFile) (fs.FileInfo, error) {
+	if sf.cache {
+		return statFile(sf.osPath)
+	}
+	if sf.osPath != "" {
+		return os.Stat(sf.osPath)
+	}
+	return fs.Stat(h.FS, sf.name)
+}
+
+// openStatic 打开资源，本地文件返回 *os.File 以便走 sendfile
+func (h *SimpleHTTPRequestHandler) openStatic(sf staticFile) (vfs.File, error) {
+	if sf.osPath != "" {
+		f, err := os.Open(sf.osPath)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	return vfs.OpenFile(h.FS, sf.name)
+}
+
+// staticETag 生成资源的强 ETag，归档、embed 等非本地文件没有 inode，只用大小与修改时间
+func (h *SimpleHTTPRequestHandler) staticETag(sf staticFile, fi fs.FileInfo) string {
+	if sf.osPath != "" {
+		return FileETag(sf.osPath, fi)
+	}
+	return statETag(0, fi)
+}
+
+// defaultIndexFiles 目录的默认索引文件，按顺序查找
+var defaultIndexFiles = []string{"index.html", "index.htm", "index"}
+
+// indexFiles 返回当前文档根的索引文件列表：挂载的设置优先于 static.Index
+func (h *SimpleHTTPRequestHandler) indexFiles() []string {
+	if h.mount != nil && len(h.mount.Index) > 0 {
+		return h.mount.Index
+	}
+	if len(config.Cfg.Static.Index) > 0 {
+		return config.Cfg.Static.Index
+	}
+	return defaultIndexFiles
+}
+
+// findIndex 在目录中按 indexFiles 的顺序查找索引文件
+func (h *SimpleHTTPRequestHandler) findIndex(dir staticFile) (staticFile, fs.FileInfo, bool) {
+	for _, index := range h.indexFiles() {
+		sf, err := h.locateStatic(path.Join(dir.name, index))
+		if err != nil {
+			continue
+		}
+		if fi, err := h.statStatic(sf); err == nil && !fi.IsDir() {
+			return sf, fi, true
+		}
+	}
+	return staticFile{}, nil, false
+}
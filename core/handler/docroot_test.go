@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+)
+
+// writeZip 创建只含 index.html 的 ZIP 归档
+func writeZip(t *testing.T, name, content string) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, content)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// withDocroot 临时使用 specs 作为文档根，结束时关闭打开的文档根
+func withDocroot(t *testing.T, workdir string, specs ...string) {
+	t.Helper()
+	saved := config.Cfg.Server
+	t.Cleanup(func() {
+		config.Cfg.Server = saved
+		docrootMu.Lock()
+		if docrootRoot != nil {
+			docrootRoot.retire()
+		}
+		docrootRoot, docrootKey = nil, ""
+		docrootMu.Unlock()
+	})
+	config.Cfg.Server.Workdir = workdir
+	config.Cfg.Server.Docroot = specs
+}
+
+func TestDocrootReplacedWhileServing(t *testing.T) {
+	dir := t.TempDir()
+	v1, v2 := filepath.Join(dir, "v1.zip"), filepath.Join(dir, "v2.zip")
+	writeZip(t, v1, "one")
+	writeZip(t, v2, "two")
+	withDocroot(t, dir, "zip:"+v1)
+
+	h := &SimpleHTTPRequestHandler{BaseHTTPRequestHandler: &BaseHTTPRequestHandler{}}
+	h.useRoot("/index.html")
+	old := h.FS
+
+	// 请求仍在使用旧的文档根时配置发生变化
+	config.Cfg.Server.Docroot = []string{"zip:" + v2}
+	next := acquireDocroot()
+	if got, err := fs.ReadFile(next.fsys, "index.html"); err != nil || string(got) != "two" {
+		t.Fatalf("new docroot: ReadFile = %q, %v", got, err)
+	}
+	if got, err := fs.ReadFile(old, "index.html"); err != nil || string(got) != "one" {
+		t.Fatalf("replaced docroot should stay open while in use: ReadFile = %q, %v", got, err)
+	}
+
+	// 最后一个引用释放后关闭旧的文档根，新的不受影响
+	h.requestDone()
+	if _, err := fs.ReadFile(old, "index.html"); err == nil {
+		t.Fatal("replaced docroot should be closed after the request is done")
+	}
+	next.release()
+	if got, err := fs.ReadFile(Docroot(), "index.html"); err != nil || string(got) != "two" {
+		t.Fatalf("current docroot: ReadFile = %q, %v", got, err)
+	}
+}
+
+// withMounts 临时使用 list 作为挂载配置，结束时关闭打开的挂载
+func withMounts(t *testing.T, list ...config.Mount) {
+	t.Helper()
+	saved := config.Cfg.Mounts
+	t.Cleanup(func() {
+		config.Cfg.Mounts = nil
+		reloadMounts()
+		mounts()
+		config.Cfg.Mounts = saved
+		reloadMounts()
+	})
+	config.Cfg.Mounts = list
+	reloadMounts()
+}
+
+// reloadMounts 与配置热加载相同，下次使用时重建挂载点
+func reloadMounts() {
+	mountsMu.Lock()
+	mountsLoaded = false
+	mountsMu.Unlock()
+}
+
+func TestMountsReloadedWhileServing(t *testing.T) {
+	dir := t.TempDir()
+	docs, v1, v2 := filepath.Join(dir, "docs.zip"), filepath.Join(dir, "v1.zip"), filepath.Join(dir, "v2.zip")
+	writeZip(t, docs, "docs")
+	writeZip(t, v1, "one")
+	writeZip(t, v2, "two")
+	withMounts(t,
+		config.Mount{Prefix: "/docs", Source: "zip:" + docs},
+		config.Mount{Prefix: "/app", Source: "zip:" + v1},
+	)
+
+	h := &SimpleHTTPRequestHandler{BaseHTTPRequestHandler: &BaseHTTPRequestHandler{}}
+	h.useRoot("/app/index.html")
+	app := h.FS
+	docsRoot, _ := matchMount("/docs/")
+
+	// 请求仍在使用 /app 时重新加载：/docs 的来源不变，只改了设置
+	listing := false
+	config.Cfg.Mounts = []config.Mount{
+		{Prefix: "/docs", Source: "zip:" + docs, Listing: &listing},
+		{Prefix: "/app", Source: "zip:" + v2},
+	}
+	reloadMounts()
+	m, _ := matchMount("/docs/")
+	if m.root != docsRoot.root || m.listingAllowed() {
+		t.Fatal("a mount with an unchanged source should keep its file system and pick up the new settings")
+	}
+	if got, err := fs.ReadFile(app, "index.html"); err != nil || string(got) != "one" {
+		t.Fatalf("replaced mount should stay open while in use: ReadFile = %q, %v", got, err)
+	}
+	if m, _ := matchMount("/app/"); m == nil {
+		t.Fatal("/app is not mounted")
+	} else if got, err := fs.ReadFile(m.root.fsys, "index.html"); err != nil || string(got) != "two" {
+		t.Fatalf("new mount: ReadFile = %q, %v", got, err)
+	}
+
+	h.requestDone()
+	if _, err := fs.ReadFile(app, "index.html"); err == nil {
+		t.Fatal("replaced mount should be closed after the request is done")
+	}
+	if got, err := fs.ReadFile(m.root.fsys, "index.html"); err != nil || string(got) != "docs" {
+		t.Fatalf("reused mount: ReadFile = %q, %v", got, err)
+	}
+}
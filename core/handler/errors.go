@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+// ProblemContentType RFC 9457 错误响应的类型
+const ProblemContentType = "application/problem+json"
+
+// maxRequestIDLen 沿用客户端提供的 X-Request-ID 的长度上限
+const maxRequestIDLen = 128
+
+// requestID 沿用客户端（或前置代理）提供的 X-Request-ID，不合法或没有时生成一个随机 ID
+func requestID(given string) string {
+	if given != "" && len(given) <= maxRequestIDLen && strings.IndexFunc(given, func(r rune) bool {
+		return r <= ' ' || r >= 0x7f
+	}) < 0 {
+		return given
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ErrorPage 错误页模板可以使用的数据
+type ErrorPage struct {
+	Code      int       // 状态码
+	Status    string    // 状态码的标准短语，如 "Not Found"
+	Message   string    // 状态行中的消息
+	Explain   string    // 详细说明
+	Method    string    // 请求方法
+	Path      string    // 请求路径
+	RequestID string    // 请求 ID，与 X-Request-ID 响应头相同
+	Server    string    // 服务器版本
+	Time      time.Time // 出错的时间
+}
+
+// problem RFC 9457 problem details
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// errorBody 生成错误响应的内容及其类型，按以下顺序选择格式：
+//  1. 路由分组设置的错误格式（h.ErrorMessageFormat 不是默认值）
+//  2. 开启 errors.ProblemJSON 且客户端偏好 JSON 时的 application/problem+json
+//  3. errors.Pages 目录中与状态码对应的模板
+//  4. 内置的错误页
+func (h *BaseHTTPRequestHandler) errorBody(code utils.HTTPStatus, status, message, explain string) ([]byte, string) {
+	if h.ErrorMessageFormat != utils.DefaultErrorMessageFormat {
+		return []byte(formatError(h.ErrorMessageFormat, h.ErrorContentType, code, message, explain)), h.ErrorContentType
+	}
+	if config.Cfg.Errors.ProblemJSON && acceptsJSON(h.Headers["Accept"]) {
+		// 没有专门的说明时，比标准短语更具体的消息更有用
+		detail := explain
+		if msgs, ok := utils.StatusMessages[code]; !ok || explain == msgs[1] && message != status {
+			detail = message
+		}
+		body, _ := json.Marshal(problem{
+			Type:      "about:blank",
+			Title:     status,
+			Status:    int(code),
+			Detail:    detail,
+			Instance:  h.Path,
+			RequestID: h.RequestID,
+		})
+		return body, ProblemContentType
+	}
+	if tmpl := errorTemplate(code); tmpl != nil {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, &ErrorPage{
+			Code:      int(code),
+			Status:    status,
+			Message:   message,
+			Explain:   explain,
+			Method:    h.Command,
+			Path:      h.Path,
+			RequestID: h.RequestID,
+			Server:    h.VersionString(),
+			Time:      time.Now(),
+		})
+		if err == nil {
+			return buf.Bytes(), "text/html; charset=utf-8"
+		}
+		talklog.Error(talklog.GID(), "Error page template %s failed: %v", tmpl.Name(), err)
+	}
+	return []byte(formatError(utils.DefaultErrorMessageFormat, utils.DefaultErrorContentType, code, message, explain)), utils.DefaultErrorContentType
+}
+
+// formatError 按 ErrorMessageFormat 的占位符（状态码、消息、状态码、说明）填充，
+// 消息与说明按内容类型转义（JSON 或 HTML）以防止注入
+func formatError(format, contentType string, code utils.HTTPStatus, message, explain string) string {
+	escape := html.EscapeString
+	if strings.Contains(contentType, "json") {
+		escape = func(s string) string {
+			b, _ := json.Marshal(s)
+			return string(b[1 : len(b)-1])
+		}
+	}
+	return fmt.Sprintf(format, code, escape(message), code, escape(explain))
+}
+
+// ---- 错误页模板 ----
+
+type errorTemplateEntry struct {
+	modTime time.Time
+	tmpl    *template.Template
+}
+
+var (
+	errorTemplatesMu sync.Mutex
+	errorTemplates   = make(map[string]errorTemplateEntry)
+)
+
+func init() {
+	config.OnReload(func() {
+		errorTemplatesMu.Lock()
+		errorTemplates = make(map[string]errorTemplateEntry)
+		errorTemplatesMu.Unlock()
+	})
+}
+
+// errorTemplate 返回状态码对应的错误页模板：依次查找 404.html、4xx.html、error.html
+// 模板按修改时间缓存，文件修改后重新解析；没有可用的模板时返回 nil
+func errorTemplate(code utils.HTTPStatus) *template.Template {
+	dir := config.Cfg.Errors.Pages
+	if dir == "" {
+		return nil
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(config.Cfg.Server.Workdir, dir)
+	}
+	names := []string{fmt.Sprintf("%d.html", code), fmt.Sprintf("%dxx.html", code/100), "error.html"}
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		fi, err := os.Stat(p)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+
+		errorTemplatesMu.Lock()
+		e, ok := errorTemplates[p]
+		errorTemplatesMu.Unlock()
+		if ok && e.modTime.Equal(fi.ModTime()) {
+			return e.tmpl
+		}
+		tmpl, err := template.ParseFiles(p)
+		if err != nil {
+			talklog.Error(talklog.GID(), "Cannot parse error page %s: %v", p, err)
+			continue
+		}
+		errorTemplatesMu.Lock()
+		errorTemplates[p] = errorTemplateEntry{modTime: fi.ModTime(), tmpl: tmpl}
+		errorTemplatesMu.Unlock()
+		return tmpl
+	}
+	return nil
+}
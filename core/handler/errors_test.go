@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+// withErrorsConfig 临时替换 errors 配置
+func withErrorsConfig(t *testing.T, pages string, problemJSON bool) {
+	t.Helper()
+	saved := config.Cfg.Errors
+	t.Cleanup(func() { config.Cfg.Errors = saved })
+	config.Cfg.Errors.Pages = pages
+	config.Cfg.Errors.ProblemJSON = problemJSON
+}
+
+// newErrorHandler 返回只用于输出错误响应的处理器
+func newErrorHandler(accept string) *BaseHTTPRequestHandler {
+	h := &BaseHTTPRequestHandler{
+		Command:            "GET",
+		Path:               "/missing",
+		RequestVersion:     "HTTP/1.1",
+		ProtocolVersion:    "HTTP/1.1",
+		Headers:            map[string]string{},
+		RequestID:          "req-1",
+		ErrorMessageFormat: utils.DefaultErrorMessageFormat,
+		ErrorContentType:   utils.DefaultErrorContentType,
+	}
+	if accept != "" {
+		h.Headers["Accept"] = accept
+	}
+	return h
+}
+
+// sendError 调用 SendError 并解析写出的响应
+func sendError(t *testing.T, h *BaseHTTPRequestHandler, code utils.HTTPStatus, message string, args ...string) (*http.Response, string) {
+	t.Helper()
+	var out bytes.Buffer
+	h.WFile = bufio.NewWriter(&out)
+	h.SendError(code, message, args...)
+	h.WFile.Flush()
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	if err != nil {
+		t.Fatalf("cannot parse response: %v\n%s", err, out.String())
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != int(code) {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, code)
+	}
+	return resp, string(body)
+}
+
+func TestErrorProblemJSONNegotiation(t *testing.T) {
+	tests := []struct {
+		accept  string
+		enabled bool
+		problem bool
+	}{
+		{"", true, false},
+		{"text/html", true, false},
+		{"*/*", true, false},
+		{"application/json", true, true},
+		{"application/problem+json", true, true},
+		{"application/json, text/html;q=0.5", true, true},
+		{"text/html, application/json;q=0.9", true, false},
+		{"application/json;q=0.5, */*", true, false},
+		{"application/json", false, false},
+	}
+	for _, tt := range tests {
+		withErrorsConfig(t, "", tt.enabled)
+		resp, _ := sendError(t, newErrorHandler(tt.accept), utils.NOT_FOUND, "")
+		got := resp.Header.Get("Content-Type") == ProblemContentType
+		if got != tt.problem {
+			t.Errorf("Accept %q (ProblemJSON %v): Content-Type = %q, want problem+json %v",
+				tt.accept, tt.enabled, resp.Header.Get("Content-Type"), tt.problem)
+		}
+	}
+}
+
+func TestErrorProblemJSONBody(t *testing.T) {
+	withErrorsConfig(t, "", true)
+	tests := []struct {
+		message, explain string
+		detail           string
+	}{
+		// 没有专门的说明时使用更具体的消息
+		{"No such page", "", "No such page"},
+		{"", "Nothing here", "Nothing here"},
+		{"No such page", "Nothing here", "Nothing here"},
+	}
+	for _, tt := range tests {
+		var args []string
+		if tt.explain != "" {
+			args = append(args, tt.explain)
+		}
+		resp, body := sendError(t, newErrorHandler("application/json"), utils.NOT_FOUND, tt.message, args...)
+		var p problem
+		if err := json.Unmarshal([]byte(body), &p); err != nil {
+			t.Fatalf("invalid problem+json %q: %v", body, err)
+		}
+		want := problem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: tt.detail, Instance: "/missing", RequestID: "req-1"}
+		if p != want {
+			t.Errorf("SendError(%q, %q) = %+v, want %+v", tt.message, tt.explain, p, want)
+		}
+		if got := resp.Header.Get("X-Request-Id"); got != "req-1" {
+			t.Errorf("X-Request-ID = %q, want req-1", got)
+		}
+	}
+}
+
+func TestErrorTemplateFallback(t *testing.T) {
+	dir := t.TempDir()
+	pages := map[string]string{
+		"404.html":   "page {{.Code}}",
+		"4xx.html":   "group {{.Code}}",
+		"5xx.html":   "broken {{.Code",
+		"error.html": "generic {{.Code}} {{.Message}}",
+	}
+	for name, src := range pages {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	withErrorsConfig(t, dir, true)
+
+	tests := []struct {
+		code    utils.HTTPStatus
+		message string
+		want    string
+	}{
+		{utils.NOT_FOUND, "", "page 404"},
+		{utils.FORBIDDEN, "", "group 403"},
+		// 无法解析的 5xx.html 被跳过
+		{utils.INTERNAL_SERVER_ERROR, "", "generic 500 Internal Server Error"},
+		{utils.BAD_GATEWAY, "<script>", "generic 502 &lt;script&gt;"},
+	}
+	for _, tt := range tests {
+		resp, body := sendError(t, newErrorHandler("text/html"), tt.code, tt.message)
+		if body != tt.want {
+			t.Errorf("%d: body = %q, want %q", tt.code, body, tt.want)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("%d: Content-Type = %q", tt.code, ct)
+		}
+	}
+
+	// 删除更具体的模板后依次退回
+	os.Remove(filepath.Join(dir, "404.html"))
+	if _, body := sendError(t, newErrorHandler(""), utils.NOT_FOUND, ""); body != "group 404" {
+		t.Errorf("without 404.html: body = %q, want group 404", body)
+	}
+	os.Remove(filepath.Join(dir, "4xx.html"))
+	if _, body := sendError(t, newErrorHandler(""), utils.NOT_FOUND, ""); body != "generic 404 Not Found" {
+		t.Errorf("without 4xx.html: body = %q, want generic 404", body)
+	}
+	os.Remove(filepath.Join(dir, "error.html"))
+	if _, body := sendError(t, newErrorHandler(""), utils.NOT_FOUND, ""); !strings.Contains(body, "Error code: 404") {
+		t.Errorf("without templates: body = %q, want the built-in page", body)
+	}
+
+	// 客户端偏好 JSON 时 problem+json 优先于模板
+	if resp, _ := sendError(t, newErrorHandler("application/json"), utils.FORBIDDEN, ""); resp.Header.Get("Content-Type") != ProblemContentType {
+		t.Errorf("problem+json should take precedence over templates")
+	}
+}
+
+func TestErrorGroupFormatEscaping(t *testing.T) {
+	withErrorsConfig(t, "", true)
+	message := `bad "quote" </script><b>`
+	explain := "line\nbreak & \\  "
+
+	t.Run("json", func(t *testing.T) {
+		h := newErrorHandler("text/html")
+		h.ErrorMessageFormat = `{"code":%d,"message":"%s","status":%d,"explain":"%s"}`
+		h.ErrorContentType = "application/json"
+		resp, body := sendError(t, h, utils.BAD_REQUEST, message, explain)
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		var got struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Status  int    `json:"status"`
+			Explain string `json:"explain"`
+		}
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Fatalf("invalid JSON %q: %v", body, err)
+		}
+		if got.Code != 400 || got.Status != 400 || got.Message != message || got.Explain != explain {
+			t.Errorf("decoded %+v", got)
+		}
+	})
+
+	t.Run("html", func(t *testing.T) {
+		h := newErrorHandler("application/json") // 分组格式优先于 problem+json
+		h.ErrorMessageFormat = "<p>%d %s</p><p>%d %s</p>"
+		h.ErrorContentType = "text/html; charset=utf-8"
+		_, body := sendError(t, h, utils.BAD_REQUEST, message, explain)
+		if strings.Contains(body, "<b>") || strings.Contains(body, "</script>") {
+			t.Errorf("HTML format is not escaped: %q", body)
+		}
+		if want := "<p>400 bad &#34;quote&#34; &lt;/script&gt;&lt;b&gt;</p>"; !strings.HasPrefix(body, want) {
+			t.Errorf("body = %q, want prefix %q", body, want)
+		}
+	})
+}
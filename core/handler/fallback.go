@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+)
+
+// tryFiles 请求的资源不存在时查找替代的文件，找不到时返回 false
+//
+// 先按顺序尝试 static.TryFiles 中的候选（"$uri" 替换为请求路径），再按 static.SPA 回退到单页应用的页面；
+// 候选是 URL 路径，可以位于其他挂载中，以 / 结尾或指向目录的候选使用其中的索引文件
+func (h *SimpleHTTPRequestHandler) tryFiles(urlPath string) (staticFile, fs.FileInfo, bool) {
+	var candidates []string
+	for _, c := range config.Cfg.Static.TryFiles {
+		if c = strings.ReplaceAll(c, "$uri", urlPath); c != urlPath {
+			candidates = append(candidates, c)
+		}
+	}
+	if index := spaIndex(urlPath); index != "" {
+		candidates = append(candidates, index)
+	}
+
+	for _, c := range candidates {
+		sf, err := h.locateStatic(fsName(h.useRoot(c)))
+		if err != nil {
+			continue
+		}
+		fi, err := h.statStatic(sf)
+		if err != nil {
+			continue
+		}
+		if fi.IsDir() {
+			if index, indexStat, ok := h.findIndex(sf); ok {
+				sf, fi = index, indexStat
+			}
+		}
+		if fi.Mode().IsRegular() {
+			talklog.Info(talklog.GID(), "Falling back from %s to %s", urlPath, c)
+			return sf, fi, true
+		}
+	}
+	h.useRoot(urlPath)
+	return staticFile{}, nil, false
+}
+
+// spaIndex 返回覆盖 urlPath 的最长前缀的单页应用页面
+// 最后一段带扩展名的路径（如 /app/main.js）视为缺失的资源文件，不回退
+func spaIndex(urlPath string) string {
+	if path.Ext(path.Base(urlPath)) != "" {
+		return ""
+	}
+	p := path.Clean("/" + urlPath)
+	index, longest := "", -1
+	for _, spa := range config.Cfg.Static.SPA {
+		prefix := path.Clean("/" + spa.Prefix)
+		if prefix != "/" && p != prefix && !strings.HasPrefix(p, prefix+"/") || len(prefix) <= longest {
+			continue
+		}
+		longest = len(prefix)
+		if index = spa.Index; index == "" {
+			index = path.Join(prefix, "index.html")
+		}
+	}
+	return index
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/filecache"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+// statFile 返回文件信息，开启文件缓存时结果来自缓存
+func statFile(path string) (os.FileInfo, error) {
+	if c := filecache.Default(); c != nil {
+		return c.Stat(path)
+	}
+	return os.Stat(path)
+}
+
+// invalidateFile 在服务器自身修改文件后立即使缓存失效，不等待文件监视的通知
+func invalidateFile(path string) {
+	if c := filecache.Default(); c != nil {
+		c.Invalidate(path)
+		c.Invalidate(filepath.Dir(path))
+	}
+}
+
+// invalidateTree 目录被整体删除、移动或覆盖后清空缓存（缓存不支持按目录前缀失效）
+func invalidateTree(path string, isDir bool) {
+	if !isDir {
+		invalidateFile(path)
+		return
+	}
+	if c := filecache.Default(); c != nil {
+		c.Purge()
+	}
+}
+
+// cachedContent 返回缓存中的文件内容；coding 非空时返回对应的压缩变体（首次使用时生成）
+// 文件缓存未开启、文件不在本地目录中（path 为空）或文件太大时返回 ok == false
+func cachedContent(path string, stat os.FileInfo, coding string) ([]byte, bool) {
+	c := filecache.Default()
+	if c == nil || path == "" {
+		return nil, false
+	}
+	data, ok := c.ReadFile(path, stat)
+	if !ok || coding == "" {
+		return data, ok
+	}
+	if encoded, ok := c.Variant(path, coding); ok {
+		return encoded, true
+	}
+	var buf bytes.Buffer
+	enc, err := newEncoder(coding, &buf)
+	if err != nil {
+		return nil, false
+	}
+	enc.Write(data)
+	if err := enc.Close(); err != nil {
+		return nil, false
+	}
+	c.SetVariant(path, coding, buf.Bytes())
+	return buf.Bytes(), true
+}
+
+// sendCachedHead 发送内存中内容的响应头，内容由 SendBody 输出
+// coding 为内容编码（空表示原始内容），区间请求只作用于原始内容
+func (h *SimpleHTTPRequestHandler) sendCachedHead(data []byte, stat os.FileInfo, contentType, coding, etag string) {
+	h.cachedBody = data
+	if coding == "" && h.SendRangeHead(stat, contentType, etag) {
+		if h.rangePlan == nil {
+			// 416 已经发送
+			h.cachedBody = nil
+		}
+		return
+	}
+	h.SendResponse(utils.OK, "")
+	if coding != "" {
+		h.SendHeader("Content-Encoding", coding)
+	}
+	h.SendHeader("Content-Type", contentType)
+	h.SendHeader("Content-Length", strconv.Itoa(len(data)))
+	h.SendHeader("Accept-Ranges", "bytes")
+	h.SendHeader("Last-Modified", stat.ModTime().UTC().Format(time.RFC1123))
+	h.SendHeader("ETag", etag)
+	h.EndHeaders()
+	talklog.Info(talklog.GID(), "Serving %d bytes from file cache for: %s", len(data), h.Path)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package handler
+
+import "os"
+
+// fileInode 非 Unix 平台没有 inode，ETag 只由大小与修改时间生成
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package handler
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode 返回文件的 inode 号，用于生成 ETag
+func fileInode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,322 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+// listingEntry 目录列表中的一项
+type listingEntry struct {
+	Name    string    `json:"name"`
+	Href    string    `json:"href"` // 相对当前目录（以 "./" 开头）、已 URL 编码的链接
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Type    string    `json:"type"`
+}
+
+// breadcrumb 面包屑导航中的一级目录
+type breadcrumb struct {
+	Name string
+	Href string
+}
+
+// sortColumn 表头的排序链接
+type sortColumn struct {
+	Label  string
+	Href   string
+	Active string // 当前排序列显示的箭头
+}
+
+// listingPage 目录列表模板的数据
+type listingPage struct {
+	Path        string
+	Breadcrumbs []breadcrumb
+	Columns     []sortColumn
+	Parent      bool
+	Entries     []listingEntry
+	Archives    []string // 可用的打包下载格式
+}
+
+// listingJSON Accept: application/json 时返回的结构
+type listingJSON struct {
+	Path    string         `json:"path"`
+	Sort    string         `json:"sort"`
+	Order   string         `json:"order"`
+	Entries []listingEntry `json:"entries"`
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"size": humanSize,
+	"time": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE HTML>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Directory listing for {{.Path}}</title>
+<style>
+	body { font-family: sans-serif; margin: 20px; }
+	table { border-collapse: collapse; }
+	th, td { padding: 4px 16px 4px 0; text-align: left; }
+	td.size { text-align: right; }
+	th a { text-decoration: none; }
+</style>
+</head>
+<body>
+<h1>Directory listing for {{range .Breadcrumbs}}<a href="{{.Href}}">{{.Name}}</a>{{end}}</h1>
+{{- if .Archives}}
+<p>Download as archive:{{range .Archives}} <a href="?archive={{.}}">{{.}}</a>{{end}}</p>
+{{- end}}
+<hr>
+<table>
+<tr>{{range .Columns}}<th><a href="{{.Href}}">{{.Label}}</a>{{.Active}}</th>{{end}}</tr>
+{{- if .Parent}}
+<tr><td><a href="../">../</a></td><td></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td class="size">{{if .IsDir}}-{{else}}{{size .Size}}{{end}}</td><td>{{time .ModTime}}</td><td>{{.Type}}</td></tr>
+{{- end}}
+</table>
+<hr>
+</body>
+</html>
+`))
+
+// humanSize 以 1024 为单位格式化文件大小
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "iB"
+}
+
+// isHidden 报告名称是否为隐藏文件（以 . 开头），配置 listing.ShowHidden 后不隐藏
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") && !config.Cfg.Listing.ShowHidden
+}
+
+// acceptsJSON 报告客户端是否更偏好 JSON 而不是 HTML
+func acceptsJSON(accept string) bool {
+	if accept == "" {
+		return false
+	}
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, item := range strings.Split(accept, ",") {
+		mt, params, _ := strings.Cut(item, ";")
+		mt = strings.ToLower(strings.TrimSpace(mt))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && strings.TrimSpace(k) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch mt {
+		case "application/json", "application/problem+json":
+			jsonQ = max(jsonQ, q)
+		case "text/html":
+			htmlQ = q
+		case "*/*", "text/*":
+			if htmlQ < 0 {
+				htmlQ = q * 0.99 // 通配的优先级低于显式给出的类型
+			}
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// readListing 读取目录项，过滤隐藏文件与 security 段拒绝的路径，并按 sortBy/order 排序（目录总是在前）
+// 返回目录项及其中最新的修改时间
+func (h *SimpleHTTPRequestHandler) readListing(dir string, dirStat os.FileInfo, sortBy, order string) ([]listingEntry, time.Time, error) {
+	list, err := fs.ReadDir(h.FS, dir)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	latest := dirStat.ModTime()
+	entries := make([]listingEntry, 0, len(list))
+	for _, de := range list {
+		name := de.Name()
+		if isHidden(name) {
+			continue
+		}
+		sf, err := h.locateStatic(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			// 列表中展示链接目标的信息
+			if target, err := h.statStatic(sf); err == nil {
+				fi = target
+			}
+		}
+		e := listingEntry{
+			Name:    name,
+			Href:    "./" + url.PathEscape(name), // "./" 防止 "a:b" 被当作带协议的 URL
+			IsDir:   fi.IsDir(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		}
+		if e.IsDir {
+			e.Href += "/"
+			e.Type = "directory"
+			e.Size = 0
+		} else {
+			e.Type = h.GuessType(name)
+		}
+		if e.ModTime.After(latest) {
+			latest = e.ModTime
+		}
+		entries = append(entries, e)
+	}
+
+	less := func(a, b listingEntry) bool { return a.Name < b.Name }
+	switch sortBy {
+	case "size":
+		less = func(a, b listingEntry) bool { return a.Size < b.Size }
+	case "mtime":
+		less = func(a, b listingEntry) bool { return a.ModTime.Before(b.ModTime) }
+	case "type":
+		less = func(a, b listingEntry) bool { return a.Type < b.Type }
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if order == "desc" {
+			a, b = b, a
+		}
+		if less(a, b) || less(b, a) {
+			return less(a, b)
+		}
+		return a.Name < b.Name
+	})
+	return entries, latest, nil
+}
+
+// listingParams 解析 ?sort=&order= 查询参数
+func (h *SimpleHTTPRequestHandler) listingParams() (sortBy, order string) {
+	query := utils.ParseQuery(h.QueryRaw)
+	sortBy, order = query["sort"], query["order"]
+	switch sortBy {
+	case "name", "size", "mtime", "type":
+	default:
+		sortBy = "name"
+	}
+	if order != "desc" {
+		order = "asc"
+	}
+	return sortBy, order
+}
+
+// breadcrumbs 由请求路径生成面包屑导航
+func breadcrumbs(urlPath string) []breadcrumb {
+	crumbs := []breadcrumb{{Name: "/", Href: "/"}}
+	href := "/"
+	for _, part := range strings.Split(strings.Trim(urlPath, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		href += url.PathEscape(part) + "/"
+		crumbs = append(crumbs, breadcrumb{Name: part + "/", Href: href})
+	}
+	return crumbs
+}
+
+// ListDirectory 生成目录列表并发送响应头，内容存入 h.cachedBody 由 SendBody 输出
+//
+// 支持 ?sort=name|size|mtime|type 与 ?order=asc|desc；Accept 偏好 application/json 时
+// 返回 JSON。列表使用弱 ETag，Last-Modified 取目录及其中各项的最新修改时间。
+// 出错或已应答 304 时返回非 nil 错误（响应已发送）。
+func (h *SimpleHTTPRequestHandler) ListDirectory(dir string, dirStat os.FileInfo) error {
+	sortBy, order := h.listingParams()
+	entries, latest, err := h.readListing(dir, dirStat, sortBy, order)
+	if err != nil {
+		talklog.Error(talklog.GID(), "Failed to list directory: %s, error: %v", dir, err)
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Error reading directory")
+		return err
+	}
+
+	// h.Path 已经过 URL 解码，由模板负责转义
+	urlPath := h.Path
+	var buf bytes.Buffer
+	contentType := "text/html; charset=utf-8"
+	if acceptsJSON(h.Headers["Accept"]) {
+		contentType = "application/json"
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(listingJSON{Path: urlPath, Sort: sortBy, Order: order, Entries: entries})
+	} else {
+		page := listingPage{
+			Path:        urlPath,
+			Breadcrumbs: breadcrumbs(urlPath),
+			Parent:      urlPath != "/",
+			Entries:     entries,
+		}
+		if config.Cfg.Archive.Enabled {
+			page.Archives = []string{"zip", "tar.gz"}
+		}
+		for _, col := range []struct{ key, label string }{
+			{"name", "Name"}, {"size", "Size"}, {"mtime", "Last modified"}, {"type", "Type"},
+		} {
+			c := sortColumn{Label: col.label, Href: "?sort=" + col.key + "&order=asc"}
+			if col.key == sortBy {
+				c.Active = " ↑"
+				if order == "asc" {
+					c.Href = "?sort=" + col.key + "&order=desc"
+				} else {
+					c.Active = " ↓"
+				}
+			}
+			page.Columns = append(page.Columns, c)
+		}
+		err = listingTemplate.Execute(&buf, page)
+	}
+	if err != nil {
+		talklog.Error(talklog.GID(), "Failed to render listing for %s: %v", dir, err)
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Error rendering directory listing")
+		return err
+	}
+
+	// 列表是动态生成的，只能保证语义等价，使用弱 ETag
+	etag := WeakETag(buf.Bytes())
+	if h.SendPreconditionResult(h.EvaluatePreconditions(etag, latest), etag, "Accept") {
+		return errResponseSent
+	}
+
+	h.cachedBody = buf.Bytes()
+	h.SendResponse(utils.OK, "")
+	h.SendHeader("Content-Type", contentType)
+	h.SendHeader("Content-Length", strconv.Itoa(buf.Len()))
+	h.SendHeader("Last-Modified", latest.UTC().Format(time.RFC1123))
+	h.SendHeader("ETag", etag)
+	h.SendHeader("Vary", "Accept")
+	h.EndHeaders()
+	talklog.Info(talklog.GID(), "Generated directory listing for: %s (%d entries)", dir, len(entries))
+	return nil
+}
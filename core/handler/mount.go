@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/vfs"
+)
+
+// mountPoint 已打开的路径别名
+type mountPoint struct {
+	config.Mount
+	prefix string      // 规范化后的前缀，不以 / 结尾，如 "/static"
+	root   *sharedRoot // 挂载的文件系统，请求经由 useRoot 持有引用
+	dir    string      // 本地目录挂载的根目录，归档等为空
+}
+
+var (
+	mountsMu     sync.Mutex
+	mountsLoaded bool
+	mountList    []*mountPoint // 按前缀长度从长到短排列
+)
+
+func init() {
+	config.OnReload(func() {
+		mountsMu.Lock()
+		mountsLoaded = false
+		mountsMu.Unlock()
+	})
+}
+
+// mounts 返回配置的挂载点，首次使用与配置热加载后重新加载，见 loadMounts
+func mounts() []*mountPoint {
+	mountsMu.Lock()
+	defer mountsMu.Unlock()
+	return loadMounts()
+}
+
+// loadMounts 按配置重建挂载点，调用方持有 mountsMu
+// Source 不变的挂载沿用已打开的文件系统，不再使用的文件系统在请求释放后关闭；无法打开的挂载记录错误后忽略
+func loadMounts() []*mountPoint {
+	if mountsLoaded {
+		return mountList
+	}
+	mountsLoaded = true
+	previous := make(map[string]*sharedRoot)
+	for _, m := range mountList {
+		previous[m.Source] = m.root
+	}
+	opened := make(map[string]*sharedRoot)
+	mountList = nil
+	for _, m := range config.Cfg.Mounts {
+		prefix := path.Clean("/" + m.Prefix)
+		if prefix == "/" || m.Source == "" {
+			talklog.Warn(talklog.GID(), "Ignoring mount %q -> %q: prefix and source are required", m.Prefix, m.Source)
+			continue
+		}
+		root := opened[m.Source]
+		if root == nil {
+			root = previous[m.Source]
+		}
+		if root == nil {
+			fsys, err := vfs.Open(m.Source)
+			if err != nil {
+				talklog.Error(talklog.GID(), "Cannot open mount %s -> %s: %v", prefix, m.Source, err)
+				continue
+			}
+			root = &sharedRoot{fsys: fsys, name: "mount " + m.Source}
+		}
+		opened[m.Source] = root
+		mp := &mountPoint{Mount: m, prefix: prefix, root: root}
+		if d, ok := root.fsys.(*vfs.DirFS); ok {
+			mp.dir = d.Root
+		}
+		mountList = append(mountList, mp)
+		talklog.Info(talklog.GID(), "Mount: %s -> %s", prefix, m.Source)
+	}
+	for source, root := range previous {
+		if opened[source] != root {
+			root.retire()
+		}
+	}
+	sort.SliceStable(mountList, func(i, j int) bool { return len(mountList[i].prefix) > len(mountList[j].prefix) })
+	return mountList
+}
+
+// matchMount 返回覆盖 urlPath 的最长前缀的挂载点，以及 urlPath 在挂载中的路径（以 / 开头）
+func matchMount(urlPath string) (*mountPoint, string) {
+	return findMount(mounts(), urlPath)
+}
+
+// acquireMount 与 matchMount 相同，并增加命中的挂载的文件系统的引用，用完后调用 root.release
+func acquireMount(urlPath string) (*mountPoint, string) {
+	mountsMu.Lock()
+	defer mountsMu.Unlock()
+	m, rest := findMount(loadMounts(), urlPath)
+	if m != nil {
+		m.root.acquire()
+	}
+	return m, rest
+}
+
+func findMount(list []*mountPoint, urlPath string) (*mountPoint, string) {
+	p := path.Clean("/" + urlPath)
+	for _, m := range list {
+		if p == m.prefix {
+			return m, "/"
+		}
+		if rest, ok := strings.CutPrefix(p, m.prefix+"/"); ok {
+			return m, "/" + rest
+		}
+	}
+	return nil, urlPath
+}
+
+// listingAllowed 报告没有索引文件时是否可以列出目录
+func (m *mountPoint) listingAllowed() bool {
+	return m.Listing == nil || *m.Listing
+}
+
+// cacheAllowed 报告是否可以使用文件缓存
+func (m *mountPoint) cacheAllowed() bool {
+	return m.Cache == nil || *m.Cache
+}
+
+// methods 返回挂载路径支持的方法，挂载是只读的，开启上传时另外支持 POST
+func (m *mountPoint) methods() []string {
+	if m.Upload && m.dir != "" {
+		return []string{"GET", "HEAD", "OPTIONS", "POST"}
+	}
+	return []string{"GET", "HEAD", "OPTIONS"}
+}
+
+// selectMount 按请求路径选择文档根，见 useRoot
+func (h *SimpleHTTPRequestHandler) selectMount() string {
+	return h.useRoot(h.Path)
+}
+
+// useRoot 按 URL 路径选择文档根：命中挂载时使用挂载的文件系统，否则使用 Docroot
+// 设置 h.mount 与 h.FS，返回 urlPath 在该文档根中的路径
+func (h *SimpleHTTPRequestHandler) useRoot(urlPath string) string {
+	m, rest := acquireMount(urlPath)
+	h.mount = m
+	if m != nil {
+		h.OnRequestDone(m.root.release)
+		h.FS = m.root.fsys
+		return rest
+	}
+	root := acquireDocroot()
+	h.OnRequestDone(root.release)
+	h.FS = root.fsys
+	return urlPath
+}
@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+	"github.com/Singert/xjtu_cnlab/core/vfs"
+)
+
+// 一个请求最多接受的区间数，防止大量细碎或重叠区间放大响应
+const maxRanges = 64
+
+var (
+	errInvalidRange = errors.New("invalid range")
+	errNoOverlap    = errors.New("range does not overlap content")
+)
+
+// httpRange 响应体中的一个字节区间
+type httpRange struct {
+	start, length int64
+}
+
+// contentRange 返回 Content-Range 头的值
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// rangeResponse 记录 SendHead 确定的区间响应，由 SendBody 按此输出文件内容
+type rangeResponse struct {
+	ranges      []httpRange
+	boundary    string // 多区间时 multipart/byteranges 的分隔符
+	contentType string
+	size        int64
+}
+
+// parseRange 解析 Range 头（仅支持 bytes 单位）
+//
+// 语法错误或单位不支持时返回 errInvalidRange，调用方应忽略 Range 头；
+// 所有区间都落在文件之外时返回 errNoOverlap，应答 416
+func parseRange(s string, size int64) ([]httpRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, errInvalidRange
+	}
+	var ranges []httpRange
+	noOverlap := false
+	for _, spec := range strings.Split(s[len(prefix):], ",") {
+		spec = textproto.TrimString(spec)
+		if spec == "" {
+			continue
+		}
+		startStr, endStr, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		startStr, endStr = textproto.TrimString(startStr), textproto.TrimString(endStr)
+		var r httpRange
+		if startStr == "" {
+			// 后缀区间 bytes=-N：最后 N 个字节
+			n, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n == 0 {
+				noOverlap = true
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.start, r.length = size-n, n
+		} else {
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			if start >= size {
+				noOverlap = true
+				continue
+			}
+			r.start = start
+			if endStr == "" {
+				r.length = size - start
+			} else {
+				end, err := strconv.ParseInt(endStr, 10, 64)
+				if err != nil || end < start {
+					return nil, errInvalidRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+				r.length = end - start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		if noOverlap {
+			return nil, errNoOverlap
+		}
+		return nil, errInvalidRange
+	}
+	if len(ranges) > maxRanges {
+		return nil, errInvalidRange
+	}
+	return ranges, nil
+}
+
+// sumRangesSize 返回所有区间的字节总数
+func sumRangesSize(ranges []httpRange) int64 {
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	return total
+}
+
+// partHeader 返回 multipart/byteranges 中一个分段的分隔行与头部
+func (rr *rangeResponse) partHeader(i int) string {
+	var b strings.Builder
+	if i > 0 {
+		b.WriteString("\r\n")
+	}
+	fmt.Fprintf(&b, "--%s\r\n", rr.boundary)
+	fmt.Fprintf(&b, "Content-Type: %s\r\n", rr.contentType)
+	fmt.Fprintf(&b, "Content-Range: %s\r\n\r\n", rr.ranges[i].contentRange(rr.size))
+	return b.String()
+}
+
+// closeDelimiter 返回 multipart/byteranges 的结束分隔行
+func (rr *rangeResponse) closeDelimiter() string {
+	return "\r\n--" + rr.boundary + "--\r\n"
+}
+
+// multipartLength 计算 multipart/byteranges 响应体的总长度
+func (rr *rangeResponse) multipartLength() int64 {
+	total := int64(len(rr.closeDelimiter()))
+	for i, r := range rr.ranges {
+		total += int64(len(rr.partHeader(i))) + r.length
+	}
+	return total
+}
+
+// newBoundary 生成 multipart 分隔符
+func newBoundary() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// checkIfRange 报告 If-Range 条件是否成立（成立时才按 Range 应答）
+// 实体标签使用强比较，日期形式要求与 Last-Modified 完全相同
+func (h *SimpleHTTPRequestHandler) checkIfRange(modTime time.Time, etag string) bool {
+	ir := textproto.TrimString(h.Headers["If-Range"])
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, "\"") || strings.HasPrefix(ir, "W/") {
+		return etagStrongMatch(ir, etag)
+	}
+	t, err := ParseHTTPTime(ir)
+	if err != nil {
+		return false
+	}
+	return t.Equal(modTime.UTC().Truncate(time.Second))
+}
+
+// SendRangeHead 按 Range 头发送 206 或 416 的响应头，返回是否已经应答
+// Range 头不存在、无效或 If-Range 不成立时返回 false，由调用方发送完整内容
+func (h *SimpleHTTPRequestHandler) SendRangeHead(stat os.FileInfo, contentType, etag string) bool {
+	rangeHeader := h.Headers["Range"]
+	if rangeHeader == "" || h.Command != "GET" {
+		return false
+	}
+	if !h.checkIfRange(stat.ModTime(), etag) {
+		talklog.Info(talklog.GID(), "If-Range not matched, sending full content")
+		return false
+	}
+	size := stat.Size()
+	ranges, err := parseRange(rangeHeader, size)
+	if err == errNoOverlap {
+		h.AddResponseHeader("Content-Range", fmt.Sprintf("bytes */%d", size))
+		h.SendError(utils.REQUESTED_RANGE_NOT_SATISFIABLE, "")
+		return true
+	}
+	if err != nil {
+		talklog.Warn(talklog.GID(), "Ignoring invalid Range header: %s", rangeHeader)
+		return false
+	}
+	// 区间总和不小于整个文件时，直接发送完整内容更划算
+	if sumRangesSize(ranges) > size {
+		return false
+	}
+
+	rr := &rangeResponse{ranges: ranges, contentType: contentType, size: size}
+	h.rangePlan = rr
+	h.SendResponse(utils.PARTIAL_CONTENT, "")
+	h.SendHeader("Accept-Ranges", "bytes")
+	h.SendHeader("Last-Modified", stat.ModTime().UTC().Format(time.RFC1123))
+	if etag != "" {
+		h.SendHeader("ETag", etag)
+	}
+	if len(ranges) == 1 {
+		h.SendHeader("Content-Type", contentType)
+		h.SendHeader("Content-Range", ranges[0].contentRange(size))
+		h.SendHeader("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+	} else {
+		rr.boundary = newBoundary()
+		h.SendHeader("Content-Type", "multipart/byteranges; boundary="+rr.boundary)
+		h.SendHeader("Content-Length", strconv.FormatInt(rr.multipartLength(), 10))
+	}
+	h.EndHeaders()
+	talklog.Info(talklog.GID(), "Sending %d range(s) of %d bytes", len(ranges), size)
+	return true
+}
+
+// SendBody 输出 SendHead 选定的内容（缓存的内存内容优先于文件 f）：
+// 区间响应只输出选中的部分，动态压缩时边读边压缩，目录打包时边遍历边写出归档，其余发送全部内容
+func (h *SimpleHTTPRequestHandler) SendBody(f vfs.File) error {
+	rr, ep, data, ap := h.rangePlan, h.encodePlan, h.cachedBody, h.archivePlan
+	h.rangePlan, h.encodePlan, h.cachedBody, h.archivePlan = nil, nil, nil, nil
+	if ap != nil {
+		return h.sendArchiveBody(ap)
+	}
+
+	var src interface {
+		io.Reader
+		io.ReaderAt
+	} = f
+	if data != nil {
+		src = bytes.NewReader(data)
+	}
+	if ep != nil {
+		return h.sendEncodedBody(src, ep)
+	}
+	if rr == nil {
+		// 只输出声明的长度：文件在此期间变长时，多出的内容会混入下一个响应
+		length := h.bodyLength
+		if data != nil {
+			length = int64(len(data))
+		}
+		return h.copyRange(src, httpRange{start: 0, length: length})
+	}
+	if len(rr.ranges) == 1 {
+		return h.copyRange(src, rr.ranges[0])
+	}
+	for i, r := range rr.ranges {
+		if _, err := io.WriteString(h.WFile, rr.partHeader(i)); err != nil {
+			return err
+		}
+		if err := h.copyRange(src, r); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(h.WFile, rr.closeDelimiter())
+	return err
+}
+
+// copyRange 输出内容中的一个区间，文件内容走 writeFile 的零拷贝路径
+func (h *SimpleHTTPRequestHandler) copyRange(src io.ReaderAt, r httpRange) error {
+	if f, ok := src.(*os.File); ok {
+		return h.writeFile(f, r.start, r.length)
+	}
+	n, err := io.Copy(h.WFile, io.NewSectionReader(src, r.start, r.length))
+	if err == nil && n < r.length {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
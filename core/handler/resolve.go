@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+// 符号链接策略（security.Symlinks）
+const (
+	SymlinksDeny       = "deny"        // 路径中不允许出现符号链接
+	SymlinksWithinRoot = "within-root" // 解析符号链接后必须仍在工作目录之内（默认）
+	SymlinksAllow      = "allow"       // 跟随任何符号链接
+)
+
+// 路径解析失败的原因
+var (
+	ErrInvalidPath = errors.New("path contains NUL or backslash")
+	ErrPathDenied  = errors.New("path is denied by policy")
+	ErrOutsideRoot = errors.New("path resolves outside the document root")
+	ErrNotLocal    = errors.New("path is not backed by a local directory")
+)
+
+// symlinkPolicy 返回配置的符号链接策略，未配置或无法识别时为 within-root
+func symlinkPolicy() string {
+	switch p := strings.ToLower(config.Cfg.Security.Symlinks); p {
+	case SymlinksDeny, SymlinksAllow:
+		return p
+	}
+	return SymlinksWithinRoot
+}
+
+// pathDenied 报告以 / 分隔的相对路径是否匹配 security.Deny
+// 不含 / 的模式逐级匹配路径中的每个名称（".*" 拒绝所有点文件及其中的内容），含 / 的模式匹配整个相对路径
+func pathDenied(rel string) bool {
+	rel = strings.Trim(rel, "/")
+	if rel == "" || rel == "." {
+		return false
+	}
+	segments := strings.Split(rel, "/")
+	for _, pattern := range config.Cfg.Security.Deny {
+		if strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(strings.Trim(pattern, "/"), rel); ok {
+				return true
+			}
+			continue
+		}
+		for _, seg := range segments {
+			if ok, _ := path.Match(pattern, seg); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ResolvePath 把已解码的 URL 路径解析为 root 之下的文件系统路径
+//
+// 拒绝含 NUL 或反斜杠的路径（ErrInvalidPath），规范化 . 与 .. 后检查 deny 列表（ErrPathDenied），
+// 再按符号链接策略检查解析后的真实路径（ErrOutsideRoot / ErrPathDenied）。
+// 返回的是未解析符号链接的路径，目标不存在时按最近的已存在上级目录检查。
+func ResolvePath(root, urlPath string) (string, error) {
+	if strings.ContainsAny(urlPath, "\x00\\") {
+		return "", ErrInvalidPath
+	}
+	clean := path.Clean("/" + urlPath)
+	if pathDenied(clean) {
+		return "", ErrPathDenied
+	}
+	p := filepath.Join(root, filepath.FromSlash(clean[1:]))
+	if err := checkSymlinks(root, p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// CheckEntry 对遍历目录得到的 root 之下的路径应用 deny 列表与符号链接策略
+// 目录列表、打包下载与 PROPFIND 用它过滤目录项
+func CheckEntry(root, p string) error {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ErrOutsideRoot
+	}
+	if pathDenied(filepath.ToSlash(rel)) {
+		return ErrPathDenied
+	}
+	if fi, err := os.Lstat(p); err == nil && fi.Mode()&os.ModeSymlink == 0 {
+		// 不是符号链接，上级目录已经检查过
+		return nil
+	}
+	return checkSymlinks(root, p)
+}
+
+// checkSymlinks 按符号链接策略检查 p 解析后的真实路径
+// 无法解析根目录或路径（权限不足、链接层数过多等）时拒绝访问
+func checkSymlinks(root, p string) error {
+	policy := symlinkPolicy()
+	if policy == SymlinksAllow {
+		return nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fmt.Errorf("%w: cannot resolve root: %v", ErrPathDenied, err)
+	}
+
+	// 目标不存在（PUT、MKCOL 等会新建路径）或中间路径不是目录时检查最近的已存在上级目录，
+	// 后者留给后续的文件操作报告；悬空的符号链接会在写入时被跟随，同样视为越界
+	existing := p
+	real, err := filepath.EvalSymlinks(existing)
+	for (errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)) && existing != root {
+		if _, lerr := os.Lstat(existing); lerr == nil {
+			return ErrOutsideRoot
+		}
+		existing = filepath.Dir(existing)
+		real, err = filepath.EvalSymlinks(existing)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPathDenied, err)
+	}
+
+	if policy == SymlinksDeny {
+		rel, err := filepath.Rel(root, existing)
+		if err != nil || real != filepath.Join(realRoot, rel) {
+			return ErrPathDenied
+		}
+		return nil
+	}
+	if real != realRoot && !strings.HasPrefix(real, realRoot+string(filepath.Separator)) {
+		return ErrOutsideRoot
+	}
+	return nil
+}
+
+// TranslatePath 将已解码的 URL 路径转换为工作目录下的文件系统路径，规则见 ResolvePath
+// 挂载路径转换到挂载的本地目录之下，归档等非本地挂载返回 ErrNotLocal
+func (h *SimpleHTTPRequestHandler) TranslatePath(urlPath string) (string, error) {
+	if m, rest := matchMount(urlPath); m != nil {
+		if m.dir == "" {
+			return "", ErrNotLocal
+		}
+		return ResolvePath(m.dir, rest)
+	}
+	return ResolvePath(h.Directory, urlPath)
+}
+
+// resolveRequestPath 调用 TranslatePath，失败时应答 400（非法字符）或 403 并返回 false
+func (h *SimpleHTTPRequestHandler) resolveRequestPath(urlPath string) (string, bool) {
+	p, err := h.TranslatePath(urlPath)
+	if err == nil {
+		return p, true
+	}
+	talklog.Warn(talklog.GID(), "Rejected path %q: %v", urlPath, err)
+	if errors.Is(err, ErrInvalidPath) {
+		h.SendError(utils.BAD_REQUEST, "Invalid characters in path")
+	} else {
+		h.SendError(utils.FORBIDDEN, "Access denied")
+	}
+	return "", false
+}
+
+// localRoot 返回 URL 路径所在的本地根目录：本地目录挂载的根目录，或工作目录
+// 对该路径下的目录项调用 CheckEntry 时以它为根
+func (h *SimpleHTTPRequestHandler) localRoot(urlPath string) string {
+	if m, _ := matchMount(urlPath); m != nil && m.dir != "" {
+		return m.dir
+	}
+	return h.Directory
+}
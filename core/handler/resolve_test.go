@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+)
+
+// resolveFixture 创建测试用的文档根：
+//
+//	root/index.html
+//	root/.secret
+//	root/server.key
+//	root/sub/ok.txt
+//	root/in       -> root/sub
+//	root/out      -> outside/
+//	root/dangling -> outside/missing
+func resolveFixture(t *testing.T) (root, outside string) {
+	t.Helper()
+	base := t.TempDir()
+	root = filepath.Join(base, "root")
+	outside = filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{
+		filepath.Join(root, "index.html"),
+		filepath.Join(root, ".secret"),
+		filepath.Join(root, "server.key"),
+		filepath.Join(root, "sub", "ok.txt"),
+		filepath.Join(outside, "passwd"),
+	} {
+		if err := os.WriteFile(name, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"in":       filepath.Join(root, "sub"),
+		"out":      outside,
+		"dangling": filepath.Join(outside, "missing"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	return root, outside
+}
+
+// withSecurity 临时替换 security 配置
+func withSecurity(t *testing.T, symlinks string, deny []string) {
+	t.Helper()
+	saved := config.Cfg.Security
+	t.Cleanup(func() { config.Cfg.Security = saved })
+	config.Cfg.Security.Symlinks = symlinks
+	config.Cfg.Security.Deny = deny
+}
+
+func TestResolvePath(t *testing.T) {
+	root, _ := resolveFixture(t)
+
+	// 每项的 URL 路径按请求行中的形式给出，先百分号解码再解析；
+	// want 按 deny、within-root、allow 三种符号链接策略分别给出期望的错误
+	tests := []struct {
+		name string
+		url  string
+		want [3]error
+		rel  string // 成功时期望的相对路径
+	}{
+		{"plain file", "/index.html", [3]error{nil, nil, nil}, "index.html"},
+		{"missing file", "/new.txt", [3]error{nil, nil, nil}, "new.txt"},
+		{"dotdot", "/../../etc/passwd", [3]error{nil, nil, nil}, "etc/passwd"},
+		{"encoded dotdot", "/sub/%2e%2e/%2e%2e/index.html", [3]error{nil, nil, nil}, "index.html"},
+		{"encoded NUL", "/index.html%00.txt", [3]error{ErrInvalidPath, ErrInvalidPath, ErrInvalidPath}, ""},
+		{"backslash", `/sub\..\..\index.html`, [3]error{ErrInvalidPath, ErrInvalidPath, ErrInvalidPath}, ""},
+		{"encoded backslash", "/sub%5c..%5cindex.html", [3]error{ErrInvalidPath, ErrInvalidPath, ErrInvalidPath}, ""},
+		{"dotfile", "/.secret", [3]error{ErrPathDenied, ErrPathDenied, ErrPathDenied}, ""},
+		{"dot directory", "/.git/config", [3]error{ErrPathDenied, ErrPathDenied, ErrPathDenied}, ""},
+		{"dotfile via dotdot", "/sub/../.secret", [3]error{ErrPathDenied, ErrPathDenied, ErrPathDenied}, ""},
+		{"key file", "/server.key", [3]error{ErrPathDenied, ErrPathDenied, ErrPathDenied}, ""},
+		{"key file in subdir", "/sub/private.key", [3]error{ErrPathDenied, ErrPathDenied, ErrPathDenied}, ""},
+		{"key-like name", "/server.key.txt", [3]error{nil, nil, nil}, "server.key.txt"},
+		{"link within root", "/in/ok.txt", [3]error{ErrPathDenied, nil, nil}, "in/ok.txt"},
+		{"link out of root", "/out/passwd", [3]error{ErrPathDenied, ErrOutsideRoot, nil}, "out/passwd"},
+		{"new file behind link out of root", "/out/new.txt", [3]error{ErrPathDenied, ErrOutsideRoot, nil}, "out/new.txt"},
+		{"dangling link", "/dangling", [3]error{ErrOutsideRoot, ErrOutsideRoot, nil}, "dangling"},
+		{"below a regular file", "/index.html/x", [3]error{nil, nil, nil}, "index.html/x"},
+	}
+
+	for i, policy := range []string{SymlinksDeny, SymlinksWithinRoot, SymlinksAllow} {
+		t.Run(policy, func(t *testing.T) {
+			withSecurity(t, policy, []string{".*", "*.key"})
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					decoded, err := url.PathUnescape(tt.url)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, err := ResolvePath(root, decoded)
+					want := tt.want[i]
+					if want != nil {
+						if !errors.Is(err, want) {
+							t.Fatalf("ResolvePath(%q) error = %v, want %v", tt.url, err, want)
+						}
+						return
+					}
+					if err != nil {
+						t.Fatalf("ResolvePath(%q) error = %v", tt.url, err)
+					}
+					if wantPath := filepath.Join(root, filepath.FromSlash(tt.rel)); got != wantPath {
+						t.Fatalf("ResolvePath(%q) = %q, want %q", tt.url, got, wantPath)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestResolvePathMissingRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "missing")
+	for _, policy := range []string{SymlinksDeny, SymlinksWithinRoot} {
+		withSecurity(t, policy, nil)
+		if _, err := ResolvePath(root, "/index.html"); !errors.Is(err, ErrPathDenied) {
+			t.Errorf("%s: error = %v, want %v", policy, err, ErrPathDenied)
+		}
+	}
+}
+
+func TestCheckEntry(t *testing.T) {
+	root, outside := resolveFixture(t)
+
+	tests := []struct {
+		name string
+		path string
+		want [3]error
+	}{
+		{"regular file", filepath.Join(root, "index.html"), [3]error{nil, nil, nil}},
+		{"dotfile", filepath.Join(root, ".secret"), [3]error{ErrPathDenied, ErrPathDenied, ErrPathDenied}},
+		{"key file", filepath.Join(root, "server.key"), [3]error{ErrPathDenied, ErrPathDenied, ErrPathDenied}},
+		{"link within root", filepath.Join(root, "in"), [3]error{ErrPathDenied, nil, nil}},
+		{"link out of root", filepath.Join(root, "out"), [3]error{ErrPathDenied, ErrOutsideRoot, nil}},
+		{"dangling link", filepath.Join(root, "dangling"), [3]error{ErrOutsideRoot, ErrOutsideRoot, nil}},
+		{"outside the root", filepath.Join(outside, "passwd"), [3]error{ErrOutsideRoot, ErrOutsideRoot, ErrOutsideRoot}},
+	}
+
+	for i, policy := range []string{SymlinksDeny, SymlinksWithinRoot, SymlinksAllow} {
+		t.Run(policy, func(t *testing.T) {
+			withSecurity(t, policy, []string{".*", "*.key"})
+			for _, tt := range tests {
+				err := CheckEntry(root, tt.path)
+				if want := tt.want[i]; want == nil && err != nil || want != nil && !errors.Is(err, want) {
+					t.Errorf("%s: CheckEntry(%q) = %v, want %v", tt.name, strings.TrimPrefix(tt.path, root), err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Singert/xjtu_cnlab/core/router"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+var ErrBodyOverflow = errors.New("response body exceeds declared Content-Length")
+
+// RouteResponseWriter 实现 router.ResponseWriter，基于 SendResponse/SendHeader 输出
+//
+// 响应体的分帧方式在写出响应头时确定：
+//   - 处理器设置了 Content-Length：按固定长度输出
+//   - HTTP/1.1 请求：Transfer-Encoding: chunked 流式输出
+//   - HTTP/1.0 请求：输出完毕后关闭连接（close-delimited）
+type RouteResponseWriter struct {
+	h           *BaseHTTPRequestHandler
+	header      router.Header
+	status      int
+	wroteHeader bool
+	aborted     bool
+	chunked     bool
+	remaining   int64 // 固定长度模式下剩余可写字节，-1 表示不限
+	written     int64
+	buf         *bufio.Writer // 合并零碎写入，减少 chunk 数量
+	cw          *ChunkedWriter
+}
+
+// NewRouteResponseWriter 创建绑定到当前请求的响应写入器
+func NewRouteResponseWriter(h *BaseHTTPRequestHandler) *RouteResponseWriter {
+	return &RouteResponseWriter{
+		h:         h,
+		header:    make(router.Header),
+		status:    int(utils.OK),
+		remaining: -1,
+	}
+}
+
+// Header 返回尚未发送的响应头
+func (w *RouteResponseWriter) Header() router.Header {
+	return w.header
+}
+
+// Status 设置状态码，在第一次写出时发送
+func (w *RouteResponseWriter) Status(code int) {
+	if w.wroteHeader {
+		talklog.Warn(talklog.GID(), "Status %d ignored, headers already sent", code)
+		return
+	}
+	w.status = code
+}
+
+// StatusCode 返回当前状态码
+func (w *RouteResponseWriter) StatusCode() int {
+	return w.status
+}
+
+// Committed 报告响应头是否已经发送
+func (w *RouteResponseWriter) Committed() bool {
+	return w.wroteHeader
+}
+
+// WriteHeader 发送状态行与响应头，重复调用会被忽略
+func (w *RouteResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		talklog.Warn(talklog.GID(), "Superfluous WriteHeader call with status %d", code)
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+	h := w.h
+
+	h.SendResponse(utils.HTTPStatus(code), "")
+
+	bodyAllowed := code >= 200 && code != int(utils.NO_CONTENT) && code != int(utils.NOT_MODIFIED)
+	if !bodyAllowed {
+		w.header.Del("Content-Length")
+		w.header.Del("Transfer-Encoding")
+		w.remaining = 0
+	} else if cl := w.header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			w.remaining = n
+		} else {
+			w.header.Del("Content-Length")
+		}
+	}
+	if bodyAllowed && w.remaining < 0 {
+		w.header.Del("Transfer-Encoding")
+		if h.RequestVersion >= "HTTP/1.1" {
+			w.header.Set("Transfer-Encoding", "chunked")
+			// HEAD 响应保留与 GET 相同的头，但不输出响应体
+			w.chunked = h.Command != "HEAD"
+		} else if h.Command != "HEAD" {
+			// HTTP/1.0 没有 chunked，只能以关闭连接作为响应体结束标志
+			w.header.Set("Connection", "close")
+		}
+	}
+	if h.CloseConnection && w.header.Get("Connection") == "" {
+		w.header.Set("Connection", "close")
+	}
+
+	keys := make([]string, 0, len(w.header))
+	for key := range w.header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, v := range w.header[key] {
+			h.SendHeader(key, v)
+		}
+	}
+	h.EndHeaders()
+
+	if w.chunked {
+		w.cw = NewChunkedWriter(h.WFile)
+		w.buf = bufio.NewWriterSize(w.cw, 4096)
+	}
+}
+
+// Error 通过 SendError 发送错误响应，已设置的响应头随之发送
+func (w *RouteResponseWriter) Error(code int, explain string) {
+	if w.wroteHeader {
+		talklog.Warn(talklog.GID(), "Error %d ignored, headers already sent", code)
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+	w.remaining = 0
+	for _, key := range []string{"Content-Type", "Content-Length", "Transfer-Encoding"} {
+		w.header.Del(key)
+	}
+	keys := make([]string, 0, len(w.header))
+	for key := range w.header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, v := range w.header[key] {
+			w.h.AddResponseHeader(key, v)
+		}
+	}
+	w.h.SendError(utils.HTTPStatus(code), "", explain)
+}
+
+// Write 写出响应体，首次调用时自动发送响应头（默认 200）
+func (w *RouteResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.header.Get("Content-Type") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(w.status)
+	}
+	if w.h.Command == "HEAD" || len(p) == 0 {
+		return len(p), nil
+	}
+	if w.remaining >= 0 {
+		if int64(len(p)) > w.remaining {
+			return 0, ErrBodyOverflow
+		}
+		w.remaining -= int64(len(p))
+	}
+	var n int
+	var err error
+	if w.chunked {
+		n, err = w.buf.Write(p)
+	} else {
+		n, err = w.h.WFile.Write(p)
+	}
+	w.written += int64(n)
+	return n, err
+}
+
+// WriteString 写出字符串形式的响应体
+func (w *RouteResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 将已缓冲的数据立即发送给客户端
+func (w *RouteResponseWriter) Flush() error {
+	if !w.wroteHeader {
+		w.WriteHeader(w.status)
+	}
+	if w.buf != nil {
+		if err := w.buf.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.h.WFile.Flush()
+}
+
+// BytesWritten 返回已写出的响应体字节数
+func (w *RouteResponseWriter) BytesWritten() int64 {
+	return w.written
+}
+
+// Abort 放弃响应：Finish 不写出 last-chunk，而是发送已缓冲的数据后关闭连接
+func (w *RouteResponseWriter) Abort() {
+	w.aborted = true
+	w.h.CloseConnection = true
+}
+
+// Finish 结束响应：写出 last-chunk，或在响应体不完整时关闭连接
+// 处理器没有输出任何内容时发送一个空响应体的响应
+func (w *RouteResponseWriter) Finish() {
+	if w.aborted {
+		if w.buf != nil {
+			w.buf.Flush()
+		}
+		w.h.WFile.Flush()
+		return
+	}
+	if !w.wroteHeader {
+		w.header.Set("Content-Length", "0")
+		w.WriteHeader(w.status)
+	}
+	if w.chunked {
+		err := w.buf.Flush()
+		if err == nil {
+			err = w.cw.Close()
+		}
+		if err != nil {
+			talklog.Error(talklog.GID(), "Error finishing chunked response: %v", err)
+			w.h.CloseConnection = true
+		}
+	} else if w.remaining > 0 && w.h.Command != "HEAD" {
+		talklog.Warn(talklog.GID(), "Handler wrote %d bytes less than declared Content-Length", w.remaining)
+		w.h.CloseConnection = true
+	}
+	if strings.EqualFold(w.header.Get("Connection"), "close") {
+		w.h.CloseConnection = true
+	}
+	w.h.WFile.Flush()
+}
+
+var _ router.ResponseWriter = (*RouteResponseWriter)(nil)
+var _ io.StringWriter = (*RouteResponseWriter)(nil)
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+)
+
+// writeFile 输出文件中从 start 开始的 length 个字节
+//
+// 明文 TCP 连接上先刷出 WFile 中缓冲的响应头，再把文件直接交给
+// net.TCPConn.ReadFrom，由内核通过 sendfile/splice 完成拷贝，数据不经过用户态；
+// TLS 等其他连接退回到经由 WFile 的普通拷贝。两条路径的对比见 BenchmarkWriteFile。
+// length 即响应头中声明的长度：文件变长时多出的内容不输出，变短时返回 io.ErrUnexpectedEOF
+func (h *SimpleHTTPRequestHandler) writeFile(f *os.File, start, length int64) error {
+	if tcp, ok := h.Conn.(*net.TCPConn); ok {
+		return sendFile(h.WFile, tcp, f, start, length)
+	}
+	return bufferFile(h.WFile, f, start, length)
+}
+
+// bufferFile 经由 w 的缓冲区输出文件区间
+func bufferFile(w *bufio.Writer, f *os.File, start, length int64) error {
+	n, err := io.Copy(w, io.NewSectionReader(f, start, length))
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// sendFile 刷出 w 中缓冲的数据后，通过 TCPConn.ReadFrom 输出文件区间
+func sendFile(w *bufio.Writer, tcp *net.TCPConn, f *os.File, start, length int64) error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	// *io.LimitedReader 包装的 *os.File 同样可以走 sendfile
+	n, err := tcp.ReadFrom(io.LimitReader(f, length))
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkWriteFile 在回环 TCP 连接上对比经由缓冲区的拷贝与 ReadFrom（sendfile）两条路径
+func BenchmarkWriteFile(b *testing.B) {
+	const size = 8 << 20
+	name := filepath.Join(b.TempDir(), "blob")
+	if err := os.WriteFile(name, make([]byte, size), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	ranges := []struct {
+		name          string
+		start, length int64
+	}{
+		{"full", 0, size},
+		{"range", size / 4, size / 2},
+	}
+	paths := []struct {
+		name     string
+		readFrom bool
+		copy     func(w *bufio.Writer, tcp *net.TCPConn, f *os.File, start, length int64) error
+	}{
+		{"buffered", false, func(w *bufio.Writer, _ *net.TCPConn, f *os.File, start, length int64) error {
+			// 请求中的文件都是新打开的，这里每次回到开头
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if err := bufferFile(w, f, start, length); err != nil {
+				return err
+			}
+			return w.Flush()
+		}},
+		{"readfrom", true, sendFile},
+	}
+
+	for _, r := range ranges {
+		for _, p := range paths {
+			b.Run(r.name+"/"+p.name, func(b *testing.B) {
+				client, server := tcpPair(b)
+				go io.Copy(io.Discard, client)
+
+				f, err := os.Open(name)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer f.Close()
+				// 缓冲路径模拟 TLS 连接：底层连接没有 ReadFrom，
+				// 否则 bufio.Writer 会把 *os.File 直接交给 TCPConn.ReadFrom
+				var conn io.Writer = server
+				if !p.readFrom {
+					conn = struct{ io.Writer }{server}
+				}
+				w := bufio.NewWriter(conn)
+
+				b.SetBytes(r.length)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := p.copy(w, server, f, r.start, r.length); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestWriteFileLength(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "grow")
+	if err := os.WriteFile(name, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	paths := []struct {
+		name string
+		copy func(w *bufio.Writer, tcp *net.TCPConn, f *os.File, start, length int64) error
+	}{
+		{"buffered", func(w *bufio.Writer, _ *net.TCPConn, f *os.File, start, length int64) error {
+			if err := bufferFile(w, f, start, length); err != nil {
+				return err
+			}
+			return w.Flush()
+		}},
+		{"readfrom", sendFile},
+	}
+	for _, p := range paths {
+		t.Run(p.name, func(t *testing.T) {
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			client, server := tcpPair(t)
+
+			// 响应头声明 10 字节之后文件变长，多出的内容不能写入连接
+			if err := os.WriteFile(name, []byte("0123456789abcdef"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.copy(bufio.NewWriter(server), server, f, 0, 10); err != nil {
+				t.Fatal(err)
+			}
+			server.CloseWrite()
+			if got, _ := io.ReadAll(client); string(got) != "0123456789" {
+				t.Fatalf("sent %q, want the declared 10 bytes", got)
+			}
+
+			// 文件变短时报告错误，由调用方关闭连接
+			if err := os.Truncate(name, 4); err != nil {
+				t.Fatal(err)
+			}
+			client, server = tcpPair(t)
+			go io.Copy(io.Discard, client)
+			if err := p.copy(bufio.NewWriter(server), server, f, 0, 10); err != io.ErrUnexpectedEOF {
+				t.Fatalf("copy of a truncated file: error = %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
+
+// tcpPair 返回一对互连的回环 TCP 连接
+func tcpPair(tb testing.TB) (client, server *net.TCPConn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s := <-accepted
+	if s == nil {
+		tb.Fatal("accept failed")
+	}
+	tb.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	return c.(*net.TCPConn), s.(*net.TCPConn)
+}
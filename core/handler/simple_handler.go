@@ -1,15 +1,12 @@
 package handler
 
 import (
-	"compress/gzip"
-	"io"
+	"fmt"
+	"io/fs"
 	"mime"
-	"mime/multipart"
 	"net"
-	"net/url"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,12 +16,21 @@ import (
 	"github.com/Singert/xjtu_cnlab/core/server"
 	"github.com/Singert/xjtu_cnlab/core/talklog"
 	"github.com/Singert/xjtu_cnlab/core/utils"
+	"github.com/Singert/xjtu_cnlab/core/vfs"
 )
 
 // SimpleHTTPRequestHandler 实现简单的HTTP请求处理器
 type SimpleHTTPRequestHandler struct {
 	*BaseHTTPRequestHandler
-	Directory string // 提供服务的目录
+	Directory string      // 工作目录，上传、写入与 CGI 作用于此
+	FS        fs.FS       // 静态文件层的文档根，见 Docroot
+	mount     *mountPoint // 当前请求命中的挂载点，nil 表示使用 FS
+
+	rangePlan   *rangeResponse   // SendHead 选定的区间响应，nil 表示发送完整内容
+	bodyLength  int64            // 发送完整内容时 SendHead 声明的 Content-Length
+	encodePlan  *encodedResponse // SendHead 选定的动态压缩，nil 表示原样发送
+	cachedBody  []byte           // SendHead 选定的内存内容（来自文件缓存），优先于返回的文件
+	archivePlan *archiveResponse // SendHead 选定的目录打包下载
 }
 
 // NewSimpleHTTPRequestHandler 创建一个新的简单HTTP请求处理器
@@ -32,138 +38,215 @@ func NewSimpleHTTPRequestHandler(server *server.HTTPServer, conn net.Conn) *Simp
 	handler := &SimpleHTTPRequestHandler{
 		BaseHTTPRequestHandler: NewBaseHTTPRequestHandler(conn),
 		Directory:              config.Cfg.Server.Workdir,
+		FS:                     Docroot(),
 	}
 	handler.Server = server
 	handler.ProcessMethod = handler // 设置处理方法为自身
+	if config.Cfg.WebDAV.Enabled {
+		handler.registerWebDAV()
+	}
 	return handler
 }
 
+// ServeRoute 构造路由上下文并调用路由处理器
+func (h *SimpleHTTPRequestHandler) ServeRoute(handlerFunc router.HandlerFunc, params router.Params) {
+	w := NewRouteResponseWriter(h.BaseHTTPRequestHandler)
+	ctx := &router.Context{
+		Method:      h.Command,
+		Path:        h.Path,
+		Headers:     h.Headers,
+		Body:        router.NewRequestBody(h.Body, MaxBodySize()),
+		Query:       utils.ParseQuery(h.QueryRaw),
+		RawQuery:    h.QueryRaw,
+		Params:      params,
+		Conn:        h.Conn,
+		RouterAware: h.Server,
+		Writer:      w,
+		RequestID:   h.RequestID,
+	}
+	defer ctx.Cleanup()
+
+	talklog.SetPrefix(talklog.GID(), "")
+	handlerFunc(ctx)
+	w.Finish()
+	h.WFile.Flush()
+}
+
 // DoGET 处理GET请求
 func (h *SimpleHTTPRequestHandler) DoGET() {
 	gid := talklog.GID()
 	talklog.Info(gid, "Processing GET request for %s", h.Path)
 	talklog.SetPrefix(gid, "ROUTE")
 	talklog.Info(gid, "Finding route for %s", h.Path)
-	if handlerFunc, found := h.Server.Router.MatchRoute(h.Command, h.Path); found {
-		talklog.Info(gid, "Route found for %s", h.Path)
-		ctx := &router.Context{
-			Method:      "GET",
-			Path:        h.Path,
-			Headers:     h.Headers,
-			Conn:        h.Conn,
-			RouterAware: h.Server,
-			Query:       utils.ParseQuery(h.QueryRaw),
-		}
-		talklog.SetPrefix(gid, "")
-		handlerFunc(ctx)
-		h.WFile.Flush()
+	if h.DispatchRoute() {
 		return
 	}
 	talklog.Info(gid, "Trying to serve static file: %s", h.Path)
 	f, err := h.ProcessMethod.SendHead()
-	if err != nil {
+	if err != nil || f == nil && h.cachedBody == nil && h.archivePlan == nil {
+		// 错误、重定向或 304 已由 SendHead 应答
 		return
 	}
-	defer f.Close()
+	if f != nil {
+		defer f.Close()
+	}
 
 	// 发送文件内容
-	io.Copy(h.WFile, f)
+	if err := h.SendBody(f); err != nil {
+		talklog.Error(gid, "Error sending file %s: %v", h.Path, err)
+		h.CloseConnection = true
+		return
+	}
 	talklog.Info(gid, "Static file served successfully: %s", h.Path)
 	h.WFile.Flush()
 }
 
 // DoHEAD 处理HEAD请求
 func (h *SimpleHTTPRequestHandler) DoHEAD() {
+	if h.DispatchRoute() {
+		return
+	}
 	f, err := h.ProcessMethod.SendHead()
-	if err != nil {
+	if err != nil || f == nil {
 		return
 	}
 	f.Close()
 }
 
-// DoPOST handles file upload with support for target path
+// DoPOST 处理POST请求：路由优先，其余作为文件上传处理
 func (h *SimpleHTTPRequestHandler) DoPOST() {
-	if handlerFunc, found := h.Server.Router.MatchRoute(h.Command, h.Path); found {
-		ctx := &router.Context{
-			Method:  "POST",
-			Path:    h.Path,
-			Headers: h.Headers,
-			Conn:    h.Conn,
-		}
-		handlerFunc(ctx)
-		h.WFile.Flush()
+	if h.DispatchRoute() {
 		return
 	}
-	contentType := h.Headers["Content-Type"]
-	mediaType, params, err := mime.ParseMediaType(contentType)
-	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
-		h.SendError(utils.BAD_REQUEST, "Content-Type must be multipart/form-data")
-		talklog.Error(talklog.GID(), "File upload error: %v", err)
+	h.HandleUpload()
+}
 
+// DoOPTIONS 处理OPTIONS请求：路由路径由 DispatchRoute 应答，其余返回静态文件支持的方法
+func (h *SimpleHTTPRequestHandler) DoOPTIONS() {
+	if h.Path != "*" && h.DispatchRoute() {
 		return
 	}
-	boundary := params["boundary"]
-	reader := multipart.NewReader(h.RFile, boundary)
-	target := h.TranslatePath(h.Path)
-	uploadDir := strings.HasSuffix(h.Path, "/")
-	if !uploadDir {
-		if info, err := os.Stat(target); err == nil && info.IsDir() {
-			uploadDir = true
-		}
+	h.SendAllow(h.StaticMethods())
+}
+
+// DoExtension 处理 PATCH 等扩展方法，只能由路由处理
+func (h *SimpleHTTPRequestHandler) DoExtension() {
+	if h.DispatchRoute() {
+		return
 	}
-	for {
-		part, err := reader.NextPart()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			h.SendError(utils.INTERNAL_SERVER_ERROR, "Error reading multipart data")
-			talklog.Error(talklog.GID(), "File upload error: %v", err)
+	h.SendError(utils.NOT_IMPLEMENTED, fmt.Sprintf("Unsupported method (%s)", h.Command))
+}
 
-			return
-		}
-		var dest string
-		if uploadDir {
-			filename := filepath.Base(part.FileName())
-			if filename == "" {
-				continue
-			}
-			dest = filepath.Join(target, filename)
-		} else {
-			dest = target
-		}
-		dst, err := os.Create(dest)
-		if err != nil {
-			h.SendError(utils.INTERNAL_SERVER_ERROR, "Cannot create file")
-			talklog.Error(talklog.GID(), "File upload error: %v", err)
+// StaticMethods 返回静态文件路径支持的方法，允许写入的路径还支持 PUT 与 DELETE
+// 开启 WebDAV 时加上 WebDAV 的方法，挂载路径只读
+func (h *SimpleHTTPRequestHandler) StaticMethods() []string {
+	if m, _ := matchMount(h.Path); m != nil {
+		return m.methods()
+	}
+	if config.Cfg.WebDAV.Enabled {
+		return h.davMethods()
+	}
+	if h.writeAllowed() {
+		return []string{"DELETE", "GET", "HEAD", "OPTIONS", "POST", "PUT"}
+	}
+	return []string{"GET", "HEAD", "OPTIONS", "POST"}
+}
 
-			return
-		}
-		if _, err := io.Copy(dst, part); err != nil {
-			dst.Close()
-			h.SendError(utils.INTERNAL_SERVER_ERROR, "Error saving file")
-			talklog.Error(talklog.GID(), "File upload error: %v", err)
+// SendAllow 以 204 应答 OPTIONS 请求，Allow 头列出允许的方法
+func (h *SimpleHTTPRequestHandler) SendAllow(methods []string) {
+	h.SendResponse(utils.NO_CONTENT, "")
+	h.SendHeader("Allow", strings.Join(methods, ", "))
+	h.EndHeaders()
+}
 
-			return
-		}
-		dst.Close()
-		if !uploadDir {
-			break
+// DispatchRoute 将请求分发给路由，返回是否已经处理
+//   - 方法匹配：调用路由处理器
+//   - HEAD 且存在 GET 路由：执行 GET 处理器但不输出响应体
+//   - OPTIONS：返回该路径已注册的方法
+//   - 路径存在但方法不匹配：405 并附带 Allow 头
+//
+// 路径没有注册任何路由或位于挂载（mounts）之下时返回 false，由调用方继续按静态文件处理
+func (h *SimpleHTTPRequestHandler) DispatchRoute() bool {
+	if m, _ := matchMount(h.Path); m != nil {
+		return false
+	}
+	r := h.Server.Router
+	if ef := r.ErrorFormat(h.Command, h.Path); ef != nil {
+		h.ErrorMessageFormat, h.ErrorContentType = ef.Format, ef.ContentType
+	}
+	if handlerFunc, params, found := r.MatchRoute(h.Command, h.Path); found {
+		talklog.Info(talklog.GID(), "Route found for %s %s", h.Command, h.Path)
+		h.ServeRoute(handlerFunc, params)
+		return true
+	}
+	if h.Command == "HEAD" {
+		if handlerFunc, params, found := r.MatchRoute("GET", h.Path); found {
+			h.ServeRoute(handlerFunc, params)
+			return true
 		}
-		talklog.Info(talklog.GID(), "File uploaded to %s", dest)
+	}
 
+	allowed := r.AllowedMethods(h.Path)
+	if allowed == nil {
+		return false
+	}
+	if h.Command == "OPTIONS" {
+		h.SendAllow(allowed)
+		return true
 	}
-	h.SendResponse(utils.OK, "Upload successful")
+	h.AddResponseHeader("Allow", strings.Join(allowed, ", "))
+	h.SendError(utils.METHOD_NOT_ALLOWED, "", fmt.Sprintf("Method %s is not allowed for %s", h.Command, h.Path))
+	return true
+}
 
-	h.EndHeaders()
+// DoPUT 处理PUT请求：路由优先，write 段允许的路径写入静态文件
+func (h *SimpleHTTPRequestHandler) DoPUT() {
+	if h.DispatchRoute() {
+		return
+	}
+	if !h.writeAllowed() {
+		h.sendWriteNotAllowed()
+		return
+	}
+	h.HandlePut()
+}
+
+// DoDELETE 处理DELETE请求：路由优先，write 段允许的路径删除静态文件
+func (h *SimpleHTTPRequestHandler) DoDELETE() {
+	if h.DispatchRoute() {
+		return
+	}
+	if !h.writeAllowed() {
+		h.sendWriteNotAllowed()
+		return
+	}
+	h.HandleDelete()
+}
+
+// sendWriteNotAllowed 拒绝不允许写入的路径：未开启写入时保持 501，路径不在白名单时 405
+func (h *SimpleHTTPRequestHandler) sendWriteNotAllowed() {
+	if !config.Cfg.Write.Enabled && !config.Cfg.WebDAV.Enabled {
+		h.SendError(utils.NOT_IMPLEMENTED, "Method not implemented")
+		return
+	}
+	h.AddResponseHeader("Allow", strings.Join(h.StaticMethods(), ", "))
+	h.SendError(utils.METHOD_NOT_ALLOWED, "", fmt.Sprintf("Method %s is not allowed for %s", h.Command, h.Path))
 }
 
 // SendHead 发送文件头信息
-func (h *SimpleHTTPRequestHandler) SendHead() (*os.File, error) {
-	path := h.TranslatePath(h.Path)
-	var f *os.File
+func (h *SimpleHTTPRequestHandler) SendHead() (vfs.File, error) {
+	sf, ok := h.resolveStatic(h.selectMount())
+	if !ok {
+		return nil, errResponseSent
+	}
+	var f vfs.File
 	var err error
-	var returnedFile *os.File // Track the file actually returned
+	var returnedFile vfs.File // Track the file actually returned
+	h.rangePlan = nil
+	h.bodyLength = 0
+	h.encodePlan = nil
+	h.cachedBody = nil
+	h.archivePlan = nil
 
 	defer func() {
 		// If f was opened but not the file ultimately returned (e.g., replaced by tmpF or error occurred), close it.
@@ -173,16 +256,21 @@ func (h *SimpleHTTPRequestHandler) SendHead() (*os.File, error) {
 	}()
 
 	// 第一阶段：路径检查
-	stat, err := os.Stat(path)
+	stat, err := h.statStatic(sf)
 	if err != nil {
-		h.SendError(utils.NOT_FOUND, "File not found")
-		talklog.Warn(talklog.GID(), "File not found or inaccessible: %s", path)
-		return nil, err
+		// static.TryFiles 与 static.SPA 的回退
+		alt, altStat, ok := h.tryFiles(h.Path)
+		if !ok {
+			h.SendError(utils.NOT_FOUND, "File not found")
+			talklog.Warn(talklog.GID(), "File not found or inaccessible: %s", sf.name)
+			return nil, err
+		}
+		sf, stat = alt, altStat
 	}
 
 	// 第二阶段：判断是否是一个对于一个文件夹的请求
 	if stat.IsDir() {
-		talklog.Info(talklog.GID(), "Directory requested: %s", path)
+		talklog.Info(talklog.GID(), "Directory requested: %s", sf.name)
 
 		// 检查是否需要添加尾部斜杠
 		// 检查是否需要添加尾部斜杠
@@ -200,187 +288,148 @@ func (h *SimpleHTTPRequestHandler) SendHead() (*os.File, error) {
 			return nil, nil
 		}
 
-		// 查找索引文件
-		foundIndex := false
-		for _, index := range []string{"index.html", "index.htm", "index"} {
-			indexPath := filepath.Join(path, index)
-			if fs, err := os.Stat(indexPath); err == nil && !fs.IsDir() {
-				path = indexPath
-				foundIndex = true
-				break
+		// ?archive=zip|tar.gz 打包下载整个目录
+		if format := utils.ParseQuery(h.QueryRaw)["archive"]; format != "" && h.SendArchiveHead(sf.name, format) {
+			if h.archivePlan == nil {
+				return nil, errResponseSent
 			}
+			return nil, nil
 		}
 
-		if !foundIndex {
-			talklog.Info(talklog.GID(), "No index found, generating directory listing for: %s", path)
-
-			// 列目录处理
-			// Make sure ListDirectory returns nil error if it sends response itself
-			tmpFile, listErr := h.ListDirectory(path) // ListDirectory now returns the temp file or error
-			if listErr != nil {
-				// Error already sent by ListDirectory
-				return nil, listErr
-			}
-			if tmpFile != nil {
-				// ListDirectory generated content and sent headers
-				returnedFile = tmpFile // Mark tmpFile as returned
-				return tmpFile, nil    // Return the temp file with HTML listing
-			}
-			// If ListDirectory didn't return a file, it means it found an index file.
-			// Need to re-stat the index file path.
-			// Find index file again (logic duplicated from original ListDirectory check)
-			foundIndex = false
-			for _, index := range []string{"index.html", "index.htm", "index"} {
-				indexPath := filepath.Join(path, index)
-				if fs, err := os.Stat(indexPath); err == nil && !fs.IsDir() {
-					path = indexPath // Update path to the index file
-					stat = fs        // Update stat to the index file
-					foundIndex = true
-					break
-				}
+		// 查找索引文件
+		if indexFile, indexStat, ok := h.findIndex(sf); ok {
+			sf, stat = indexFile, indexStat
+		} else {
+			if h.mount != nil && !h.mount.listingAllowed() {
+				h.SendError(utils.FORBIDDEN, "Directory listing is disabled")
+				return nil, errResponseSent
 			}
-			if !foundIndex {
-				// Should have been handled by ListDirectory returning a file
-				h.SendError(utils.INTERNAL_SERVER_ERROR, "Index file logic error")
-				return nil, os.ErrNotExist // Or a more specific error
+			talklog.Info(talklog.GID(), "No index found, generating directory listing for: %s", sf.name)
+			// 列表在内存中生成，响应头已发送，内容由 SendBody 从 h.cachedBody 输出
+			if err := h.ListDirectory(sf.name, stat); err != nil {
+				return nil, err
 			}
-			// Proceed to handle the found index file
+			return nil, nil
 		}
 	}
 
 	// 第三阶段：路径验证 (Check again after potential index file resolution)
-	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator)) {
+	if !stat.Mode().IsRegular() {
 		h.SendError(utils.NOT_FOUND, "File not found")
 		return nil, os.ErrNotExist
 	}
 
-	// 第四阶段：缓存验证
-	if ims := h.Headers["If-Modified-Since"]; ims != "" {
-		modTime := stat.ModTime().UTC().Truncate(time.Second)
-		if t, err := time.Parse(time.RFC1123, ims); err == nil {
-			t = t.UTC()
-			if !modTime.After(t) {
-				h.SendResponse(utils.NOT_MODIFIED, "")
-				h.EndHeaders()
-				return nil, nil // Return nil, nil for NOT_MODIFIED
-			}
+	// 模板文件渲染后发送
+	if isTemplate(sf.name) {
+		return nil, h.sendTemplate(sf, stat)
+	}
+
+	// 第四阶段：条件请求
+	// 先确定要发送的表示（预压缩文件、动态压缩或原始内容），再用对应的 ETag 评估前置条件
+	contentType := h.GuessType(sf.name)
+	coding, sidecar, sidecarStat := h.selectEncoding(sf, stat)
+	etag := h.staticETag(sf, stat)
+	selectedETag := etag
+	switch {
+	case sidecar != nil:
+		selectedETag = VariantETag(h.staticETag(*sidecar, sidecarStat), "gzip")
+	case coding != "":
+		selectedETag = VariantETag(etag, coding)
+	}
+	vary := ""
+	if config.Cfg.Server.IsGzip {
+		vary = "Accept-Encoding"
+	}
+	if h.SendPreconditionResult(h.EvaluatePreconditions(selectedETag, stat.ModTime()), selectedETag, vary) {
+		return nil, errResponseSent
+	}
+	if vary != "" {
+		h.AddResponseHeader("Vary", vary)
+	}
+
+	// 第五阶段：预压缩文件
+	if sidecar != nil {
+		if data, ok := cachedContent(sidecar.cachePath(), sidecarStat, ""); ok {
+			h.sendCachedHead(data, stat, contentType, "gzip", selectedETag)
+			return nil, nil
 		}
+		if gz, err := h.openStatic(*sidecar); err == nil {
+			h.SendResponse(utils.OK, "")
+			h.SendHeader("Content-Encoding", "gzip")
+			h.SendHeader("Content-Type", contentType)
+			h.SendHeader("Content-Length", strconv.FormatInt(sidecarStat.Size(), 10))
+			h.bodyLength = sidecarStat.Size()
+			h.SendHeader("Accept-Ranges", "bytes") // 区间作用于原始内容
+			h.SendHeader("Last-Modified", stat.ModTime().UTC().Format(time.RFC1123))
+			h.SendHeader("ETag", selectedETag)
+			h.EndHeaders()
+			talklog.Info(talklog.GID(), "Serving precompressed %s.gz for %s", sf.name, sf.name)
+			return gz, nil
+		}
+		talklog.Warn(talklog.GID(), "Cannot open precompressed file %s, falling back", sidecar.name)
+		coding = h.ContentEncoding
+	}
+
+	// 第六阶段：小文件直接从内存缓存发送
+	if data, ok := cachedContent(sf.cachePath(), stat, coding); ok {
+		h.sendCachedHead(data, stat, contentType, coding, selectedETag)
+		if h.cachedBody == nil {
+			return nil, errResponseSent
+		}
+		return nil, nil
 	}
 
-	// 第五阶段：打开文件
-	f, err = os.Open(path)
+	// 第七阶段：打开文件
+	f, err = h.openStatic(sf)
 	if err != nil {
 		h.SendError(utils.NOT_FOUND, "File not found")
 		return nil, err
 	}
 
-	// 第六阶段: 尝试 Gzip 压缩 (如果适用)
-	h.IsGzip = config.Cfg.Server.IsGzip && h.IsGzip
-
-	if h.IsGzip {
-		tmpF, err := os.CreateTemp("", "gzip*")
-		talklog.Info(talklog.GID(), "Gzip compression enabled for: %s", path)
-
-		if err == nil {
-			// Setup deferred cleanup for the temp file
-			cleanupTemp := true // Flag to control cleanup
-			defer func() {
-				if cleanupTemp {
-					tmpF.Close()
-					os.Remove(tmpF.Name())
-				}
-			}()
-
-			gw := gzip.NewWriter(tmpF)
-			// Use TeeReader to write to gzip writer while allowing original file to be read later if needed? No, copy directly.
-			_, copyErr := io.Copy(gw, f) // Copy from original file 'f'
-			closeErr := gw.Close()       // Close the gzip writer *before* seeking/stating
-
-			if copyErr == nil && closeErr == nil {
-				// Seek to start for reading
-				if _, seekErr := tmpF.Seek(0, io.SeekStart); seekErr == nil {
-					// Get stat of the compressed file
-					if tmpStat, statErr := tmpF.Stat(); statErr == nil {
-						// Compression successful! Send headers for compressed file.
-						h.SendResponse(utils.OK, "")
-						h.SendHeader("Content-Encoding", "gzip")
-						h.SendHeader("Content-Type", h.GuessType(path))                          // Use original path for type
-						h.SendHeader("Content-Length", strconv.FormatInt(tmpStat.Size(), 10))    // Compressed size
-						h.SendHeader("Last-Modified", stat.ModTime().UTC().Format(time.RFC1123)) // Original mod time
-						h.EndHeaders()
-						talklog.Info(talklog.GID(), "File headers sent for: %s", path)
-
-						// We are returning tmpF. Prevent its deferred cleanup.
-						cleanupTemp = false
-						// The original file 'f' is no longer needed by this function or its caller. Close it now.
-						f.Close()
-						f = nil             // Ensure the main defer doesn't try to close it again
-						returnedFile = tmpF // Mark tmpF as the returned file
-						talklog.Info(talklog.GID(), "Served compressed file for %s", path)
-						return tmpF, nil // Return the compressed temp file
-					} else {
-						talklog.Error(talklog.GID(), "Error stating compressed temp file: %v", statErr)
-					}
-				} else {
-					talklog.Error(talklog.GID(), "Error seeking compressed temp file: %v", seekErr)
-				}
-			} else {
-				talklog.Error(talklog.GID(), "Error during gzip compression: copyErr=%v, closeErr=%v", copyErr, closeErr)
-				// Need to rewind original file 'f' as copy might have consumed it
-				if _, seekErr := f.Seek(0, io.SeekStart); seekErr != nil {
-					talklog.Error(talklog.GID(), "Error rewinding original file after failed compression: %v", seekErr)
-					h.SendError(utils.INTERNAL_SERVER_ERROR, "Failed to process file")
-					// The main defer will close f
-					return nil, seekErr
-				}
-			}
-			// If we reach here, compression failed. Fall through to send original file.
-			// The deferred tmpF cleanup will execute.
+	// 第八阶段：动态压缩，长度未知，HTTP/1.1 使用 chunked 流式发送
+	if coding != "" {
+		plan := &encodedResponse{coding: coding, chunked: h.RequestVersion >= "HTTP/1.1"}
+		h.encodePlan = plan
+		h.SendResponse(utils.OK, "")
+		h.SendHeader("Content-Encoding", coding)
+		h.SendHeader("Content-Type", contentType)
+		if plan.chunked {
+			h.SendHeader("Transfer-Encoding", "chunked")
 		} else {
-			talklog.Error(talklog.GID(), "Error creating temp file for gzip: %v", err)
-			// Fall through to send original file.
+			h.SendHeader("Connection", "close")
+		}
+		h.SendHeader("Accept-Ranges", "bytes") // 区间作用于原始内容
+		h.SendHeader("Last-Modified", stat.ModTime().UTC().Format(time.RFC1123))
+		h.SendHeader("ETag", selectedETag)
+		h.EndHeaders()
+		talklog.Info(talklog.GID(), "Streaming %s-encoded content for: %s", coding, sf.name)
+		returnedFile = f
+		return f, nil
+	}
+
+	// 第九阶段：发送未压缩文件的头信息
+	if h.SendRangeHead(stat, contentType, etag) {
+		if h.rangePlan == nil {
+			// 416 已经发送
+			return nil, errResponseSent
 		}
-	} else {
-		talklog.Info(talklog.GID(), "Gzip not enabled or not applicable for: %s", path)
+		returnedFile = f
+		return f, nil
 	}
-	// 第七阶段：发送未压缩文件的头信息 (if gzip not applicable or failed)
 	h.SendResponse(utils.OK, "")
-	h.SendHeader("Content-Type", h.GuessType(path))
-	h.SendHeader("Content-Length", strconv.FormatInt(stat.Size(), 10)) // Original size
+	h.SendHeader("Content-Type", contentType)
+	h.SendHeader("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	h.bodyLength = stat.Size()
+	h.SendHeader("Accept-Ranges", "bytes")
 	h.SendHeader("Last-Modified", stat.ModTime().UTC().Format(time.RFC1123))
+	h.SendHeader("ETag", etag)
 	h.EndHeaders()
-	talklog.Info(talklog.GID(), "File headers sent for: %s", path)
+	talklog.Info(talklog.GID(), "File headers sent for: %s", sf.name)
 
 	returnedFile = f // Mark f as the returned file
 	return f, nil    // Return the original file
 }
 
-// TranslatePath 将URL路径转换为文件系统路径
-func (h *SimpleHTTPRequestHandler) TranslatePath(path string) string {
-	// 去除查询参数
-	if idx := strings.Index(path, "?"); idx != -1 {
-		path = path[:idx]
-	}
-
-	// 解码URL编码
-	decodedPath, err := url.PathUnescape(path)
-	if err != nil {
-		decodedPath = path
-	}
-
-	// 规范化路径
-	decodedPath = filepath.Clean(decodedPath)
-
-	// 确保路径以 / 开头
-	if !strings.HasPrefix(decodedPath, "/") {
-		decodedPath = "/" + decodedPath
-	}
-
-	// 将URL路径转换为文件系统路径
-	result := filepath.Join(h.Directory, decodedPath[1:])
-	return result
-}
-
 // GuessType 猜测文件的MIME类型
 func (h *SimpleHTTPRequestHandler) GuessType(path string) string {
 	ext := filepath.Ext(path)
@@ -396,110 +445,3 @@ func (h *SimpleHTTPRequestHandler) GuessType(path string) string {
 	// 默认类型
 	return "application/octet-stream"
 }
-
-// ListDirectory 列出目录内容
-// Returns the temporary file containing the HTML listing, or nil if an index file was found/handled.
-// Sends error responses internally.
-func (h *SimpleHTTPRequestHandler) ListDirectory(path string) (*os.File, error) {
-	// Check for index files first (moved from SendHead)
-	for _, index := range []string{"index.html", "index.htm", "index"} {
-		indexPath := filepath.Join(path, index)
-		if fs, err := os.Stat(indexPath); err == nil && !fs.IsDir() {
-			// Found an index file, let SendHead handle it.
-			// Indicate success but no file to return from here.
-			return nil, nil
-		}
-	}
-
-	// No index file found, proceed with listing
-	d, err := os.Open(path)
-	if err != nil {
-		h.SendError(utils.INTERNAL_SERVER_ERROR, "Cannot open directory for listing")
-		return nil, err
-	}
-	defer d.Close()
-
-	files, err := d.Readdir(-1)
-	if err != nil {
-		h.SendError(utils.INTERNAL_SERVER_ERROR, "Error reading directory")
-		return nil, err
-	}
-
-	// Sort file list
-	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
-
-	// Build HTML page
-	displayPath := h.Path
-	if !strings.HasSuffix(displayPath, "/") {
-		displayPath += "/"
-	}
-
-	title := "Directory listing for " + displayPath
-
-	html := "<!DOCTYPE HTML>\n"
-	html += "<html>\n"
-	html += "<head>\n"
-	html += "<meta charset=\"utf-8\">\n"
-	html += "<title>" + title + "</title>\n"
-	html += "</head>\n"
-	html += "<body>\n"
-	html += "<h1>" + title + "</h1>\n"
-	html += "<hr>\n"
-	html += "<ul>\n"
-
-	// 添加上级目录链接
-	if displayPath != "/" {
-		html += "<li><a href=\"../\">../</a></li>\n"
-	}
-
-	// 添加文件和目录链接
-	for _, file := range files {
-		name := file.Name()
-		if file.IsDir() {
-			name += "/"
-		}
-		html += "<li><a href=\"" + name + "\">" + name + "</a></li>\n"
-	}
-
-	html += "</ul>\n"
-	html += "<hr>\n"
-	html += "</body>\n"
-	html += "</html>\n"
-
-	// Create temporary file and write HTML content
-	tmpFile, err := os.CreateTemp("", "dirlist*.html")
-	if err != nil {
-		talklog.Error(talklog.GID(), "Failed to list directory: %s, error: %v", path, err)
-
-		h.SendError(utils.INTERNAL_SERVER_ERROR, "Error creating temporary file for listing")
-
-		return nil, err
-	}
-
-	if _, err := tmpFile.WriteString(html); err != nil {
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
-		talklog.Error(talklog.GID(), "Failed to list directory: %s, error: %v", path, err)
-
-		h.SendError(utils.INTERNAL_SERVER_ERROR, "Error writing directory listing")
-		return nil, err
-	}
-	if _, err := tmpFile.Seek(0, 0); err != nil {
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
-		talklog.Error(talklog.GID(), "Failed to list directory: %s, error: %v", path, err)
-
-		h.SendError(utils.INTERNAL_SERVER_ERROR, "Error seeking in directory listing file")
-		return nil, err
-	}
-	talklog.Info(talklog.GID(), "Generated directory listing for: %s", path)
-	// Send response headers *before* returning the file
-	h.SendResponse(utils.OK, "")
-	h.SendHeader("Content-Type", "text/html; charset=utf-8")
-	h.SendHeader("Content-Length", strconv.Itoa(len(html)))
-	// Add Last-Modified? Maybe based on directory mod time? For now, omit.
-	h.EndHeaders()
-
-	// Return the temporary file; caller (SendHead/DoGET) is responsible for closing it.
-	return tmpFile, nil
-}
@@ -0,0 +1,293 @@
+package handler
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+	"github.com/fsnotify/fsnotify"
+)
+
+const defaultTemplateExt = ".tmpl"
+
+// TemplateData 页面模板可以使用的数据
+type TemplateData struct {
+	Method        string
+	Path          string
+	Query         map[string]string
+	RawQuery      string
+	Headers       map[string]string // 请求头，键为规范形式，如 "User-Agent"
+	ClientAddress string
+	RequestID     string
+	Server        ServerInfo
+	Now           time.Time
+}
+
+// ServerInfo 页面模板可以使用的服务器信息
+type ServerInfo struct {
+	Name      string
+	Version   string
+	GoVersion string
+	OS        string
+	Arch      string
+	StartTime time.Time
+	Uptime    time.Duration
+}
+
+// templateExt 返回开启的模板扩展名，未开启时为空
+func templateExt() string {
+	cfg := config.Cfg.Templates
+	if !cfg.Enabled {
+		return ""
+	}
+	if cfg.Extension == "" {
+		return defaultTemplateExt
+	}
+	return "." + strings.TrimPrefix(cfg.Extension, ".")
+}
+
+// isTemplate 报告文件是否需要按模板渲染
+func isTemplate(name string) bool {
+	ext := templateExt()
+	return ext != "" && strings.HasSuffix(name, ext) && len(name) > len(ext)
+}
+
+// ---- 模板缓存 ----
+
+// pageTemplate 解析后的页面模板，页面的修改时间变化后重新解析
+type pageTemplate struct {
+	modTime time.Time
+	tmpl    *template.Template
+}
+
+// partialSet 片段目录中的模板文件
+// 目录通过 fsnotify 监视，其中任何文件变化都会重新列出片段并清空已解析的页面模板；
+// 无法监视时 watcher 为 nil，每次请求重新列出片段且不缓存页面模板，保证不会使用过期的片段
+type partialSet struct {
+	dir     string
+	files   []string
+	watcher *fsnotify.Watcher
+}
+
+var (
+	templatesMu   sync.Mutex
+	pageTemplates = make(map[string]*pageTemplate)
+	partials      *partialSet // nil 表示尚未加载
+	templatesGen  uint64      // 每次失效加一，用于丢弃失效前解析的结果
+)
+
+func init() {
+	config.OnReload(func() {
+		templatesMu.Lock()
+		defer templatesMu.Unlock()
+		resetTemplates()
+	})
+}
+
+// resetTemplates 清空已解析的模板并停止监视片段目录，调用方持有 templatesMu
+func resetTemplates() {
+	pageTemplates = make(map[string]*pageTemplate)
+	templatesGen++
+	if partials != nil && partials.watcher != nil {
+		partials.watcher.Close()
+	}
+	partials = nil
+}
+
+// partialsDir 返回片段目录
+func partialsDir() string {
+	dir := config.Cfg.Templates.Dir
+	if dir == "" || filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(config.Cfg.Server.Workdir, dir)
+}
+
+// listPartials 返回片段目录中的模板文件，按名称排序
+func listPartials(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range entries {
+		if !isTemplate(e.Name()) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(files)
+	return files
+}
+
+// watchPartials 开始监视片段目录并列出其中的模板，调用方持有 templatesMu
+func watchPartials(dir string) *partialSet {
+	p := &partialSet{dir: dir}
+	if dir == "" {
+		return p
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		talklog.Warn(talklog.GID(), "Cannot watch template partials %s, templates will not be cached: %v", dir, err)
+		return p
+	}
+	// 先建立监视再列出，保证之后的变化一定能使缓存失效
+	if err := w.Add(dir); err != nil {
+		w.Close()
+		talklog.Warn(talklog.GID(), "Cannot watch template partials %s, templates will not be cached: %v", dir, err)
+		return p
+	}
+	p.watcher = w
+	p.files = listPartials(dir)
+	go p.watch()
+	return p
+}
+
+// watch 处理片段目录的文件系统事件
+func (p *partialSet) watch() {
+	for {
+		select {
+		case ev, ok := <-p.watcher.Events:
+			if !ok {
+				return
+			}
+			templatesMu.Lock()
+			if partials == p {
+				if filepath.Clean(ev.Name) == filepath.Clean(p.dir) && (ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename)) {
+					// 目录本身被删除或移走，下次请求时重新监视
+					resetTemplates()
+				} else {
+					p.files = listPartials(p.dir)
+					pageTemplates = make(map[string]*pageTemplate)
+					templatesGen++
+				}
+			}
+			templatesMu.Unlock()
+		case err, ok := <-p.watcher.Errors:
+			if !ok {
+				return
+			}
+			// 可能丢失了事件，保守起见重新监视
+			talklog.Warn(talklog.GID(), "Template partials watcher error, purging: %v", err)
+			templatesMu.Lock()
+			if partials == p {
+				resetTemplates()
+			}
+			templatesMu.Unlock()
+		}
+	}
+}
+
+// loadTemplate 返回页面模板，片段目录中的模板以文件名为名称一同解析
+func (h *SimpleHTTPRequestHandler) loadTemplate(sf staticFile, stat os.FileInfo) (*template.Template, error) {
+	key := sf.name
+	if h.mount != nil {
+		key = h.mount.prefix + "\x00" + key
+	}
+
+	templatesMu.Lock()
+	if partials == nil {
+		partials = watchPartials(partialsDir())
+	}
+	p, files, gen := partials, partials.files, templatesGen
+	cached := pageTemplates[key]
+	templatesMu.Unlock()
+	cacheable := p.dir == "" || p.watcher != nil
+	if !cacheable {
+		files = listPartials(p.dir)
+	} else if cached != nil && cached.modTime.Equal(stat.ModTime()) {
+		return cached.tmpl, nil
+	}
+
+	src, err := fs.ReadFile(h.FS, sf.name)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := template.New(path.Base(sf.name))
+	if len(files) > 0 {
+		if _, err := tmpl.ParseFiles(files...); err != nil {
+			return nil, err
+		}
+	}
+	// 页面与片段同名时以页面的内容为准
+	if _, err := tmpl.Parse(string(src)); err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		templatesMu.Lock()
+		if gen == templatesGen {
+			pageTemplates[key] = &pageTemplate{modTime: stat.ModTime(), tmpl: tmpl}
+		}
+		templatesMu.Unlock()
+	}
+	talklog.Info(talklog.GID(), "Parsed template %s", sf.name)
+	return tmpl, nil
+}
+
+// sendTemplate 渲染模板文件并发送响应头，内容存入 h.cachedBody 由 SendBody 输出
+// 输出是动态的，不参与条件请求与区间请求；出错时应答 500 并返回 errResponseSent
+func (h *SimpleHTTPRequestHandler) sendTemplate(sf staticFile, stat os.FileInfo) error {
+	tmpl, err := h.loadTemplate(sf, stat)
+	if err != nil {
+		talklog.Error(talklog.GID(), "Cannot load template %s: %v", sf.name, err)
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Template error")
+		return errResponseSent
+	}
+
+	data := &TemplateData{
+		Method:        h.Command,
+		Path:          h.Path,
+		Query:         utils.ParseQuery(h.QueryRaw),
+		RawQuery:      h.QueryRaw,
+		Headers:       h.Headers,
+		ClientAddress: h.ClientAddress,
+		RequestID:     h.RequestID,
+		Server: ServerInfo{
+			Name:      config.GoHTTPServerName(),
+			Version:   config.GoHTTPServerVersion(),
+			GoVersion: config.GoVersion(),
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+			StartTime: config.Cfg.StartTime,
+			Uptime:    time.Since(config.Cfg.StartTime),
+		},
+		Now: time.Now(),
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		talklog.Error(talklog.GID(), "Cannot render template %s: %v", sf.name, err)
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Template error")
+		return errResponseSent
+	}
+
+	// page.html.tmpl 按 page.html 确定类型，没有其他扩展名时视为 HTML
+	contentType := "text/html; charset=utf-8"
+	if inner := strings.TrimSuffix(sf.name, templateExt()); path.Ext(inner) != "" {
+		contentType = h.GuessType(inner)
+	}
+	h.cachedBody = append([]byte{}, buf.Bytes()...)
+	h.SendResponse(utils.OK, "")
+	h.SendHeader("Content-Type", contentType)
+	h.SendHeader("Content-Length", strconv.Itoa(len(h.cachedBody)))
+	h.SendHeader("Cache-Control", "no-cache")
+	h.EndHeaders()
+	talklog.Info(talklog.GID(), "Rendered template %s (%d bytes)", sf.name, len(h.cachedBody))
+	return nil
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+)
+
+// withTemplates 临时开启模板并使用 dir 作为片段目录，结束时清空模板缓存
+func withTemplates(t *testing.T, dir string) {
+	t.Helper()
+	saved := config.Cfg.Templates
+	reset := func() {
+		templatesMu.Lock()
+		resetTemplates()
+		templatesMu.Unlock()
+	}
+	t.Cleanup(func() {
+		config.Cfg.Templates = saved
+		reset()
+	})
+	config.Cfg.Templates.Enabled = true
+	config.Cfg.Templates.Extension = ""
+	config.Cfg.Templates.Dir = dir
+	reset()
+}
+
+// renderTemplate 加载并渲染页面模板
+func renderTemplate(t *testing.T, h *SimpleHTTPRequestHandler, name string) (*template.Template, string) {
+	t.Helper()
+	stat, err := os.Stat(filepath.Join(h.Directory, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := h.loadTemplate(staticFile{name: name}, stat)
+	if err != nil {
+		t.Fatalf("loadTemplate(%s): %v", name, err)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, nil); err != nil {
+		t.Fatal(err)
+	}
+	return tmpl, out.String()
+}
+
+func TestTemplatePartialsInvalidation(t *testing.T) {
+	root, partialsDir := t.TempDir(), t.TempDir()
+	write := func(name, src string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(root, "page.tmpl"), `[{{template "nav.tmpl"}}]`)
+	write(filepath.Join(partialsDir, "nav.tmpl"), "one")
+	withTemplates(t, partialsDir)
+	h := &SimpleHTTPRequestHandler{Directory: root, FS: os.DirFS(root)}
+
+	first, out := renderTemplate(t, h, "page.tmpl")
+	if out != "[one]" {
+		t.Fatalf("render = %q, want [one]", out)
+	}
+	if again, _ := renderTemplate(t, h, "page.tmpl"); again != first {
+		t.Fatal("unchanged template should be served from the cache")
+	}
+
+	// 片段变化由监视器通知，异步生效
+	write(filepath.Join(partialsDir, "nav.tmpl"), "two")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, out = renderTemplate(t, h, "page.tmpl"); out == "[two]" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("render after changing the partial = %q, want [two]", out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 页面本身按修改时间失效
+	write(filepath.Join(root, "page.tmpl"), `({{template "nav.tmpl"}})`)
+	os.Chtimes(filepath.Join(root, "page.tmpl"), time.Now(), time.Now().Add(time.Hour))
+	if _, out = renderTemplate(t, h, "page.tmpl"); out != "(two)" {
+		t.Fatalf("render after changing the page = %q, want (two)", out)
+	}
+}
+
+func TestTemplatePartialsUnwatchable(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "page.tmpl"), []byte("page"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// 片段目录不存在时无法监视，每次都重新解析
+	withTemplates(t, filepath.Join(root, "missing"))
+	h := &SimpleHTTPRequestHandler{Directory: root, FS: os.DirFS(root)}
+
+	first, out := renderTemplate(t, h, "page.tmpl")
+	if out != "page" {
+		t.Fatalf("render = %q, want page", out)
+	}
+	if again, _ := renderTemplate(t, h, "page.tmpl"); again == first {
+		t.Fatal("templates should not be cached while the partials cannot be watched")
+	}
+}
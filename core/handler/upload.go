@@ -0,0 +1,499 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+	"golang.org/x/text/unicode/norm"
+)
+
+// 上传的默认上限
+const (
+	defaultUploadMaxFileSize    = 100 << 20
+	defaultUploadMaxRequestSize = 256 << 20
+	maxFilenameBytes            = 255
+	maxRenameAttempts           = 1000
+)
+
+// 覆盖策略：目标文件已存在时的处理方式
+const (
+	OverwriteReject  = "reject"  // 应答 409
+	OverwriteRename  = "rename"  // 另存为 "name (1).ext"
+	OverwriteReplace = "replace" // 原子替换已有文件
+)
+
+var (
+	errUploadTooLarge = errors.New("upload exceeds size limit")
+	// ErrFileExists 目标文件已存在且覆盖策略不允许替换
+	ErrFileExists = errors.New("upload target already exists")
+)
+
+// uploadError 携带应答状态码的上传错误
+type uploadError struct {
+	status utils.HTTPStatus
+	msg    string
+	err    error
+}
+
+func (e *uploadError) Error() string { return e.msg }
+func (e *uploadError) Unwrap() error { return e.err }
+
+// UploadedFile 上传成功的文件，作为 JSON 响应的一项
+type UploadedFile struct {
+	Field       string `json:"field"`
+	Name        string `json:"name"` // 清理后实际保存的文件名
+	Path        string `json:"path"` // 可访问该文件的 URL 路径
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type"`
+	Replaced    bool   `json:"replaced,omitempty"`
+
+	tmp  string
+	dest string
+}
+
+// uploadLimits 返回单文件与单个请求的大小上限
+func uploadLimits() (maxFile, maxRequest int64) {
+	maxFile, maxRequest = config.Cfg.Upload.MaxFileSize, config.Cfg.Upload.MaxRequestSize
+	if maxFile <= 0 {
+		maxFile = defaultUploadMaxFileSize
+	}
+	if maxRequest <= 0 {
+		maxRequest = defaultUploadMaxRequestSize
+	}
+	return maxFile, maxRequest
+}
+
+// overwritePolicy 返回配置的覆盖策略，默认 reject
+func overwritePolicy() string {
+	switch p := strings.ToLower(config.Cfg.Upload.Overwrite); p {
+	case OverwriteRename, OverwriteReplace:
+		return p
+	}
+	return OverwriteReject
+}
+
+// windowsReserved Windows 上不能作为文件名（忽略扩展名）的设备名
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename 清理客户端提供的文件名，无法得到安全的名称时返回空字符串
+//
+//   - 只保留最后一段路径（同时按 / 与 \ 分隔），统一为 NFC
+//   - 控制字符、格式字符（如双向文本覆盖、零宽字符）及 <>:"|?* 替换为 _
+//   - 去掉首尾空白和结尾的点，开头的点替换为 _（避免生成隐藏文件）
+//   - Windows 保留设备名前加 _，超长时保留扩展名截断到 255 字节
+func SanitizeFilename(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = name[strings.LastIndexByte(name, '/')+1:]
+	name = norm.NFC.String(strings.ToValidUTF8(name, "_"))
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r), r == utf8.RuneError:
+			return '_'
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRightFunc(strings.TrimSpace(name), func(r rune) bool { return r == '.' || unicode.IsSpace(r) })
+	if name == "" || strings.Trim(name, "_") == "" {
+		return ""
+	}
+	if name[0] == '.' {
+		name = "_" + name[1:]
+	}
+
+	base, _, _ := strings.Cut(name, ".")
+	if windowsReserved[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+
+	if len(name) > maxFilenameBytes {
+		ext := path.Ext(name)
+		if len(ext) > 32 {
+			ext = ""
+		}
+		stem := name[:maxFilenameBytes-len(ext)]
+		for !utf8.ValidString(stem) {
+			stem = stem[:len(stem)-1]
+		}
+		name = stem + ext
+	}
+	return name
+}
+
+// uploadAllowed 按 upload.AllowedExtensions 与 upload.AllowedTypes 检查文件，列表为空表示不限制
+func uploadAllowed(name, contentType string) bool {
+	cfg := config.Cfg.Upload
+	if len(cfg.AllowedExtensions) > 0 {
+		ext := strings.ToLower(filepath.Ext(name))
+		ok := false
+		for _, allowed := range cfg.AllowedExtensions {
+			allowed = strings.ToLower(strings.TrimSpace(allowed))
+			if !strings.HasPrefix(allowed, ".") {
+				allowed = "." + allowed
+			}
+			if ext == allowed {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(cfg.AllowedTypes) > 0 {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return false
+		}
+		for _, t := range cfg.AllowedTypes {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1]) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// linkNoReplace 将 tmp 以 dest 为名发布，dest 已存在时返回 ErrFileExists
+// 优先使用硬链接保证不会覆盖，文件系统不支持时退回检查后重命名
+func linkNoReplace(tmp, dest string) error {
+	err := os.Link(tmp, dest)
+	if err == nil {
+		return os.Remove(tmp)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return ErrFileExists
+	}
+	if _, statErr := os.Lstat(dest); statErr == nil {
+		return ErrFileExists
+	}
+	return os.Rename(tmp, dest)
+}
+
+// renameCandidate 返回第 n 个备选文件名，如 "a.txt" -> "a (1).txt"
+func renameCandidate(name string, n int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// PublishFile 按 upload.Overwrite 策略把临时文件 tmp 发布为 dir 下的 name
+// tmp 必须与 dir 位于同一文件系统。返回实际使用的文件名及是否替换了已有文件；
+// 因同名文件而无法发布时返回的错误满足 errors.Is(err, ErrFileExists)
+func PublishFile(tmp, dir, name string) (string, bool, error) {
+	dest := filepath.Join(dir, name)
+	switch overwritePolicy() {
+	case OverwriteReplace:
+		replaced := false
+		if fi, err := os.Lstat(dest); err == nil {
+			if fi.IsDir() {
+				return "", false, fmt.Errorf("%w: %s is a directory", ErrFileExists, name)
+			}
+			replaced = true
+		}
+		return name, replaced, os.Rename(tmp, dest)
+	case OverwriteRename:
+		for n := 0; n <= maxRenameAttempts; n++ {
+			candidate := name
+			if n > 0 {
+				candidate = renameCandidate(name, n)
+			}
+			err := linkNoReplace(tmp, filepath.Join(dir, candidate))
+			if err == nil {
+				return candidate, false, nil
+			}
+			if !errors.Is(err, ErrFileExists) {
+				return "", false, err
+			}
+		}
+		return "", false, fmt.Errorf("%w: no free name for %s", ErrFileExists, name)
+	default:
+		if err := linkNoReplace(tmp, dest); err != nil {
+			return "", false, err
+		}
+		return name, false, nil
+	}
+}
+
+// commitUpload 发布暂存的上传文件，同名冲突转换为 409
+func commitUpload(u *UploadedFile) error {
+	dir := filepath.Dir(u.dest)
+	name, replaced, err := PublishFile(u.tmp, dir, u.Name)
+	if errors.Is(err, ErrFileExists) {
+		return &uploadError{utils.CONFLICT, "File already exists: " + u.Name, err}
+	}
+	if err != nil {
+		return err
+	}
+	u.Name, u.dest, u.Replaced = name, filepath.Join(dir, name), replaced
+	return nil
+}
+
+// CheckTarget 提前检查按 upload.Overwrite 策略能否把文件发布为 dir 下的 name，
+// 不能时返回的错误满足 errors.Is(err, ErrFileExists)；检查之后 PublishFile 仍可能遇到冲突
+func CheckTarget(dir, name string) error {
+	fi, err := os.Lstat(filepath.Join(dir, name))
+	if err != nil {
+		return nil
+	}
+	switch overwritePolicy() {
+	case OverwriteRename:
+		return nil
+	case OverwriteReplace:
+		if !fi.IsDir() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrFileExists, name)
+}
+
+// checkConflicts 发布前检查所有文件：任何一个文件按 CheckTarget 无法发布，或在 reject
+// 策略下与同一请求中的其他文件同名，都使整个上传失败，不会只发布其中一部分
+func checkConflicts(files []*UploadedFile) error {
+	reject := overwritePolicy() == OverwriteReject
+	seen := make(map[string]bool)
+	for _, u := range files {
+		err := CheckTarget(filepath.Dir(u.dest), u.Name)
+		if err == nil && reject && seen[u.dest] {
+			err = ErrFileExists
+		}
+		if err != nil {
+			return &uploadError{utils.CONFLICT, "File already exists: " + u.Name, err}
+		}
+		seen[u.dest] = true
+	}
+	return nil
+}
+
+// rollbackUploads 删除已发布的新文件，用于检查之后才出现的冲突（如并发上传同名文件）
+// 被替换的文件已无法恢复，保留新内容
+func rollbackUploads(files []*UploadedFile) {
+	for _, u := range files {
+		if !u.Replaced {
+			if err := os.Remove(u.dest); err != nil {
+				talklog.Error(talklog.GID(), "Cannot roll back upload %s: %v", u.dest, err)
+			}
+		}
+	}
+}
+
+// stageUpload 把一个文件分段写入目标目录下的临时文件，同时计算大小与 SHA-256
+func stageUpload(part io.Reader, dir string, maxFile int64) (tmp string, size int64, sum string, err error) {
+	f, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	hash := sha256.New()
+	size, err = io.Copy(io.MultiWriter(f, hash), io.LimitReader(part, maxFile+1))
+	if err != nil {
+		return "", 0, "", err
+	}
+	if size > maxFile {
+		return "", 0, "", errUploadTooLarge
+	}
+	// CreateTemp 创建的文件只有属主可读，发布后应与普通上传的文件一样可被服务读取
+	if err = f.Chmod(0o644); err != nil {
+		return "", 0, "", err
+	}
+	if err = f.Sync(); err != nil {
+		return "", 0, "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", 0, "", err
+	}
+	return f.Name(), size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// HandleUpload 处理 multipart/form-data 文件上传
+//
+// 请求路径以 / 结尾或是已存在的目录时，每个文件分段以清理后的文件名保存到该目录；
+// 否则只保存第一个文件分段，文件名取自请求路径。所有分段先写入同目录下的临时文件，
+// 全部接收成功后才按 upload.Overwrite 策略原子地发布，任何错误都不会留下不完整的文件；
+// 多个文件要么全部发布，要么都不发布。
+// 成功时应答 201 与 JSON 格式的文件列表（名称、大小、SHA-256）。
+func (h *SimpleHTTPRequestHandler) HandleUpload() {
+	gid := talklog.GID()
+	mediaType, params, err := mime.ParseMediaType(h.Headers["Content-Type"])
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		h.SendError(utils.BAD_REQUEST, "Content-Type must be multipart/form-data")
+		talklog.Error(gid, "File upload error: %v", err)
+		return
+	}
+
+	if m, _ := matchMount(h.Path); m != nil && !(m.Upload && m.dir != "") {
+		h.AddResponseHeader("Allow", strings.Join(m.methods(), ", "))
+		h.SendError(utils.METHOD_NOT_ALLOWED, "", fmt.Sprintf("Uploads are not allowed for %s", h.Path))
+		return
+	}
+
+	maxFile, maxRequest := uploadLimits()
+	if h.ContentLength > maxRequest {
+		h.CloseConnection = true
+		h.SendError(utils.REQUEST_ENTITY_TOO_LARGE, "", fmt.Sprintf("Upload exceeds %d bytes", maxRequest))
+		return
+	}
+
+	target, ok := h.resolveRequestPath(h.Path)
+	if !ok {
+		return
+	}
+	uploadDir := strings.HasSuffix(h.Path, "/")
+	if !uploadDir {
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			uploadDir = true
+		}
+	}
+	dir, urlDir := target, h.Path
+	if !uploadDir {
+		dir, urlDir = filepath.Dir(target), path.Dir(h.Path)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		h.SendError(utils.NOT_FOUND, "Upload directory not found")
+		return
+	}
+
+	var files []*UploadedFile
+	defer func() {
+		// 未发布的临时文件一律删除
+		for _, u := range files {
+			if u.tmp != "" {
+				os.Remove(u.tmp)
+			}
+		}
+	}()
+
+	body := &io.LimitedReader{R: h.Body, N: maxRequest + 1}
+	reader := multipart.NewReader(body, params["boundary"])
+	fail := func(err error) {
+		h.CloseConnection = true
+		var ue *uploadError
+		switch {
+		case errors.As(err, &ue):
+			h.SendError(ue.status, "", ue.msg)
+		case err == errUploadTooLarge:
+			h.SendError(utils.REQUEST_ENTITY_TOO_LARGE, "", fmt.Sprintf("File exceeds %d bytes", maxFile))
+		case body.N <= 0:
+			h.SendError(utils.REQUEST_ENTITY_TOO_LARGE, "", fmt.Sprintf("Upload exceeds %d bytes", maxRequest))
+		default:
+			h.SendError(utils.BAD_REQUEST, "Error reading multipart data")
+		}
+		talklog.Error(gid, "File upload error: %v", err)
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+		if part.FileName() == "" {
+			// 普通表单字段
+			part.Close()
+			continue
+		}
+		if !uploadDir && len(files) > 0 {
+			part.Close()
+			continue
+		}
+
+		rawName := part.FileName()
+		if !uploadDir {
+			rawName = filepath.Base(target)
+		}
+		name := SanitizeFilename(rawName)
+		if name == "" {
+			fail(&uploadError{utils.BAD_REQUEST, fmt.Sprintf("Invalid file name (%q)", rawName), nil})
+			return
+		}
+		contentType := part.Header.Get("Content-Type")
+		if contentType == "" || contentType == "application/octet-stream" {
+			contentType = h.GuessType(name)
+		}
+		if _, err := h.TranslatePath(path.Join(urlDir, name)); err != nil {
+			fail(&uploadError{utils.FORBIDDEN, fmt.Sprintf("File name not allowed (%s)", name), err})
+			return
+		}
+		if !uploadAllowed(name, contentType) {
+			fail(&uploadError{utils.UNSUPPORTED_MEDIA_TYPE, fmt.Sprintf("File type not allowed (%s, %s)", name, contentType), nil})
+			return
+		}
+
+		tmp, size, sum, err := stageUpload(part, dir, maxFile)
+		part.Close()
+		if err != nil {
+			fail(err)
+			return
+		}
+		files = append(files, &UploadedFile{
+			Field: part.FormName(), Name: name, Size: size, SHA256: sum, ContentType: contentType,
+			tmp: tmp, dest: filepath.Join(dir, name),
+		})
+	}
+	if len(files) == 0 {
+		h.SendError(utils.BAD_REQUEST, "No file in upload")
+		return
+	}
+
+	if err := checkConflicts(files); err != nil {
+		fail(err)
+		return
+	}
+	for i, u := range files {
+		if err := commitUpload(u); err != nil {
+			rollbackUploads(files[:i])
+			fail(err)
+			return
+		}
+		u.tmp = ""
+	}
+	for _, u := range files {
+		u.Path = (&url.URL{Path: path.Join(urlDir, u.Name)}).EscapedPath()
+		talklog.Info(gid, "File uploaded to %s (%d bytes, sha256 %s)", u.dest, u.Size, u.SHA256)
+	}
+
+	data, _ := json.Marshal(struct {
+		Files []*UploadedFile `json:"files"`
+	}{files})
+	h.SendResponse(utils.CREATED, "")
+	h.SendHeader("Content-Type", "application/json; charset=utf-8")
+	h.SendHeader("Content-Length", strconv.Itoa(len(data)))
+	h.EndHeaders()
+	h.WFile.Write(data)
+}
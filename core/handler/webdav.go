@@ -0,0 +1,680 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+// WebDAV 模式的默认上限
+const (
+	defaultMaxPropfindEntries = 10000
+	maxDAVBody                = 1 << 20 // PROPFIND/PROPPATCH/LOCK 请求体的上限
+)
+
+var errPropfindTooLarge = errors.New("propfind exceeds entry limit")
+
+// registerWebDAV 注册 WebDAV class 1/2 的方法；PUT/DELETE/OPTIONS 替换为检查锁与递归删除的版本
+func (h *SimpleHTTPRequestHandler) registerWebDAV() {
+	h.HandleMethod("OPTIONS", h.davOptions)
+	h.HandleMethod("PROPFIND", func() {
+		if h.DispatchRoute() {
+			return
+		}
+		h.HandlePropfind()
+	})
+	writes := map[string]func(){
+		"PUT":       h.davPut,
+		"DELETE":    h.davDelete,
+		"PROPPATCH": h.HandleProppatch,
+		"MKCOL":     h.HandleMkcol,
+		"COPY":      func() { h.handleCopyMove(false) },
+		"MOVE":      func() { h.handleCopyMove(true) },
+		"LOCK":      h.HandleLock,
+		"UNLOCK":    h.HandleUnlock,
+	}
+	for method, fn := range writes {
+		h.HandleMethod(method, func() {
+			if h.DispatchRoute() {
+				return
+			}
+			if !h.writeAllowed() {
+				h.sendWriteNotAllowed()
+				return
+			}
+			fn()
+		})
+	}
+}
+
+// davMethods 返回 WebDAV 模式下静态路径支持的方法
+func (h *SimpleHTTPRequestHandler) davMethods() []string {
+	methods := []string{"GET", "HEAD", "OPTIONS", "POST", "PROPFIND"}
+	if h.writeAllowed() {
+		methods = append(methods, "COPY", "DELETE", "LOCK", "MKCOL", "MOVE", "PROPPATCH", "PUT", "UNLOCK")
+		sort.Strings(methods)
+	}
+	return methods
+}
+
+// davOptions 在 OPTIONS 应答中声明支持 WebDAV class 1 与 2
+func (h *SimpleHTTPRequestHandler) davOptions() {
+	if h.Path != "*" && h.DispatchRoute() {
+		return
+	}
+	h.AddResponseHeader("DAV", "1, 2")
+	h.AddResponseHeader("MS-Author-Via", "DAV")
+	h.SendAllow(h.StaticMethods())
+}
+
+// sendXML 经由普通的响应流程发送 XML 应答
+func (h *SimpleHTTPRequestHandler) sendXML(code utils.HTTPStatus, body string) {
+	data := []byte(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + body)
+	h.SendResponse(code, "")
+	h.SendHeader("Content-Type", "application/xml; charset=utf-8")
+	h.SendHeader("Content-Length", strconv.Itoa(len(data)))
+	h.EndHeaders()
+	h.WFile.Write(data)
+}
+
+// readDAVBody 读取并解析 XML 请求体，返回请求体是否非空；出错时已经应答
+func (h *SimpleHTTPRequestHandler) readDAVBody(v any) (present, ok bool) {
+	if h.Body == nil || h.ContentLength == 0 {
+		return false, true
+	}
+	data, err := io.ReadAll(io.LimitReader(h.Body, maxDAVBody+1))
+	if err != nil {
+		h.CloseConnection = true
+		h.SendError(utils.BAD_REQUEST, "Error reading request body")
+		return false, false
+	}
+	if len(data) > maxDAVBody {
+		h.CloseConnection = true
+		h.SendError(utils.REQUEST_ENTITY_TOO_LARGE, "", fmt.Sprintf("XML body exceeds %d bytes", maxDAVBody))
+		return false, false
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return false, true
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		h.SendError(utils.BAD_REQUEST, "", "Malformed XML body: "+err.Error())
+		return false, false
+	}
+	return true, true
+}
+
+// davConfirm 检查写操作是否被 WebDAV 锁阻止，被阻止时应答 423 并返回 false
+// recursive 时还检查路径之下的锁；member 为 true 表示操作会增删集合成员，父集合上的锁也要检查
+func (h *SimpleHTTPRequestHandler) davConfirm(urlPath string, recursive, member bool) bool {
+	tokens := submittedTokens(h.Headers["If"])
+	err := davLocks.Confirm(urlPath, recursive, tokens)
+	if err == nil && member && urlPath != "/" {
+		err = davLocks.Confirm(path.Dir(urlPath), false, tokens)
+	}
+	if err != nil {
+		h.SendError(utils.LOCKED, "", "The resource is locked and no matching lock token was submitted")
+		return false
+	}
+	return true
+}
+
+// davDepth 解析 Depth 头，空值按 infinity 处理；取值不在 allowed 中时应答 400
+func (h *SimpleHTTPRequestHandler) davDepth(allowed ...string) (string, bool) {
+	depth := strings.ToLower(strings.TrimSpace(h.Headers["Depth"]))
+	if depth == "" {
+		depth = "infinity"
+	}
+	for _, a := range allowed {
+		if depth == a {
+			return depth, true
+		}
+	}
+	h.SendError(utils.BAD_REQUEST, "", "Invalid Depth header")
+	return "", false
+}
+
+// collectDAV 按 Depth 收集 PROPFIND 涉及的资源，跳过隐藏文件、被拒绝的路径，并避免符号链接循环
+func (h *SimpleHTTPRequestHandler) collectDAV(root davResource, depth string) ([]davResource, error) {
+	limit := config.Cfg.WebDAV.MaxPropfindEntries
+	if limit <= 0 {
+		limit = defaultMaxPropfindEntries
+	}
+	resources := []davResource{root}
+	if depth == "0" || !root.info.IsDir() {
+		return resources, nil
+	}
+	localRoot := h.localRoot(h.Path)
+	visited := make(map[string]bool)
+	enter := func(dir string) bool {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil || visited[real] {
+			return false
+		}
+		visited[real] = true
+		return true
+	}
+
+	var walk func(dir davResource) error
+	walk = func(dir davResource) error {
+		d, err := os.Open(dir.fsPath)
+		if err != nil {
+			return err
+		}
+		names, err := d.Readdirnames(-1)
+		d.Close()
+		if err != nil {
+			return err
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if isHidden(name) {
+				continue
+			}
+			child := davResource{urlPath: path.Join(dir.urlPath, name), fsPath: filepath.Join(dir.fsPath, name)}
+			if CheckEntry(localRoot, child.fsPath) != nil {
+				continue
+			}
+			if child.info, err = os.Stat(child.fsPath); err != nil {
+				continue
+			}
+			resources = append(resources, child)
+			if len(resources) > limit {
+				return errPropfindTooLarge
+			}
+			if child.info.IsDir() && depth == "infinity" && enter(child.fsPath) {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	enter(root.fsPath)
+	if err := walk(root); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// HandlePropfind 处理 PROPFIND：按 Depth（0、1、infinity）返回资源属性的 207 Multi-Status
+func (h *SimpleHTTPRequestHandler) HandlePropfind() {
+	depth, ok := h.davDepth("0", "1", "infinity")
+	if !ok {
+		return
+	}
+	req := &propfindRequest{}
+	if _, ok := h.readDAVBody(req); !ok {
+		return
+	}
+	root := davResource{urlPath: davLockPath(h.Path)}
+	if root.fsPath, ok = h.resolveRequestPath(h.Path); !ok {
+		return
+	}
+	var err error
+	if root.info, err = os.Stat(root.fsPath); err != nil {
+		h.SendError(utils.NOT_FOUND, "File not found")
+		return
+	}
+	resources, err := h.collectDAV(root, depth)
+	if err == errPropfindTooLarge {
+		h.sendXML(utils.FORBIDDEN, `<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`)
+		return
+	}
+	if err != nil {
+		talklog.Error(talklog.GID(), "PROPFIND cannot read %s: %v", root.fsPath, err)
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Error reading directory")
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(`<D:multistatus xmlns:D="DAV:">`)
+	for _, r := range resources {
+		h.writePropResponse(&b, r, req)
+	}
+	b.WriteString("</D:multistatus>")
+	h.sendXML(utils.MULTI_STATUS, b.String())
+	talklog.Info(talklog.GID(), "PROPFIND %s (Depth %s, %d resources)", h.Path, depth, len(resources))
+}
+
+// HandleProppatch 处理 PROPPATCH：死属性保存在内存中，DAV: 命名空间的活属性受保护
+// 按 RFC 4918 要么全部执行要么全部不执行，失败时其余属性报告 424
+func (h *SimpleHTTPRequestHandler) HandleProppatch() {
+	target, ok := h.resolveRequestPath(h.Path)
+	if !ok {
+		return
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		h.SendError(utils.NOT_FOUND, "File not found")
+		return
+	}
+	urlPath := davLockPath(h.Path)
+	if !h.davConfirm(urlPath, false, false) {
+		return
+	}
+	req := &propertyUpdateRequest{}
+	present, ok := h.readDAVBody(req)
+	if !ok {
+		return
+	}
+	if !present {
+		h.SendError(utils.BAD_REQUEST, "", "PROPPATCH requires a propertyupdate body")
+		return
+	}
+
+	var accepted, forbidden []davProperty
+	for _, u := range req.Updates {
+		if u.XMLName.Space != davNS || u.XMLName.Local != "set" && u.XMLName.Local != "remove" {
+			h.SendError(utils.BAD_REQUEST, "", "Unexpected element in propertyupdate")
+			return
+		}
+		for _, p := range u.Prop.Props {
+			if p.XMLName.Space == davNS {
+				forbidden = append(forbidden, davProperty{name: p.XMLName})
+			} else {
+				accepted = append(accepted, davProperty{name: p.XMLName})
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(`<D:multistatus xmlns:D="DAV:"><D:response><D:href>` + davHref(urlPath, info.IsDir()) + "</D:href>")
+	if len(forbidden) > 0 {
+		writePropstat(&b, forbidden, utils.FORBIDDEN)
+		writePropstat(&b, accepted, utils.FAILED_DEPENDENCY)
+	} else {
+		davProps.Patch(target, req.Updates)
+		writePropstat(&b, accepted, utils.OK)
+	}
+	b.WriteString("</D:response></D:multistatus>")
+	h.sendXML(utils.MULTI_STATUS, b.String())
+}
+
+// HandleMkcol 处理 MKCOL：创建目录，父目录必须存在
+func (h *SimpleHTTPRequestHandler) HandleMkcol() {
+	if h.ContentLength != 0 {
+		h.SendError(utils.UNSUPPORTED_MEDIA_TYPE, "", "MKCOL does not accept a request body")
+		return
+	}
+	target, ok := h.resolveRequestPath(h.Path)
+	if !ok {
+		return
+	}
+	if _, err := os.Lstat(target); err == nil {
+		h.AddResponseHeader("Allow", strings.Join(h.StaticMethods(), ", "))
+		h.SendError(utils.METHOD_NOT_ALLOWED, "", "Resource already exists")
+		return
+	}
+	urlPath := davLockPath(h.Path)
+	if !h.davConfirm(urlPath, false, true) {
+		return
+	}
+	if err := os.Mkdir(target, 0o755); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			h.SendError(utils.CONFLICT, "", "Parent collection does not exist")
+			return
+		}
+		talklog.Error(talklog.GID(), "MKCOL %s failed: %v", target, err)
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Cannot create collection")
+		return
+	}
+	invalidateFile(target)
+	h.SendResponse(utils.CREATED, "")
+	h.SendHeader("Content-Length", "0")
+	h.EndHeaders()
+	talklog.Info(talklog.GID(), "MKCOL created %s", target)
+}
+
+// davDestination 解析 COPY/MOVE 的 Destination 头，返回解码后的 URL 路径
+func (h *SimpleHTTPRequestHandler) davDestination() (string, bool) {
+	raw := strings.TrimSpace(h.Headers["Destination"])
+	if raw == "" {
+		h.SendError(utils.BAD_REQUEST, "", "Missing Destination header")
+		return "", false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || !strings.HasPrefix(u.Path, "/") {
+		h.SendError(utils.BAD_REQUEST, "", "Invalid Destination header")
+		return "", false
+	}
+	if u.Host != "" && !strings.EqualFold(u.Host, h.Headers["Host"]) {
+		h.SendError(utils.BAD_GATEWAY, "", "Destination is on another server")
+		return "", false
+	}
+	return u.Path, true
+}
+
+// handleCopyMove 处理 COPY 与 MOVE
+//
+// Overwrite: F 且目标存在时应答 412，否则先删除目标；新建目标应答 201，覆盖应答 204。
+// MOVE 优先使用 rename，跨文件系统时退化为复制后删除；死属性随资源复制或移动，锁不随之移动。
+func (h *SimpleHTTPRequestHandler) handleCopyMove(move bool) {
+	dest, ok := h.davDestination()
+	if !ok {
+		return
+	}
+	srcURL, dstURL := davLockPath(h.Path), davLockPath(dest)
+	if !pathWritable(dstURL) {
+		h.SendError(utils.FORBIDDEN, "", "Destination is not writable")
+		return
+	}
+	if srcURL == dstURL || isDescendant(srcURL, dstURL) {
+		h.SendError(utils.FORBIDDEN, "", "Destination is the source or inside it")
+		return
+	}
+	overwrite := strings.ToUpper(strings.TrimSpace(h.Headers["Overwrite"]))
+	if overwrite != "" && overwrite != "T" && overwrite != "F" {
+		h.SendError(utils.BAD_REQUEST, "", "Invalid Overwrite header")
+		return
+	}
+	allowedDepths := []string{"0", "infinity"}
+	if move {
+		allowedDepths = []string{"infinity"}
+	}
+	depth, ok := h.davDepth(allowedDepths...)
+	if !ok {
+		return
+	}
+
+	src, ok := h.resolveRequestPath(h.Path)
+	if !ok {
+		return
+	}
+	dst, ok := h.resolveRequestPath(dest)
+	if !ok {
+		return
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		h.SendError(utils.NOT_FOUND, "File not found")
+		return
+	}
+	if move && !h.davConfirm(srcURL, true, true) || !h.davConfirm(dstURL, true, true) {
+		return
+	}
+	dstInfo, err := os.Lstat(dst)
+	existed := err == nil
+	if existed && overwrite == "F" {
+		h.SendError(utils.PRECONDITION_FAILED, "", "Destination exists and Overwrite is F")
+		return
+	}
+	if fi, err := os.Stat(filepath.Dir(dst)); err != nil || !fi.IsDir() {
+		h.SendError(utils.CONFLICT, "", "Destination parent collection does not exist")
+		return
+	}
+
+	gid := talklog.GID()
+	if existed {
+		if err := os.RemoveAll(dst); err != nil {
+			talklog.Error(gid, "%s cannot replace %s: %v", h.Command, dst, err)
+			h.SendError(utils.INTERNAL_SERVER_ERROR, "Cannot replace destination")
+			return
+		}
+		davProps.Remove(dst)
+		invalidateTree(dst, dstInfo.IsDir())
+	}
+	root := h.localRoot(h.Path)
+	if move {
+		err = os.Rename(src, dst)
+		if errors.Is(err, syscall.EXDEV) {
+			// 跨文件系统移动时，源目录中有不能复制的条目就放弃移动，以免删除源目录时丢失它们
+			var skipped int
+			if skipped, err = copyTree(root, src, dst, info, true); err == nil && skipped > 0 {
+				os.RemoveAll(dst)
+				h.SendError(utils.FORBIDDEN, "", "Collection contains entries that cannot be moved")
+				return
+			}
+			if err == nil {
+				err = os.RemoveAll(src)
+			}
+		}
+	} else {
+		_, err = copyTree(root, src, dst, info, depth == "infinity")
+	}
+	if err != nil {
+		talklog.Error(gid, "%s %s -> %s failed: %v", h.Command, src, dst, err)
+		if errors.Is(err, syscall.ENOSPC) {
+			h.SendError(utils.INSUFFICIENT_STORAGE, "No space left on device")
+		} else {
+			h.SendError(utils.INTERNAL_SERVER_ERROR, "Cannot "+strings.ToLower(h.Command)+" resource")
+		}
+		return
+	}
+	davProps.Copy(src, dst, move)
+	if move {
+		davLocks.Remove(srcURL)
+		invalidateTree(src, info.IsDir())
+	}
+	invalidateTree(dst, info.IsDir())
+
+	if existed {
+		h.SendResponse(utils.NO_CONTENT, "")
+	} else {
+		h.SendResponse(utils.CREATED, "")
+		h.SendHeader("Location", (&url.URL{Path: dest}).EscapedPath())
+		h.SendHeader("Content-Length", "0")
+	}
+	h.EndHeaders()
+	talklog.Info(gid, "%s %s -> %s", h.Command, src, dst)
+}
+
+// copyTree 复制 root 之下的文件或目录，infinite 为 false 时只创建空目录（COPY Depth: 0）
+// 与普通访问一样跟随符号链接，但每个条目都先按 CheckEntry 检查，跳过被拒绝的路径、
+// 指向根目录之外的链接与指向已复制目录的链接；返回跳过的条目数
+func copyTree(root, src, dst string, info os.FileInfo, infinite bool) (int, error) {
+	visited := make(map[string]bool)
+	skipped := 0
+	var copyDir func(src, dst string, info os.FileInfo) error
+	copyDir = func(src, dst string, info os.FileInfo) error {
+		if !info.IsDir() {
+			return copyFile(src, dst, info)
+		}
+		if err := os.Mkdir(dst, 0o755); err != nil {
+			return err
+		}
+		real, err := filepath.EvalSymlinks(src)
+		if err != nil || visited[real] || !infinite {
+			return nil
+		}
+		visited[real] = true
+		d, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		names, err := d.Readdirnames(-1)
+		d.Close()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			p := filepath.Join(src, name)
+			if CheckEntry(root, p) != nil {
+				skipped++
+				continue
+			}
+			fi, err := os.Stat(p)
+			if err != nil || !fi.IsDir() && !fi.Mode().IsRegular() {
+				skipped++
+				continue
+			}
+			if err := copyDir(p, filepath.Join(dst, name), fi); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := copyDir(src, dst, info)
+	return skipped, err
+}
+
+// copyFile 复制单个文件，保留权限位与修改时间
+func copyFile(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// davPut 检查锁后按普通 PUT 处理
+func (h *SimpleHTTPRequestHandler) davPut() {
+	target, ok := h.resolveRequestPath(h.Path)
+	if !ok {
+		return
+	}
+	_, err := os.Stat(target)
+	if !h.davConfirm(davLockPath(h.Path), false, err != nil) {
+		return
+	}
+	h.HandlePut()
+}
+
+// davDelete 处理 WebDAV 的 DELETE：集合连同其中的成员一起删除
+func (h *SimpleHTTPRequestHandler) davDelete() {
+	target, ok := h.resolveRequestPath(h.Path)
+	if !ok {
+		return
+	}
+	if target == filepath.Clean(h.Directory) {
+		h.SendError(utils.FORBIDDEN, "", "Cannot delete the document root")
+		return
+	}
+	info, err := os.Lstat(target)
+	if err != nil {
+		h.SendError(utils.NOT_FOUND, "File not found")
+		return
+	}
+	urlPath := davLockPath(h.Path)
+	if !h.davConfirm(urlPath, true, true) || h.checkWritePreconditions(target, info) {
+		return
+	}
+	if err := os.RemoveAll(target); err != nil {
+		talklog.Error(talklog.GID(), "DELETE %s failed: %v", target, err)
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Cannot delete resource")
+		return
+	}
+	davProps.Remove(target)
+	davLocks.Remove(urlPath)
+	invalidateTree(target, info.IsDir())
+	h.SendResponse(utils.NO_CONTENT, "")
+	h.EndHeaders()
+	talklog.Info(talklog.GID(), "DELETE removed %s", target)
+}
+
+// HandleLock 处理 LOCK：创建写锁，或在没有请求体时用 If 头中的令牌刷新锁
+// 对不存在的资源加锁会创建空文件并应答 201
+func (h *SimpleHTTPRequestHandler) HandleLock() {
+	urlPath := davLockPath(h.Path)
+	timeout := parseTimeout(h.Headers["Timeout"])
+	req := &lockInfoRequest{}
+	present, ok := h.readDAVBody(req)
+	if !ok {
+		return
+	}
+	// 先解析并检查路径，被拒绝的请求不会留下锁
+	target, ok := h.resolveRequestPath(h.Path)
+	if !ok {
+		return
+	}
+	if !present {
+		tokens := submittedTokens(h.Headers["If"])
+		if len(tokens) != 1 {
+			h.SendError(utils.BAD_REQUEST, "", "Lock refresh requires exactly one lock token in the If header")
+			return
+		}
+		l, err := davLocks.Refresh(urlPath, tokens[0], timeout)
+		if err != nil {
+			h.SendError(utils.PRECONDITION_FAILED, "", "Lock token does not match the resource")
+			return
+		}
+		h.sendXML(utils.OK, `<D:prop xmlns:D="DAV:"><D:lockdiscovery>`+activeLockXML(*l)+"</D:lockdiscovery></D:prop>")
+		return
+	}
+	if req.Write == nil || (req.Exclusive == nil) == (req.Shared == nil) {
+		h.SendError(utils.BAD_REQUEST, "", "Only exclusive or shared write locks are supported")
+		return
+	}
+	depth, ok := h.davDepth("0", "infinity")
+	if !ok {
+		return
+	}
+
+	owner := ""
+	if req.Owner != nil {
+		if href := strings.TrimSpace(req.Owner.Href); href != "" {
+			owner = "<D:href>" + escapeXML(href) + "</D:href>"
+		} else {
+			owner = escapeXML(strings.TrimSpace(req.Owner.Text))
+		}
+	}
+	l, err := davLocks.Create(urlPath, depth == "infinity", req.Exclusive != nil, owner, timeout)
+	if err != nil {
+		h.SendError(utils.LOCKED, "", "The resource is already locked")
+		return
+	}
+
+	status := utils.OK
+	if _, err := os.Stat(target); errors.Is(err, fs.ErrNotExist) {
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			davLocks.Unlock(urlPath, l.token)
+			if errors.Is(err, fs.ErrNotExist) {
+				h.SendError(utils.CONFLICT, "", "Parent collection does not exist")
+			} else {
+				talklog.Error(talklog.GID(), "LOCK cannot create %s: %v", target, err)
+				h.SendError(utils.INTERNAL_SERVER_ERROR, "Cannot create resource")
+			}
+			return
+		}
+		f.Close()
+		invalidateFile(target)
+		status = utils.CREATED
+	}
+	h.AddResponseHeader("Lock-Token", "<"+l.token+">")
+	h.sendXML(status, `<D:prop xmlns:D="DAV:"><D:lockdiscovery>`+activeLockXML(*l)+"</D:lockdiscovery></D:prop>")
+	talklog.Info(talklog.GID(), "LOCK %s (%s, Depth %s)", urlPath, l.token, depth)
+}
+
+// HandleUnlock 处理 UNLOCK：Lock-Token 头中的令牌必须作用于请求路径
+func (h *SimpleHTTPRequestHandler) HandleUnlock() {
+	token := strings.Trim(strings.TrimSpace(h.Headers["Lock-Token"]), "<>")
+	if token == "" {
+		h.SendError(utils.BAD_REQUEST, "", "Missing Lock-Token header")
+		return
+	}
+	if err := davLocks.Unlock(davLockPath(h.Path), token); err != nil {
+		h.SendError(utils.CONFLICT, "", "Lock token does not match the resource")
+		return
+	}
+	h.SendResponse(utils.NO_CONTENT, "")
+	h.EndHeaders()
+}
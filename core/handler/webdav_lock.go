@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebDAV 锁的超时设置
+const (
+	defaultLockTimeout = 10 * time.Minute
+	maxLockTimeout     = 24 * time.Hour
+)
+
+var (
+	errLocked       = errors.New("resource is locked")
+	errNoSuchLock   = errors.New("no such lock")
+	errLockMismatch = errors.New("lock token does not apply to resource")
+)
+
+// davLock 一个写锁（RFC 4918 第 6 节），root 为加锁资源的 URL 路径
+type davLock struct {
+	token     string
+	root      string
+	infinite  bool // Depth: infinity，同时锁定所有子资源
+	exclusive bool
+	owner     string // 客户端提供的 owner XML，原样返回
+	timeout   time.Duration
+	expires   time.Time
+}
+
+// covers 报告锁是否作用于 p
+func (l *davLock) covers(p string) bool {
+	return l.root == p || l.infinite && isDescendant(l.root, p)
+}
+
+// timeoutHeader 返回 Timeout 头形式的超时时间
+func (l *davLock) timeoutHeader() string {
+	return "Second-" + strconv.FormatInt(int64(l.timeout/time.Second), 10)
+}
+
+// isDescendant 报告 p 是否位于集合 root 之下（不含 root 本身）
+func isDescendant(root, p string) bool {
+	if root == "/" {
+		return p != "/"
+	}
+	return strings.HasPrefix(p, root+"/")
+}
+
+// davLockPath 规范化用于加锁的 URL 路径：去掉结尾的 /（根路径除外）
+func davLockPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// lockManager 内存中的 WebDAV 锁管理器，服务重启后所有锁失效
+type lockManager struct {
+	mu    sync.Mutex
+	locks map[string]*davLock // token -> lock
+}
+
+// davLocks 进程内共享的锁管理器（HTTP 与 HTTPS 连接共用）
+var davLocks = &lockManager{locks: make(map[string]*davLock)}
+
+// expire 删除已超时的锁，调用方持有锁
+func (m *lockManager) expire(now time.Time) {
+	for token, l := range m.locks {
+		if now.After(l.expires) {
+			delete(m.locks, token)
+		}
+	}
+}
+
+// newLockToken 生成 urn:uuid 形式的锁令牌
+func newLockToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// parseTimeout 解析 Timeout 头（如 "Second-3600, Infinite"），取第一个可识别的值
+func parseTimeout(s string) time.Duration {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if strings.EqualFold(part, "Infinite") {
+			return maxLockTimeout
+		}
+		if n, ok := strings.CutPrefix(part, "Second-"); ok {
+			if secs, err := strconv.ParseInt(n, 10, 64); err == nil && secs > 0 {
+				d := time.Duration(secs) * time.Second
+				if d > maxLockTimeout || d <= 0 {
+					d = maxLockTimeout
+				}
+				return d
+			}
+		}
+	}
+	return defaultLockTimeout
+}
+
+// Create 加锁；与已有的锁冲突时返回 errLocked
+// 排他锁与任何锁冲突，共享锁只与排他锁冲突
+func (m *lockManager) Create(root string, infinite, exclusive bool, owner string, timeout time.Duration) (*davLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.expire(now)
+	for _, l := range m.locks {
+		overlap := l.covers(root) || infinite && isDescendant(root, l.root)
+		if overlap && (exclusive || l.exclusive) {
+			return nil, errLocked
+		}
+	}
+	l := &davLock{
+		token:     newLockToken(),
+		root:      root,
+		infinite:  infinite,
+		exclusive: exclusive,
+		owner:     owner,
+		timeout:   timeout,
+		expires:   now.Add(timeout),
+	}
+	m.locks[l.token] = l
+	return l, nil
+}
+
+// Refresh 刷新锁的超时时间，令牌必须作用于 p
+func (m *lockManager) Refresh(p, token string, timeout time.Duration) (*davLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.expire(now)
+	l, ok := m.locks[token]
+	if !ok {
+		return nil, errNoSuchLock
+	}
+	if !l.covers(p) {
+		return nil, errLockMismatch
+	}
+	l.timeout, l.expires = timeout, now.Add(timeout)
+	copied := *l
+	return &copied, nil
+}
+
+// Unlock 释放锁，令牌必须作用于 p
+func (m *lockManager) Unlock(p, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	l, ok := m.locks[token]
+	if !ok {
+		return errNoSuchLock
+	}
+	if !l.covers(p) {
+		return errLockMismatch
+	}
+	delete(m.locks, token)
+	return nil
+}
+
+// Confirm 检查对 p 的写操作是否被锁阻止：作用于 p 的锁（recursive 时还包括 p 之下的锁）
+// 都必须在 tokens 中给出令牌
+func (m *lockManager) Confirm(p string, recursive bool, tokens []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	for token, l := range m.locks {
+		if !l.covers(p) && !(recursive && isDescendant(p, l.root)) {
+			continue
+		}
+		held := false
+		for _, t := range tokens {
+			if t == token {
+				held = true
+				break
+			}
+		}
+		if !held {
+			return errLocked
+		}
+	}
+	return nil
+}
+
+// Discover 返回作用于 p 的锁，按令牌排序
+func (m *lockManager) Discover(p string) []davLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	var found []davLock
+	for _, l := range m.locks {
+		if l.covers(p) {
+			found = append(found, *l)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].token < found[j].token })
+	return found
+}
+
+// Remove 删除 p 及其之下的所有锁（资源被删除或移走后调用）
+func (m *lockManager) Remove(p string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, l := range m.locks {
+		if l.root == p || isDescendant(p, l.root) {
+			delete(m.locks, token)
+		}
+	}
+}
+
+// submittedTokens 从 If 头中提取锁令牌（只识别 Coded-URL 形式的 urn:uuid / opaquelocktoken）
+// 简化处理：不评估 If 头中的实体标签与 Not 条件
+func submittedTokens(ifHeader string) []string {
+	var tokens []string
+	for {
+		start := strings.IndexByte(ifHeader, '<')
+		if start < 0 {
+			return tokens
+		}
+		end := strings.IndexByte(ifHeader[start:], '>')
+		if end < 0 {
+			return tokens
+		}
+		uri := ifHeader[start+1 : start+end]
+		if strings.HasPrefix(uri, "urn:uuid:") || strings.HasPrefix(uri, "opaquelocktoken:") {
+			tokens = append(tokens, uri)
+		}
+		ifHeader = ifHeader[start+end+1:]
+	}
+}
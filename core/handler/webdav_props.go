@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"encoding/xml"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+const davNS = "DAV:"
+
+// davTimeFormat getlastmodified 使用的 rfc1123-date，时区必须写作 GMT
+const davTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// davProperty 一个属性，inner 为已转义的 XML 内容
+type davProperty struct {
+	name  xml.Name
+	inner string
+}
+
+// davPropNames WebDAV 的活属性（由服务器根据文件计算，PROPPATCH 不能修改）
+var davPropNames = []string{
+	"displayname", "getcontentlength", "getcontenttype", "getetag",
+	"getlastmodified", "lockdiscovery", "resourcetype", "supportedlock",
+}
+
+// ---- 请求体 ----
+
+// xmlNames 收集子元素的名称，用于 <prop> 中列出的属性名
+type xmlNames []xml.Name
+
+func (n *xmlNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			*n = append(*n, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type propfindRequest struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     *xmlNames `xml:"DAV: prop"`
+}
+
+// rawProp PROPPATCH 中的一个属性及其原始 XML 内容
+type rawProp struct {
+	XMLName xml.Name
+	Inner   string `xml:",innerxml"`
+}
+
+// propUpdate <set> 或 <remove>，按文档顺序执行
+type propUpdate struct {
+	XMLName xml.Name
+	Prop    struct {
+		Props []rawProp `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+type propertyUpdateRequest struct {
+	XMLName xml.Name     `xml:"DAV: propertyupdate"`
+	Updates []propUpdate `xml:",any"`
+}
+
+type lockInfoRequest struct {
+	XMLName   xml.Name  `xml:"DAV: lockinfo"`
+	Exclusive *struct{} `xml:"DAV: lockscope>exclusive"`
+	Shared    *struct{} `xml:"DAV: lockscope>shared"`
+	Write     *struct{} `xml:"DAV: locktype>write"`
+	Owner     *struct {
+		Href string `xml:"DAV: href"`
+		Text string `xml:",chardata"`
+	} `xml:"DAV: owner"`
+}
+
+// ---- 死属性 ----
+
+// deadPropStore 内存中的死属性（客户端通过 PROPPATCH 设置的任意属性），按文件系统路径保存
+type deadPropStore struct {
+	mu    sync.Mutex
+	props map[string]map[xml.Name]string
+}
+
+var davProps = &deadPropStore{props: make(map[string]map[xml.Name]string)}
+
+// Get 返回 p 的死属性，按名称排序
+func (s *deadPropStore) Get(p string) []davProperty {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var props []davProperty
+	for name, inner := range s.props[p] {
+		props = append(props, davProperty{name, inner})
+	}
+	sort.Slice(props, func(i, j int) bool {
+		if props[i].name.Space != props[j].name.Space {
+			return props[i].name.Space < props[j].name.Space
+		}
+		return props[i].name.Local < props[j].name.Local
+	})
+	return props
+}
+
+// Patch 按顺序执行设置（remove 为 false）与删除
+func (s *deadPropStore) Patch(p string, updates []propUpdate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.props[p]
+	if m == nil {
+		m = make(map[xml.Name]string)
+	}
+	for _, u := range updates {
+		for _, prop := range u.Prop.Props {
+			if u.XMLName.Local == "remove" {
+				delete(m, prop.XMLName)
+			} else {
+				m[prop.XMLName] = prop.Inner
+			}
+		}
+	}
+	if len(m) == 0 {
+		delete(s.props, p)
+	} else {
+		s.props[p] = m
+	}
+}
+
+// inTree 报告 p 是否为 root 或位于 root 之下
+func inTree(root, p string) bool {
+	return p == root || strings.HasPrefix(p, root+string(filepath.Separator))
+}
+
+// Remove 删除 root 及其之下所有资源的死属性
+func (s *deadPropStore) Remove(root string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.props {
+		if inTree(root, p) {
+			delete(s.props, p)
+		}
+	}
+}
+
+// Copy 把 src 树的死属性复制到 dst 树，move 为 true 时同时删除 src 的属性
+func (s *deadPropStore) Copy(src, dst string, move bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.props {
+		if inTree(dst, p) {
+			delete(s.props, p)
+		}
+	}
+	copied := make(map[string]map[xml.Name]string)
+	for p, m := range s.props {
+		if !inTree(src, p) {
+			continue
+		}
+		props := make(map[xml.Name]string, len(m))
+		for k, v := range m {
+			props[k] = v
+		}
+		copied[dst+strings.TrimPrefix(p, src)] = props
+		if move {
+			delete(s.props, p)
+		}
+	}
+	for p, m := range copied {
+		s.props[p] = m
+	}
+}
+
+// ---- 应答 ----
+
+// escapeXML 转义文本内容
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// davHref 返回资源的 href，集合以 / 结尾
+func davHref(urlPath string, isDir bool) string {
+	if isDir && !strings.HasSuffix(urlPath, "/") {
+		urlPath += "/"
+	}
+	return escapeXML((&url.URL{Path: urlPath}).EscapedPath())
+}
+
+// writeProp 写出一个属性元素，DAV: 命名空间使用 D: 前缀，其余命名空间在元素上声明
+func writeProp(b *strings.Builder, p davProperty) {
+	if p.name.Space == davNS {
+		b.WriteString("<D:" + p.name.Local)
+	} else {
+		b.WriteString("<" + p.name.Local + ` xmlns="` + escapeXML(p.name.Space) + `"`)
+	}
+	if p.inner == "" {
+		b.WriteString("/>")
+		return
+	}
+	b.WriteString(">" + p.inner)
+	if p.name.Space == davNS {
+		b.WriteString("</D:" + p.name.Local + ">")
+	} else {
+		b.WriteString("</" + p.name.Local + ">")
+	}
+}
+
+// statusLine 返回 <D:status> 中的状态行
+func statusLine(code utils.HTTPStatus) string {
+	return "HTTP/1.1 " + strconv.Itoa(int(code)) + " " + utils.StatusMessages[code][0]
+}
+
+// writePropstat 写出一组状态相同的属性
+func writePropstat(b *strings.Builder, props []davProperty, code utils.HTTPStatus) {
+	if len(props) == 0 {
+		return
+	}
+	b.WriteString("<D:propstat><D:prop>")
+	for _, p := range props {
+		writeProp(b, p)
+	}
+	b.WriteString("</D:prop><D:status>" + statusLine(code) + "</D:status></D:propstat>")
+}
+
+// activeLockXML 返回 lockdiscovery 中的一个 activelock
+func activeLockXML(l davLock) string {
+	var b strings.Builder
+	b.WriteString("<D:activelock><D:locktype><D:write/></D:locktype><D:lockscope>")
+	if l.exclusive {
+		b.WriteString("<D:exclusive/>")
+	} else {
+		b.WriteString("<D:shared/>")
+	}
+	b.WriteString("</D:lockscope><D:depth>")
+	if l.infinite {
+		b.WriteString("infinity")
+	} else {
+		b.WriteString("0")
+	}
+	b.WriteString("</D:depth>")
+	if l.owner != "" {
+		b.WriteString("<D:owner>" + l.owner + "</D:owner>")
+	}
+	b.WriteString("<D:timeout>" + l.timeoutHeader() + "</D:timeout>")
+	b.WriteString("<D:locktoken><D:href>" + escapeXML(l.token) + "</D:href></D:locktoken>")
+	b.WriteString("<D:lockroot><D:href>" + davHref(l.root, false) + "</D:href></D:lockroot>")
+	b.WriteString("</D:activelock>")
+	return b.String()
+}
+
+// liveProps 计算资源的活属性
+func (h *SimpleHTTPRequestHandler) liveProps(urlPath, fsPath string, info os.FileInfo) map[string]string {
+	props := map[string]string{
+		"displayname":     escapeXML(info.Name()),
+		"getlastmodified": info.ModTime().UTC().Format(davTimeFormat),
+		"resourcetype":    "",
+		"supportedlock": "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>" +
+			"<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>",
+	}
+	if urlPath == "/" {
+		props["displayname"] = ""
+	}
+	if info.IsDir() {
+		props["resourcetype"] = "<D:collection/>"
+	} else {
+		props["getcontentlength"] = strconv.FormatInt(info.Size(), 10)
+		props["getcontenttype"] = escapeXML(h.GuessType(fsPath))
+		props["getetag"] = escapeXML(FileETag(fsPath, info))
+	}
+	var locks strings.Builder
+	for _, l := range davLocks.Discover(urlPath) {
+		locks.WriteString(activeLockXML(l))
+	}
+	props["lockdiscovery"] = locks.String()
+	return props
+}
+
+// davResource PROPFIND 遍历到的一个资源
+type davResource struct {
+	urlPath string
+	fsPath  string
+	info    os.FileInfo
+}
+
+// writePropResponse 按 PROPFIND 的请求写出一个资源的 <D:response>
+func (h *SimpleHTTPRequestHandler) writePropResponse(b *strings.Builder, r davResource, req *propfindRequest) {
+	live := h.liveProps(r.urlPath, r.fsPath, r.info)
+	dead := davProps.Get(r.fsPath)
+	b.WriteString("<D:response><D:href>" + davHref(r.urlPath, r.info.IsDir()) + "</D:href>")
+
+	var found, missing []davProperty
+	switch {
+	case req.PropName != nil:
+		for _, name := range davPropNames {
+			if _, ok := live[name]; ok {
+				found = append(found, davProperty{name: xml.Name{Space: davNS, Local: name}})
+			}
+		}
+		for _, p := range dead {
+			found = append(found, davProperty{name: p.name})
+		}
+	case req.Prop != nil:
+		for _, name := range *req.Prop {
+			p, ok := davProperty{name: name}, false
+			if name.Space == davNS {
+				p.inner, ok = live[name.Local]
+			}
+			for _, d := range dead {
+				if !ok && d.name == name {
+					p, ok = d, true
+				}
+			}
+			if ok {
+				found = append(found, p)
+			} else {
+				missing = append(missing, p)
+			}
+		}
+	default:
+		for _, name := range davPropNames {
+			if inner, ok := live[name]; ok {
+				found = append(found, davProperty{xml.Name{Space: davNS, Local: name}, inner})
+			}
+		}
+		found = append(found, dead...)
+	}
+	writePropstat(b, found, utils.OK)
+	writePropstat(b, missing, utils.NOT_FOUND)
+	b.WriteString("</D:response>")
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+	"github.com/Singert/xjtu_cnlab/core/utils"
+)
+
+// writeAllowed 报告当前请求路径是否允许 PUT/DELETE
+func (h *SimpleHTTPRequestHandler) writeAllowed() bool {
+	return h.Path != "*" && pathWritable(h.Path)
+}
+
+// pathWritable 报告 URL 路径是否允许写入：挂载路径只读；开启 WebDAV（非只读）时工作目录下的任何路径均可写，
+// 否则需要开启 write.Enabled，且路径位于 write.Paths 中某个前缀之下（不含前缀本身）
+func pathWritable(urlPath string) bool {
+	p := path.Clean("/" + urlPath)
+	if m, _ := matchMount(p); m != nil {
+		return false
+	}
+	if dav := config.Cfg.WebDAV; dav.Enabled && !dav.ReadOnly {
+		return p != "/"
+	}
+	cfg := config.Cfg.Write
+	if !cfg.Enabled {
+		return false
+	}
+	for _, prefix := range cfg.Paths {
+		prefix = "/" + strings.Trim(prefix, "/")
+		if prefix == "/" && p != "/" || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMaxSize 返回 PUT 请求体的上限，未配置时与上传的单文件上限相同
+func writeMaxSize() int64 {
+	if config.Cfg.Write.MaxSize > 0 {
+		return config.Cfg.Write.MaxSize
+	}
+	maxFile, _ := uploadLimits()
+	return maxFile
+}
+
+// checkWritePreconditions 评估 If-Match / If-None-Match 等条件，返回是否已经应答 412
+// stat 为 nil 表示目标不存在
+func (h *SimpleHTTPRequestHandler) checkWritePreconditions(target string, stat os.FileInfo) bool {
+	etag, modTime := "", time.Time{}
+	if stat != nil {
+		etag, modTime = FileETag(target, stat), stat.ModTime()
+	}
+	return h.SendPreconditionResult(h.EvaluatePreconditions(etag, modTime), etag, "")
+}
+
+// pathLock 一个目标路径的互斥锁，refs 为持有或等待它的请求数
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+var (
+	pathLocksMu sync.Mutex
+	pathLocks   = make(map[string]*pathLock)
+)
+
+// lockPath 独占目标路径直到调用返回的函数，使同一路径的条件检查与写入串行进行
+func lockPath(target string) (unlock func()) {
+	pathLocksMu.Lock()
+	l := pathLocks[target]
+	if l == nil {
+		l = &pathLock{}
+		pathLocks[target] = l
+	}
+	l.refs++
+	pathLocksMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		pathLocksMu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(pathLocks, target)
+		}
+		pathLocksMu.Unlock()
+	}
+}
+
+// statTarget 返回写入目标的信息，不存在时为 nil；出错或目标不是普通文件时应答错误并返回 false
+func (h *SimpleHTTPRequestHandler) statTarget(target string) (os.FileInfo, bool) {
+	stat, err := os.Stat(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, true
+	}
+	if err != nil {
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Cannot access target")
+		return nil, false
+	}
+	if !stat.Mode().IsRegular() {
+		h.SendError(utils.CONFLICT, "", "Target exists and is not a regular file")
+		return nil, false
+	}
+	return stat, true
+}
+
+// HandlePut 把请求体原子地写入请求路径对应的文件
+//
+// 请求体先写入同目录的临时文件，完整接收后再重命名；If-None-Match: * 时以硬链接发布，
+// 保证不会覆盖并发创建的文件。条件在接收请求体之前检查一次，发布前在路径锁内对目标重新检查，
+// 期间目标被其他请求修改时应答 412。新建文件应答 201，替换已有文件应答 204。
+func (h *SimpleHTTPRequestHandler) HandlePut() {
+	gid := talklog.GID()
+	if strings.HasSuffix(h.Path, "/") {
+		h.SendError(utils.CONFLICT, "", "Cannot PUT to a directory")
+		return
+	}
+	target, ok := h.resolveRequestPath(h.Path)
+	if !ok {
+		return
+	}
+	maxSize := writeMaxSize()
+	if h.ContentLength > maxSize {
+		h.CloseConnection = true
+		h.SendError(utils.REQUEST_ENTITY_TOO_LARGE, "", fmt.Sprintf("Body exceeds %d bytes", maxSize))
+		return
+	}
+
+	// 先检查一次，条件不满足时不必接收请求体
+	stat, ok := h.statTarget(target)
+	if !ok || h.checkWritePreconditions(target, stat) {
+		return
+	}
+
+	dir := filepath.Dir(target)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		if !config.Cfg.Write.CreateDirs || err == nil {
+			h.SendError(utils.CONFLICT, "", "Parent directory does not exist")
+			return
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			talklog.Error(gid, "PUT cannot create %s: %v", dir, err)
+			h.SendError(utils.CONFLICT, "", "Cannot create parent directory")
+			return
+		}
+	}
+
+	tmp, size, sum, err := stageUpload(h.Body, dir, maxSize)
+	if err != nil {
+		h.CloseConnection = true
+		if err == errUploadTooLarge {
+			h.SendError(utils.REQUEST_ENTITY_TOO_LARGE, "", fmt.Sprintf("Body exceeds %d bytes", maxSize))
+		} else {
+			talklog.Error(gid, "PUT %s failed: %v", target, err)
+			h.SendError(utils.INTERNAL_SERVER_ERROR, "Error saving file")
+		}
+		return
+	}
+	defer os.Remove(tmp)
+
+	// 接收请求体期间目标可能已被其他请求替换，在路径锁内重新检查后再发布
+	unlock := lockPath(target)
+	defer unlock()
+	if stat, ok = h.statTarget(target); !ok {
+		return
+	}
+	if h.checkWritePreconditions(target, stat) {
+		talklog.Warn(gid, "PUT %s: target changed while the body was received", target)
+		return
+	}
+	if stat == nil && strings.TrimSpace(h.Headers["If-None-Match"]) == "*" {
+		// 只允许创建：目标在检查之后被并发创建时不覆盖
+		err = linkNoReplace(tmp, target)
+	} else {
+		err = os.Rename(tmp, target)
+	}
+	invalidateFile(target)
+	if errors.Is(err, ErrFileExists) {
+		h.SendError(utils.PRECONDITION_FAILED, "", "Target was created concurrently")
+		return
+	}
+	if err != nil {
+		talklog.Error(gid, "PUT %s failed: %v", target, err)
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Error saving file")
+		return
+	}
+
+	status := utils.NO_CONTENT
+	if stat == nil {
+		status = utils.CREATED
+	}
+	h.SendResponse(status, "")
+	if newStat, err := os.Stat(target); err == nil {
+		h.SendHeader("ETag", FileETag(target, newStat))
+	}
+	if status == utils.CREATED {
+		h.SendHeader("Location", (&url.URL{Path: h.Path}).EscapedPath())
+		h.SendHeader("Content-Length", "0")
+	}
+	h.EndHeaders()
+	talklog.Info(gid, "PUT stored %s (%d bytes, sha256 %s)", target, size, sum)
+}
+
+// HandleDelete 删除请求路径对应的文件；开启 write.DeleteDirs 时也可删除空目录
+func (h *SimpleHTTPRequestHandler) HandleDelete() {
+	target, ok := h.resolveRequestPath(h.Path)
+	if !ok {
+		return
+	}
+	if target == filepath.Clean(h.Directory) {
+		h.SendError(utils.FORBIDDEN, "", "Cannot delete the document root")
+		return
+	}
+	// 条件检查与删除之间目标不能被 PUT 替换
+	unlock := lockPath(target)
+	defer unlock()
+	stat, err := os.Lstat(target)
+	if err != nil {
+		h.SendError(utils.NOT_FOUND, "File not found")
+		return
+	}
+	if stat.IsDir() && !config.Cfg.Write.DeleteDirs {
+		h.SendError(utils.FORBIDDEN, "", "Deleting directories is not enabled")
+		return
+	}
+	if h.checkWritePreconditions(target, stat) {
+		return
+	}
+	if err := os.Remove(target); err != nil {
+		if stat.IsDir() {
+			h.SendError(utils.CONFLICT, "", "Directory is not empty")
+			return
+		}
+		talklog.Error(talklog.GID(), "DELETE %s failed: %v", target, err)
+		h.SendError(utils.INTERNAL_SERVER_ERROR, "Cannot delete file")
+		return
+	}
+	invalidateFile(target)
+	h.SendResponse(utils.NO_CONTENT, "")
+	h.EndHeaders()
+	talklog.Info(talklog.GID(), "DELETE removed %s", target)
+}
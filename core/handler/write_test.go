@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// putRequest 以 body 为请求体对 dir 下的 name 执行 PUT，返回应答的状态码
+func putRequest(t *testing.T, dir, name string, body io.Reader, headers map[string]string) int {
+	t.Helper()
+	var out bytes.Buffer
+	h := &SimpleHTTPRequestHandler{
+		BaseHTTPRequestHandler: &BaseHTTPRequestHandler{
+			Command:         "PUT",
+			Path:            "/" + name,
+			RequestVersion:  "HTTP/1.1",
+			ProtocolVersion: "HTTP/1.1",
+			Headers:         headers,
+			Body:            body,
+			ContentLength:   -1,
+			WFile:           bufio.NewWriter(&out),
+		},
+		Directory: dir,
+	}
+	h.HandlePut()
+	h.WFile.Flush()
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	if err != nil {
+		t.Fatalf("cannot parse response: %v\n%s", err, out.String())
+	}
+	return resp.StatusCode
+}
+
+func TestPutIfMatchLostUpdate(t *testing.T) {
+	withSecurity(t, SymlinksAllow, nil)
+	dir := t.TempDir()
+	target := filepath.Join(dir, "doc.txt")
+	if err := os.WriteFile(target, []byte("v0"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := FileETag(target, stat)
+
+	// 第一个请求通过了条件检查，仍在接收请求体
+	pr, pw := io.Pipe()
+	first := make(chan int)
+	go func() {
+		first <- putRequest(t, dir, "doc.txt", pr, map[string]string{"If-Match": etag})
+	}()
+	if _, err := pw.Write([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第二个请求带着同一个 ETag 先完成
+	if code := putRequest(t, dir, "doc.txt", strings.NewReader("second"), map[string]string{"If-Match": etag}); code != http.StatusNoContent {
+		t.Fatalf("second PUT = %d, want 204", code)
+	}
+	pw.Close()
+	if code := <-first; code != http.StatusPreconditionFailed {
+		t.Fatalf("first PUT = %d, want 412 after the target changed", code)
+	}
+
+	if got, _ := os.ReadFile(target); string(got) != "second" {
+		t.Fatalf("target = %q, want the second write", got)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
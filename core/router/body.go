@@ -0,0 +1,210 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrBodyTooLarge   = errors.New("request body too large")
+	ErrBodyConsumed   = errors.New("request body already consumed by streaming read")
+	ErrNotMultipart   = errors.New("request Content-Type isn't multipart/form-data")
+	ErrNotJSONContent = errors.New("request Content-Type isn't application/json")
+)
+
+// DefaultMaxMemory ParseMultipartForm 默认保存在内存中的上限，超出部分写入临时文件
+const DefaultMaxMemory = 32 << 20
+
+// RequestBody 请求体的惰性读取器
+//
+// 可以直接作为 io.Reader 流式读取；也可以通过 Bytes() 一次性读入内存
+// （受 maxBytes 限制）。两种方式不能混用：流式读取过后 Bytes() 返回 ErrBodyConsumed。
+type RequestBody struct {
+	r        io.Reader
+	maxBytes int64
+	data     []byte
+	buffered bool // 是否已经读入 data
+	streamed bool // 是否已经被流式读取过
+	err      error
+}
+
+// NewRequestBody 包装底层请求体读取器，maxBytes <= 0 表示不限制
+func NewRequestBody(r io.Reader, maxBytes int64) *RequestBody {
+	if r == nil {
+		r = strings.NewReader("")
+	}
+	return &RequestBody{r: r, maxBytes: maxBytes}
+}
+
+// Read 实现 io.Reader；已经调用过 Bytes() 时从缓冲区读取
+func (b *RequestBody) Read(p []byte) (int, error) {
+	if b.buffered {
+		if len(b.data) == 0 {
+			return 0, io.EOF
+		}
+		n := copy(p, b.data)
+		b.data = b.data[n:]
+		return n, nil
+	}
+	b.streamed = true
+	return b.r.Read(p)
+}
+
+// Bytes 读取完整的请求体，超过上限时返回 ErrBodyTooLarge
+func (b *RequestBody) Bytes() ([]byte, error) {
+	if b.buffered || b.err != nil {
+		return b.data, b.err
+	}
+	if b.streamed {
+		return nil, ErrBodyConsumed
+	}
+	b.buffered = true
+	r := b.r
+	if b.maxBytes > 0 {
+		r = io.LimitReader(b.r, b.maxBytes+1)
+	}
+	b.data, b.err = io.ReadAll(r)
+	if b.err == nil && b.maxBytes > 0 && int64(len(b.data)) > b.maxBytes {
+		b.data, b.err = nil, ErrBodyTooLarge
+	}
+	return b.data, b.err
+}
+
+// reader 返回一个从头开始的读取器，已缓冲时不消耗缓冲内容
+func (b *RequestBody) reader() io.Reader {
+	if b.buffered {
+		return bytes.NewReader(b.data)
+	}
+	b.streamed = true
+	if b.maxBytes > 0 {
+		return &maxBytesReader{r: b.r, n: b.maxBytes}
+	}
+	return b.r
+}
+
+// maxBytesReader 超出上限时返回 ErrBodyTooLarge 而不是静默截断
+type maxBytesReader struct {
+	r io.Reader
+	n int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.n <= 0 {
+		// 探测是否还有多余数据
+		var one [1]byte
+		if n, _ := m.r.Read(one[:]); n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > m.n {
+		p = p[:m.n]
+	}
+	n, err := m.r.Read(p)
+	m.n -= int64(n)
+	return n, err
+}
+
+// mediaType 返回请求 Content-Type 的媒体类型与参数
+func (c *Context) mediaType() (string, map[string]string) {
+	mt, params, err := mime.ParseMediaType(c.Headers["Content-Type"])
+	if err != nil {
+		return "", nil
+	}
+	return mt, params
+}
+
+// ParseForm 解析 URL 查询参数与 application/x-www-form-urlencoded 请求体
+// 请求体中的字段排在查询参数之前
+func (c *Context) ParseForm() (url.Values, error) {
+	if c.form != nil {
+		return c.form, nil
+	}
+	form := make(url.Values)
+	if mt, _ := c.mediaType(); mt == "application/x-www-form-urlencoded" && c.Body != nil {
+		data, err := c.Body.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range values {
+			form[k] = append(form[k], v...)
+		}
+	}
+	values, _ := url.ParseQuery(c.RawQuery)
+	for k, v := range values {
+		form[k] = append(form[k], v...)
+	}
+	c.form = form
+	return form, nil
+}
+
+// ParseMultipartForm 解析 multipart/form-data 请求体
+// 超过 maxMemory 的文件内容会写入临时文件，请求结束时由 Cleanup 删除
+func (c *Context) ParseMultipartForm(maxMemory int64) (*multipart.Form, error) {
+	if c.multipartForm != nil {
+		return c.multipartForm, nil
+	}
+	mt, params := c.mediaType()
+	if !strings.HasPrefix(mt, "multipart/") || params["boundary"] == "" || c.Body == nil {
+		return nil, ErrNotMultipart
+	}
+	if maxMemory <= 0 {
+		maxMemory = DefaultMaxMemory
+	}
+	reader := multipart.NewReader(c.Body.reader(), params["boundary"])
+	form, err := reader.ReadForm(maxMemory)
+	if err != nil {
+		return nil, err
+	}
+	c.multipartForm = form
+	return form, nil
+}
+
+// FormValue 返回表单字段的第一个值，依次查找 urlencoded 请求体、multipart 字段和查询参数
+func (c *Context) FormValue(key string) string {
+	if mt, _ := c.mediaType(); strings.HasPrefix(mt, "multipart/") {
+		if form, err := c.ParseMultipartForm(DefaultMaxMemory); err == nil {
+			if v := form.Value[key]; len(v) > 0 {
+				return v[0]
+			}
+		}
+	}
+	form, err := c.ParseForm()
+	if err != nil {
+		return ""
+	}
+	return form.Get(key)
+}
+
+// BindJSON 将 JSON 请求体解码到 v
+func (c *Context) BindJSON(v any) error {
+	if mt, _ := c.mediaType(); mt != "" && mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+		return ErrNotJSONContent
+	}
+	if c.Body == nil {
+		return io.EOF
+	}
+	data, err := c.Body.Bytes()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Cleanup 删除 ParseMultipartForm 产生的临时文件，由请求处理器在路由返回后调用
+func (c *Context) Cleanup() {
+	if c.multipartForm != nil {
+		c.multipartForm.RemoveAll()
+		c.multipartForm = nil
+	}
+}
@@ -0,0 +1,69 @@
+package router
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Param 返回路径参数的值
+func (c *Context) Param(name string) string {
+	return c.Params.Get(name)
+}
+
+// Header 返回待发送的响应头
+func (c *Context) Header() Header {
+	return c.Writer.Header()
+}
+
+// Status 设置响应状态码，可链式调用：ctx.Status(201).JSON(...)
+func (c *Context) Status(code int) *Context {
+	c.Writer.Status(code)
+	return c
+}
+
+// Write 写出响应体，实现 io.Writer
+func (c *Context) Write(p []byte) (int, error) {
+	return c.Writer.Write(p)
+}
+
+// Data 以固定长度输出完整的响应体
+func (c *Context) Data(code int, contentType string, body []byte) error {
+	w := c.Writer
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code)
+	_, err := w.Write(body)
+	return err
+}
+
+// Text 输出纯文本
+func (c *Context) Text(code int, text string) error {
+	return c.Data(code, "text/plain; charset=utf-8", []byte(text))
+}
+
+// HTML 输出 HTML 页面
+func (c *Context) HTML(code int, html string) error {
+	return c.Data(code, "text/html; charset=utf-8", []byte(html))
+}
+
+// JSON 将 v 编码为缩进格式的 JSON 输出
+func (c *Context) JSON(code int, v any) error {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return c.Data(500, "application/json; charset=utf-8", []byte(`{"error": "failed to encode response"}`))
+	}
+	return c.Data(code, "application/json; charset=utf-8", body)
+}
+
+// Error 以服务器的错误页应答：按客户端的 Accept 返回 HTML 或 problem+json，分组设置了 ErrorFormat 时使用该格式
+func (c *Context) Error(code int, explain string) {
+	c.Writer.Error(code, explain)
+}
+
+// Redirect 重定向到 location，code 应为 3xx
+func (c *Context) Redirect(code int, location string) error {
+	c.Writer.Header().Set("Location", location)
+	return c.Data(code, "", nil)
+}
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+)
+
+// Middleware 包装路由处理器，在其前后执行通用逻辑
+type Middleware func(HandlerFunc) HandlerFunc
+
+// Use 注册全局中间件，作用于所有路由（包括之后注册的），按注册顺序由外到内执行
+func (r *Router) Use(mws ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middlewares = append(r.middlewares, mws...)
+}
+
+// Use 注册分组中间件，作用于之后在该分组（及其子分组）中注册的路由
+func (g *Group) Use(mws ...Middleware) {
+	g.middlewares = append(g.middlewares, mws...)
+}
+
+// chain 将中间件依次包装到处理器上，第一个中间件位于最外层
+func chain(handler HandlerFunc, mws []Middleware) HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	return handler
+}
+
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
+
+// middlewareName 返回中间件的可读名称，如 router.Logger
+func middlewareName(mw Middleware) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(mw).Pointer())
+	if fn == nil {
+		return "anonymous"
+	}
+	name := fn.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return closureSuffix.ReplaceAllString(name, "")
+}
+
+func middlewareNames(mws []Middleware) []string {
+	names := make([]string, 0, len(mws))
+	for _, mw := range mws {
+		names = append(names, middlewareName(mw))
+	}
+	return names
+}
+
+// Logger 记录每个路由请求的方法、路径、状态码与耗时
+func Logger() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			start := time.Now()
+			next(ctx)
+			talklog.Info(talklog.GID(), "%s %s -> %d (%s)",
+				ctx.Method, ctx.Path, ctx.Writer.StatusCode(), time.Since(start))
+		}
+	}
+}
+
+// Recovery 捕获处理器中的 panic，记录 panic 的值与堆栈
+// 响应头未发送时返回不含 panic 信息的 500；已发送时放弃响应并关闭连接，让客户端知道响应不完整
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			defer func() {
+				if r := recover(); r != nil {
+					talklog.Error(talklog.GID(), "panic in handler for %s %s: %v\n%s",
+						ctx.Method, ctx.Path, r, debug.Stack())
+					if ctx.Writer.Committed() {
+						ctx.Writer.Abort()
+					} else {
+						ctx.Error(500, "")
+					}
+				}
+			}()
+			next(ctx)
+		}
+	}
+}
+
+// Timing 在响应头中加入 X-Response-Time 与 Server-Timing（计时截止到响应头发送）
+func Timing() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			tw := &timingWriter{ResponseWriter: ctx.Writer, start: time.Now()}
+			ctx.Writer = tw
+			defer func() { ctx.Writer = tw.ResponseWriter }()
+			next(ctx)
+		}
+	}
+}
+
+// timingWriter 在响应头发送前写入计时头
+type timingWriter struct {
+	ResponseWriter
+	start time.Time
+}
+
+func (w *timingWriter) stamp() {
+	if w.Committed() {
+		return
+	}
+	d := time.Since(w.start)
+	w.Header().Set("X-Response-Time", d.String())
+	w.Header().Set("Server-Timing", fmt.Sprintf("app;dur=%.3f", float64(d.Microseconds())/1000))
+}
+
+func (w *timingWriter) Error(code int, explain string) {
+	w.stamp()
+	w.ResponseWriter.Error(code, explain)
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.stamp()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(p []byte) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *timingWriter) Flush() error {
+	w.stamp()
+	return w.ResponseWriter.Flush()
+}
@@ -0,0 +1,52 @@
+package router
+
+import "net/textproto"
+
+// Header 响应头集合，键名统一按 MIME 规范大小写存储
+type Header map[string][]string
+
+// Set 设置响应头，覆盖已有的值
+func (h Header) Set(key, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{value}
+}
+
+// Add 追加一个响应头的值
+func (h Header) Add(key, value string) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Get 返回响应头的第一个值
+func (h Header) Get(key string) string {
+	if v := h[textproto.CanonicalMIMEHeaderKey(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Values 返回响应头的全部值
+func (h Header) Values(key string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(key)]
+}
+
+// Del 删除响应头
+func (h Header) Del(key string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// ResponseWriter 路由处理器的响应输出接口，由 handler 包实现
+//
+// 在第一次 Write/Flush 之前可以通过 Header() 修改响应头、通过 Status 设置状态码；
+// 未设置 Content-Length 时，HTTP/1.1 使用 chunked 编码流式输出，
+// HTTP/1.0 则输出完毕后关闭连接。
+type ResponseWriter interface {
+	Header() Header
+	Status(code int)      // 设置状态码，随第一次写出一起发送
+	StatusCode() int      // 当前（或已发送）的状态码
+	WriteHeader(code int) // 立即发送状态行与响应头
+	Write(p []byte) (int, error)
+	Flush() error
+	Committed() bool                // 响应头是否已经发送
+	Error(code int, explain string) // 以服务器的错误页（或分组的错误格式）应答，响应头已发送时被忽略
+	Abort()                         // 放弃未完成的响应：不再结束响应体，发送后关闭连接
+}
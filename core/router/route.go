@@ -1,29 +1,152 @@
 package router
 
-// 注册路由
-func (r *Router) RegisterRoute(method, pattern, description string, handler HandlerFunc) {
+import (
+	"sort"
+	"strings"
+
+	"github.com/Singert/xjtu_cnlab/core/cors"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+)
+
+// 注册路由，模式冲突时返回错误且不注册
+func (r *Router) RegisterRoute(method, pattern, description string, handler HandlerFunc) error {
+	return r.register(method, pattern, description, handler, nil, nil, nil)
+}
+
+func (r *Router) register(method, pattern, description string, handler HandlerFunc, mws []Middleware, policy *cors.Policy, ef *ErrorFormat) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.routes = append(r.routes, RouteEntry{
+	if !strings.HasPrefix(pattern, "/") {
+		pattern = "/" + pattern
+	}
+	entry := &RouteEntry{
 		Method:      method,
 		Pattern:     pattern,
 		Description: description,
 		Handler:     handler,
-	})
+		middlewares: mws,
+		cors:        policy,
+		errorFormat: ef,
+	}
+
+	segs, err := parsePattern(pattern)
+	if err == nil {
+		root := r.trees[method]
+		if root == nil {
+			root = &node{}
+			r.trees[method] = root
+		}
+		err = root.insert(segs, entry)
+	}
+	if err != nil {
+		talklog.Warn(talklog.GID(), "Register route failed: %v", err)
+		return err
+	}
+
+	r.routes = append(r.routes, entry)
+	return nil
 }
 
-// 匹配路由
-func (r *Router) MatchRoute(method, path string) (HandlerFunc, bool) {
+// 匹配路由，返回包装好中间件的处理器与路径参数
+func (r *Router) MatchRoute(method, path string) (HandlerFunc, Params, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	for _, route := range r.routes {
-		if route.Method == method && route.Pattern == path {
-			return route.Handler, true
+	root := r.trees[method]
+	if root == nil {
+		return nil, nil, false
+	}
+	var params Params
+	route := root.lookup(splitPath(path), &params)
+	if route == nil || route.Handler == nil {
+		return nil, nil, false
+	}
+	handler := chain(route.Handler, route.middlewares)
+	return chain(handler, r.middlewares), params, true
+}
+
+// AllowedMethods 返回路径已注册的方法集合（已排序），路径不存在时返回 nil
+// 注册了 GET 的路径同时允许 HEAD，任何已注册的路径都允许 OPTIONS
+func (r *Router) AllowedMethods(path string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	segs := splitPath(path)
+	set := make(map[string]bool)
+	for method, root := range r.trees {
+		var params Params
+		if route := root.lookup(segs, &params); route != nil && route.Handler != nil {
+			set[method] = true
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	if set["GET"] {
+		set["HEAD"] = true
+	}
+	set["OPTIONS"] = true
+	methods := make([]string, 0, len(set))
+	for m := range set {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// CORSPolicy 返回路径所属路由的跨域策略
+// 优先使用与 method 匹配的路由（预检请求传入 Access-Control-Request-Method），
+// 否则按方法名的顺序取该路径上第一个设置了策略的路由；都没有时返回 nil
+func (r *Router) CORSPolicy(method, path string) *cors.Policy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if route := r.routeFor(method, splitPath(path), func(e *RouteEntry) bool { return e.cors != nil }); route != nil {
+		return route.cors
+	}
+	return nil
+}
+
+// ErrorFormat 返回路径所属路由的错误响应格式，选择路由的方式与 CORSPolicy 相同，都没有时返回 nil
+func (r *Router) ErrorFormat(method, path string) *ErrorFormat {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if route := r.routeFor(method, splitPath(path), func(e *RouteEntry) bool { return e.errorFormat != nil }); route != nil {
+		return route.errorFormat
+	}
+	return nil
+}
+
+// routeFor 返回决定路径设置的路由：method 的路由（HEAD 还有 GET 的路由）存在时使用它，
+// 否则按方法名的顺序返回第一个满足 has 的路由，结果不随 map 的遍历顺序变化；调用方持有读锁
+func (r *Router) routeFor(method string, segs []string, has func(*RouteEntry) bool) *RouteEntry {
+	preferred := []string{method}
+	if method == "HEAD" {
+		preferred = append(preferred, "GET")
+	}
+	for _, m := range preferred {
+		if root := r.trees[m]; root != nil {
+			var params Params
+			if route := root.lookup(segs, &params); route != nil && route.Handler != nil {
+				return route
+			}
 		}
 	}
-	return nil, false
+
+	methods := make([]string, 0, len(r.trees))
+	for m := range r.trees {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	for _, m := range methods {
+		var params Params
+		if route := r.trees[m].lookup(segs, &params); route != nil && route.Handler != nil && has(route) {
+			return route
+		}
+	}
+	return nil
 }
 
 // 注册一个新的路由组
@@ -35,10 +158,34 @@ func (r *Router) RegisterGroupRoute(prefix string, fn func(g *Group)) {
 	fn(g)
 }
 
+// 注册子路由组，继承当前分组的前缀与中间件
+func (g *Group) RegisterGroupRoute(prefix string, fn func(g *Group)) {
+	child := &Group{
+		prefix:      g.prefix + prefix,
+		route:       g.route,
+		middlewares: append([]Middleware(nil), g.middlewares...),
+		cors:        g.cors,
+		errorFormat: g.errorFormat,
+	}
+	fn(child)
+}
+
+// CORS 为分组内之后注册的路由设置跨域策略，覆盖全局配置，子分组继承
+func (g *Group) CORS(policy *cors.Policy) {
+	g.cors = policy
+}
+
+// ErrorFormat 为分组内之后注册的路由设置错误响应的格式与类型，覆盖服务器的错误页，子分组继承
+// 如 API 分组可以使用 `{"code": %d, "message": "%s", "status": %d, "detail": "%s"}` 与 application/json
+func (g *Group) ErrorFormat(format, contentType string) {
+	g.errorFormat = &ErrorFormat{Format: format, ContentType: contentType}
+}
+
 // Group内部注册路由
-func (g *Group) RegisterRoute(method, pattern, disposition string, handler HandlerFunc) {
+func (g *Group) RegisterRoute(method, pattern, disposition string, handler HandlerFunc) error {
 	fullPath := g.prefix + pattern
-	g.route.RegisterRoute(method, fullPath, disposition, handler)
+	mws := append([]Middleware(nil), g.middlewares...)
+	return g.route.register(method, fullPath, disposition, handler, mws, g.cors, g.errorFormat)
 }
 
 // 热更新
@@ -46,10 +193,11 @@ func (r *Router) Update(method, pattern, newDisposition string, newHandler Handl
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	for i, route := range r.routes {
+	// 树中的叶子节点与 routes 共享同一个 RouteEntry，直接修改即可生效
+	for _, route := range r.routes {
 		if route.Method == method && route.Pattern == pattern {
-			r.routes[i].Handler = newHandler
-			r.routes[i].Description = newDisposition
+			route.Handler = newHandler
+			route.Description = newDisposition
 			return true
 		}
 	}
@@ -62,7 +210,11 @@ func (r *Router) ListRoutes() []RouteEntry {
 	defer r.mu.RUnlock()
 
 	// 拷贝一份返回，避免外部修改
+	global := middlewareNames(r.middlewares)
 	result := make([]RouteEntry, len(r.routes))
-	copy(result, r.routes)
+	for i, route := range r.routes {
+		result[i] = *route
+		result[i].Middlewares = append(append([]string(nil), global...), middlewareNames(route.middlewares)...)
+	}
 	return result
 }
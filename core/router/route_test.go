@@ -0,0 +1,51 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/Singert/xjtu_cnlab/core/cors"
+)
+
+func TestRouteSettingsByMethod(t *testing.T) {
+	r := NewRouter()
+	noop := func(*Context) {}
+	strict := cors.MustNew(cors.Policy{AllowOrigins: []string{"https://a.example"}})
+	open := cors.MustNew(cors.Policy{AllowOrigins: []string{"*"}})
+	r.RegisterGroupRoute("/api", func(g *Group) {
+		g.ErrorFormat("json", "application/json")
+		g.CORS(strict)
+		g.RegisterRoute("POST", "/items", "create", noop)
+	})
+	r.RegisterGroupRoute("/api", func(g *Group) {
+		g.ErrorFormat("text", "text/plain")
+		g.CORS(open)
+		g.RegisterRoute("GET", "/items", "list", noop)
+	})
+
+	tests := []struct {
+		method string
+		format string
+		policy *cors.Policy
+	}{
+		{"POST", "json", strict},
+		{"GET", "text", open},
+		{"HEAD", "text", open},
+		// 没有该方法的路由时按方法名的顺序取第一个：GET
+		{"PUT", "text", open},
+		{"DELETE", "text", open},
+	}
+	// 多次查询，结果不能随 map 的遍历顺序变化
+	for i := 0; i < 50; i++ {
+		for _, tt := range tests {
+			if ef := r.ErrorFormat(tt.method, "/api/items"); ef == nil || ef.Format != tt.format {
+				t.Fatalf("ErrorFormat(%s) = %+v, want %s", tt.method, ef, tt.format)
+			}
+			if p := r.CORSPolicy(tt.method, "/api/items"); p != tt.policy {
+				t.Fatalf("CORSPolicy(%s) = %p, want %p", tt.method, p, tt.policy)
+			}
+		}
+	}
+	if ef := r.ErrorFormat("GET", "/other"); ef != nil {
+		t.Fatalf("ErrorFormat(/other) = %+v, want nil", ef)
+	}
+}
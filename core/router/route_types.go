@@ -1,7 +1,11 @@
 package router
 
 import (
+	"mime/multipart"
+	"net/url"
 	"sync"
+
+	"github.com/Singert/xjtu_cnlab/core/cors"
 )
 
 type HandlerFunc func(*Context)
@@ -18,10 +22,17 @@ type Context struct {
 	Method      string
 	Path        string
 	Headers     map[string]string
-	Body        []byte
+	Body        *RequestBody // 惰性读取的请求体
 	Query       map[string]string
-	Conn        any
+	RawQuery    string
+	Params      Params // 路径参数，如 /user/:id 中的 id
+	Conn        any    // 底层连接，响应请通过 Writer 输出
 	RouterAware RouterProvider
+	Writer      ResponseWriter // 响应输出，支持流式写出与 Flush
+	RequestID   string         // 请求 ID，与 X-Request-ID 响应头相同
+
+	form          url.Values
+	multipartForm *multipart.Form
 }
 
 // 表示一个路由规则
@@ -30,26 +41,46 @@ type RouteEntry struct {
 	Pattern     string
 	Description string
 	Handler     HandlerFunc
+	Middlewares []string // 生效的中间件名称（全局在前），由 ListRoutes 填充
+
+	middlewares []Middleware // 注册时所在分组的中间件
+	cors        *cors.Policy // 注册时所在分组的跨域策略，nil 表示使用全局配置
+	errorFormat *ErrorFormat // 注册时所在分组的错误响应格式，nil 表示使用服务器的错误页
+}
+
+// ErrorFormat 路由分组的错误响应格式
+// Format 的占位符与 utils.DefaultErrorMessageFormat 相同：状态码、消息、状态码、说明，
+// 消息与说明按 ContentType 转义（JSON 或 HTML）
+type ErrorFormat struct {
+	Format      string
+	ContentType string
 }
 
 type RouteEntryJSON struct {
-	Method      string `json:"method"`
-	Pattern     string `json:"pattern"`
-	Description string `json:"description"`
+	Method      string   `json:"method"`
+	Pattern     string   `json:"pattern"`
+	Description string   `json:"description"`
+	Middlewares []string `json:"middlewares,omitempty"`
 }
 
 type Router struct {
-	routes []RouteEntry
-	mu     sync.RWMutex
+	routes      []*RouteEntry    // 按注册顺序保存，用于列出路由
+	trees       map[string]*node // 每个方法一棵路由树，用于匹配
+	middlewares []Middleware     // 全局中间件
+	mu          sync.RWMutex
 }
 
 type Group struct {
-	prefix string
-	route  *Router
+	prefix      string
+	route       *Router
+	middlewares []Middleware
+	cors        *cors.Policy
+	errorFormat *ErrorFormat
 }
 
 func NewRouter() *Router {
 	return &Router{
-		routes: make([]RouteEntry, 0),
+		routes: make([]*RouteEntry, 0),
+		trees:  make(map[string]*node),
 	}
 }
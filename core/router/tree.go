@@ -0,0 +1,258 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 路由树按路径段（以 / 分隔）组织，每个 HTTP 方法一棵：
+//
+//	/api/user/:id          命名参数，匹配一个非空路径段
+//	/api/user/:id(\d+)     带正则约束的命名参数，正则需匹配整个路径段
+//	/files/*path           通配参数，匹配剩余的全部路径（可为空），只能位于末尾
+//
+// 同一位置的匹配优先级为：静态段 > 带约束的参数 > 普通参数 > 通配参数，
+// 高优先级分支匹配失败时会回溯尝试下一种，查找代价只与路径段数有关。
+
+// Param 一个路径参数
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params 路径参数列表，按在路径中出现的顺序排列
+type Params []Param
+
+// Get 返回参数值，不存在时返回空字符串
+func (ps Params) Get(name string) string {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+type node struct {
+	children   map[string]*node // 静态子节点
+	params     []*node          // 参数子节点，带约束的排在前面
+	catchAll   *node            // 通配子节点
+	name       string           // 参数名（参数/通配节点）
+	constraint string           // 原始正则约束
+	regex      *regexp.Regexp
+	route      *RouteEntry // 以该节点结尾的路由
+}
+
+// segmentKind 解析后的模式段类型
+type segmentKind int
+
+const (
+	staticSegment segmentKind = iota
+	paramSegment
+	catchAllSegment
+)
+
+type patternSegment struct {
+	kind       segmentKind
+	text       string // 静态段文本或参数名
+	constraint string
+}
+
+// splitPath 将路径切分为路径段，"/" 对应一个空段
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// parsePattern 解析路由模式
+func parsePattern(pattern string) ([]patternSegment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern %q must begin with '/'", pattern)
+	}
+	parts := splitPath(pattern)
+	segs := make([]patternSegment, 0, len(parts))
+	seen := make(map[string]bool)
+	for i, part := range parts {
+		switch {
+		case strings.HasPrefix(part, ":"):
+			name, constraint := part[1:], ""
+			if open := strings.IndexByte(name, '('); open >= 0 {
+				if !strings.HasSuffix(name, ")") {
+					return nil, fmt.Errorf("pattern %q: unterminated constraint in %q", pattern, part)
+				}
+				name, constraint = name[:open], name[open+1:len(name)-1]
+				if constraint == "" {
+					return nil, fmt.Errorf("pattern %q: empty constraint in %q", pattern, part)
+				}
+			}
+			if !validParamName(name) {
+				return nil, fmt.Errorf("pattern %q: invalid parameter name in %q", pattern, part)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("pattern %q: duplicate parameter %q", pattern, name)
+			}
+			seen[name] = true
+			segs = append(segs, patternSegment{kind: paramSegment, text: name, constraint: constraint})
+		case strings.HasPrefix(part, "*"):
+			name := part[1:]
+			if !validParamName(name) {
+				return nil, fmt.Errorf("pattern %q: invalid wildcard name in %q", pattern, part)
+			}
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("pattern %q: wildcard %q must be the last segment", pattern, part)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("pattern %q: duplicate parameter %q", pattern, name)
+			}
+			segs = append(segs, patternSegment{kind: catchAllSegment, text: name})
+		default:
+			// 段中间出现的 : 和 * 按字面量处理，参数必须独占一个路径段
+			segs = append(segs, patternSegment{kind: staticSegment, text: part})
+		}
+	}
+	return segs, nil
+}
+
+func validParamName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// insert 将路由插入树中，冲突时返回错误且不修改树
+func (n *node) insert(segs []patternSegment, route *RouteEntry) error {
+	// 先检查冲突再修改，保证失败时树保持不变
+	if err := n.check(segs, route); err != nil {
+		return err
+	}
+	cur := n
+	for _, seg := range segs {
+		switch seg.kind {
+		case staticSegment:
+			if cur.children == nil {
+				cur.children = make(map[string]*node)
+			}
+			child := cur.children[seg.text]
+			if child == nil {
+				child = &node{}
+				cur.children[seg.text] = child
+			}
+			cur = child
+		case paramSegment:
+			child := cur.findParam(seg.constraint)
+			if child == nil {
+				child = &node{name: seg.text, constraint: seg.constraint}
+				if seg.constraint != "" {
+					child.regex = regexp.MustCompile("^(?:" + seg.constraint + ")$")
+				}
+				cur.addParam(child)
+			}
+			cur = child
+		case catchAllSegment:
+			if cur.catchAll == nil {
+				cur.catchAll = &node{name: seg.text}
+			}
+			cur = cur.catchAll
+		}
+	}
+	cur.route = route
+	return nil
+}
+
+// check 检查插入是否会与已有路由冲突
+func (n *node) check(segs []patternSegment, route *RouteEntry) error {
+	cur := n
+	for _, seg := range segs {
+		if cur == nil {
+			break
+		}
+		switch seg.kind {
+		case staticSegment:
+			cur = cur.children[seg.text]
+		case paramSegment:
+			if seg.constraint != "" {
+				if _, err := regexp.Compile("^(?:" + seg.constraint + ")$"); err != nil {
+					return fmt.Errorf("route %s %s: bad constraint for :%s: %v", route.Method, route.Pattern, seg.text, err)
+				}
+			}
+			child := cur.findParam(seg.constraint)
+			if child != nil && child.name != seg.text {
+				return fmt.Errorf("route %s %s: parameter :%s conflicts with :%s of existing route",
+					route.Method, route.Pattern, seg.text, child.name)
+			}
+			cur = child
+		case catchAllSegment:
+			if cur.catchAll != nil && cur.catchAll.name != seg.text {
+				return fmt.Errorf("route %s %s: wildcard *%s conflicts with *%s of existing route",
+					route.Method, route.Pattern, seg.text, cur.catchAll.name)
+			}
+			cur = cur.catchAll
+		}
+	}
+	if cur != nil && cur.route != nil {
+		return fmt.Errorf("route %s %s conflicts with existing route %s %s",
+			route.Method, route.Pattern, cur.route.Method, cur.route.Pattern)
+	}
+	return nil
+}
+
+// findParam 返回约束相同的参数子节点
+func (n *node) findParam(constraint string) *node {
+	for _, p := range n.params {
+		if p.constraint == constraint {
+			return p
+		}
+	}
+	return nil
+}
+
+// addParam 插入参数子节点：带约束的按注册顺序在前，无约束的在最后
+func (n *node) addParam(child *node) {
+	if child.constraint == "" {
+		n.params = append(n.params, child)
+		return
+	}
+	i := len(n.params)
+	if i > 0 && n.params[i-1].constraint == "" {
+		i--
+	}
+	n.params = append(n.params, nil)
+	copy(n.params[i+1:], n.params[i:])
+	n.params[i] = child
+}
+
+// lookup 查找与路径段匹配的路由，匹配到的参数追加到 ps
+func (n *node) lookup(segs []string, ps *Params) *RouteEntry {
+	if len(segs) == 0 {
+		return n.route
+	}
+	seg := segs[0]
+	if child := n.children[seg]; child != nil {
+		if route := child.lookup(segs[1:], ps); route != nil {
+			return route
+		}
+	}
+	if seg != "" {
+		for _, p := range n.params {
+			if p.regex != nil && !p.regex.MatchString(seg) {
+				continue
+			}
+			*ps = append(*ps, Param{Key: p.name, Value: seg})
+			if route := p.lookup(segs[1:], ps); route != nil {
+				return route
+			}
+			*ps = (*ps)[:len(*ps)-1]
+		}
+	}
+	if n.catchAll != nil && n.catchAll.route != nil {
+		*ps = append(*ps, Param{Key: n.catchAll.name, Value: strings.Join(segs, "/")})
+		return n.catchAll.route
+	}
+	return nil
+}
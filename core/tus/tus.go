@@ -0,0 +1,634 @@
+// Package tus 实现 tus 1.0 断点续传协议（https://tus.io/protocols/resumable-upload）
+//
+// 支持的扩展：creation、expiration、termination、checksum。未完成的上传暂存在
+// tus.UploadDir 中，每个上传由数据文件 <id>.bin 与描述文件 <id>.info 组成，
+// 当前偏移量即数据文件的大小；上传完成后按 upload 段的覆盖策略发布到工作目录。
+// 发布失败（如同名文件已存在）时数据保留到上传过期，客户端可以在冲突解决后
+// 以当前偏移量发送空的 PATCH 重新完成上传。
+package tus
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Singert/xjtu_cnlab/core/config"
+	"github.com/Singert/xjtu_cnlab/core/handler"
+	"github.com/Singert/xjtu_cnlab/core/router"
+	"github.com/Singert/xjtu_cnlab/core/talklog"
+)
+
+// 协议常量
+const (
+	Version            = "1.0.0"
+	Extensions         = "creation,expiration,termination,checksum"
+	ChecksumAlgorithms = "md5,sha1,sha256"
+	OffsetContentType  = "application/offset+octet-stream"
+
+	statusChecksumMismatch = 460
+)
+
+// 默认配置
+const (
+	defaultPath       = "/tus/"
+	defaultExpiration = 24 * time.Hour
+	sweepInterval     = time.Minute
+)
+
+var errNotFound = errors.New("upload not found")
+
+// Info 一个上传的描述信息，保存在 <id>.info 中
+type Info struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Metadata  map[string]string `json:"metadata"`
+	RawMeta   string            `json:"raw_metadata"` // 原样返回给 HEAD 请求
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Server tus 协议的路由处理器
+type Server struct {
+	prefix     string // 以 / 结尾的挂载路径
+	dir        string // 暂存目录
+	targetDir  string // 完成后的保存目录
+	maxSize    int64
+	expiration time.Duration
+
+	mu        sync.Mutex
+	locks     map[string]bool // 正在处理 PATCH/DELETE 的上传
+	lastSweep time.Time
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultServer *Server
+	defaultErr    error
+)
+
+// Register 按 config.yml 的 tus 段在路由上挂载 tus 端点，未开启时不做任何事
+// HTTP 与 HTTPS 的路由共享同一个 Server
+func Register(r *router.Router) {
+	if !config.Cfg.Tus.Enabled {
+		return
+	}
+	defaultOnce.Do(func() {
+		defaultServer, defaultErr = NewServer()
+	})
+	if defaultErr != nil {
+		talklog.Error(talklog.GID(), "tus disabled: %v", defaultErr)
+		return
+	}
+	defaultServer.Register(r)
+}
+
+// NewServer 按配置创建 Server 并准备暂存目录
+func NewServer() (*Server, error) {
+	cfg := config.Cfg.Tus
+	s := &Server{
+		prefix:     cfg.Path,
+		dir:        cfg.UploadDir,
+		targetDir:  filepath.Join(config.Cfg.Server.Workdir, filepath.Clean("/"+cfg.TargetDir)),
+		maxSize:    cfg.MaxSize,
+		expiration: time.Duration(cfg.Expiration) * time.Second,
+		locks:      make(map[string]bool),
+	}
+	if s.prefix == "" {
+		s.prefix = defaultPath
+	}
+	s.prefix = "/" + strings.Trim(s.prefix, "/") + "/"
+	if s.dir == "" {
+		s.dir = filepath.Join(os.TempDir(), "xjtu_cnlab-tus")
+	}
+	if s.expiration <= 0 {
+		s.expiration = defaultExpiration
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(s.targetDir); err != nil || !fi.IsDir() {
+		return nil, errors.New("tus target directory does not exist: " + s.targetDir)
+	}
+	return s, nil
+}
+
+// Register 在路由上注册 tus 端点
+func (s *Server) Register(r *router.Router) {
+	collection := strings.TrimSuffix(s.prefix, "/")
+	for _, p := range []string{collection, s.prefix} {
+		r.RegisterRoute("OPTIONS", p, "tus capability discovery", s.handleOptions)
+		r.RegisterRoute("POST", p, "tus creation", s.handleCreate)
+	}
+	if fi, err := fs.Stat(handler.Docroot(), strings.Trim(s.prefix, "/")); err == nil && fi.IsDir() {
+		talklog.Warn(talklog.GID(), "tus endpoint %s shadows the static directory of the same name", s.prefix)
+	}
+	resource := s.prefix + ":id"
+	r.RegisterRoute("OPTIONS", resource, "tus capability discovery", s.handleOptions)
+	r.RegisterRoute("HEAD", resource, "tus upload offset", s.handleHead)
+	r.RegisterRoute("PATCH", resource, "tus upload chunk", s.handlePatch)
+	r.RegisterRoute("DELETE", resource, "tus termination", s.handleDelete)
+	talklog.Info(talklog.GID(), "tus endpoint mounted at %s, finalizing into %s", s.prefix, s.targetDir)
+}
+
+func (s *Server) dataPath(id string) string { return filepath.Join(s.dir, id+".bin") }
+func (s *Server) infoPath(id string) string { return filepath.Join(s.dir, id+".info") }
+
+// validID 上传 ID 是 32 位十六进制数，防止路径参数指向暂存目录之外
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// lock 独占一个上传，已被其他请求占用时返回 false
+func (s *Server) lock(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks[id] {
+		return false
+	}
+	s.locks[id] = true
+	return true
+}
+
+func (s *Server) unlock(id string) {
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+}
+
+// load 读取上传信息与当前偏移量，过期的上传会被删除并视为不存在
+func (s *Server) load(id string) (*Info, int64, error) {
+	if !validID(id) {
+		return nil, 0, errNotFound
+	}
+	data, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, errNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, 0, err
+	}
+	if time.Now().After(info.ExpiresAt) {
+		s.remove(id)
+		return nil, 0, errNotFound
+	}
+	fi, err := os.Stat(s.dataPath(id))
+	if err != nil {
+		return nil, 0, errNotFound
+	}
+	return &info, fi.Size(), nil
+}
+
+// save 写入上传信息（先写临时文件再重命名）
+func (s *Server) save(info *Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(info.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(info.ID))
+}
+
+func (s *Server) remove(id string) {
+	os.Remove(s.infoPath(id))
+	os.Remove(s.dataPath(id))
+}
+
+// sweep 删除过期的上传，最多每分钟执行一次
+func (s *Server) sweep() {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) < sweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), ".info"); ok && validID(id) && s.lock(id) {
+			s.load(id) // 过期时删除
+			s.unlock(id)
+		}
+	}
+}
+
+// newID 生成随机的上传 ID
+func newID() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// parseMetadata 解析 Upload-Metadata："key base64value,key2 base64value2"
+func parseMetadata(raw string) (map[string]string, bool) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		if key == "" {
+			return nil, false
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, false
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, true
+}
+
+// newChecksum 解析 Upload-Checksum："<algorithm> <base64 digest>"
+func newChecksum(header string) (hash.Hash, []byte, bool) {
+	algo, value, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, nil, false
+	}
+	want, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, nil, false
+	}
+	switch strings.ToLower(algo) {
+	case "md5":
+		return md5.New(), want, true
+	case "sha1":
+		return sha1.New(), want, true
+	case "sha256":
+		return sha256.New(), want, true
+	}
+	return nil, nil, false
+}
+
+// commonHeaders 设置每个 tus 响应都携带的头
+func commonHeaders(ctx *router.Context) {
+	ctx.Header().Set("Tus-Resumable", Version)
+	ctx.Header().Set("Cache-Control", "no-store")
+}
+
+// checkVersion 检查 Tus-Resumable，不支持的版本应答 412
+func checkVersion(ctx *router.Context) bool {
+	commonHeaders(ctx)
+	if ctx.Headers["Tus-Resumable"] == Version {
+		return true
+	}
+	ctx.Header().Set("Tus-Version", Version)
+	ctx.Text(412, "Unsupported tus version")
+	return false
+}
+
+// expires 设置 Upload-Expires 头
+func expires(ctx *router.Context, info *Info) {
+	ctx.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(time.RFC1123))
+}
+
+// handleOptions 返回服务器支持的协议版本与扩展
+func (s *Server) handleOptions(ctx *router.Context) {
+	commonHeaders(ctx)
+	h := ctx.Header()
+	h.Set("Tus-Version", Version)
+	h.Set("Tus-Extension", Extensions)
+	h.Set("Tus-Checksum-Algorithm", ChecksumAlgorithms)
+	if s.maxSize > 0 {
+		h.Set("Tus-Max-Size", strconv.FormatInt(s.maxSize, 10))
+	}
+	ctx.Status(204)
+}
+
+// handleCreate 创建上传（creation 扩展），应答 201 与 Location
+func (s *Server) handleCreate(ctx *router.Context) {
+	if !checkVersion(ctx) {
+		return
+	}
+	go s.sweep()
+
+	if _, ok := ctx.Headers["Upload-Defer-Length"]; ok {
+		ctx.Text(400, "Upload-Defer-Length is not supported")
+		return
+	}
+	length, err := strconv.ParseInt(ctx.Headers["Upload-Length"], 10, 64)
+	if err != nil || length < 0 {
+		ctx.Text(400, "Missing or invalid Upload-Length")
+		return
+	}
+	if s.maxSize > 0 && length > s.maxSize {
+		ctx.Text(413, "Upload-Length exceeds Tus-Max-Size")
+		return
+	}
+	rawMeta := ctx.Headers["Upload-Metadata"]
+	meta, ok := parseMetadata(rawMeta)
+	if !ok {
+		ctx.Text(400, "Invalid Upload-Metadata")
+		return
+	}
+	if name, ok := fileName(meta); ok {
+		clean := handler.SanitizeFilename(name)
+		if clean == "" {
+			ctx.Text(400, "Invalid file name in Upload-Metadata")
+			return
+		}
+		rel, _ := filepath.Rel(config.Cfg.Server.Workdir, filepath.Join(s.targetDir, clean))
+		if _, err := handler.ResolvePath(config.Cfg.Server.Workdir, filepath.ToSlash(rel)); err != nil {
+			ctx.Text(403, "File name not allowed")
+			return
+		}
+		// 不允许覆盖时尽早拒绝，避免客户端传完全部数据后才发现冲突
+		if err := handler.CheckTarget(s.targetDir, clean); err != nil {
+			s.fail(ctx, err)
+			return
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		ctx.Text(500, "Cannot create upload")
+		return
+	}
+	now := time.Now()
+	info := &Info{ID: id, Length: length, Metadata: meta, RawMeta: rawMeta, CreatedAt: now, ExpiresAt: now.Add(s.expiration)}
+	f, err := os.OpenFile(s.dataPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err == nil {
+		f.Close()
+		err = s.save(info)
+	}
+	if err != nil {
+		s.remove(id)
+		talklog.Error(talklog.GID(), "tus: cannot create upload: %v", err)
+		ctx.Text(500, "Cannot create upload")
+		return
+	}
+	talklog.Info(talklog.GID(), "tus: created upload %s (%d bytes)", id, length)
+
+	if length == 0 {
+		location, err := s.finalize(info)
+		if err != nil {
+			s.fail(ctx, err)
+			return
+		}
+		ctx.Header().Set("Content-Location", location)
+	}
+	ctx.Header().Set("Location", s.prefix+id)
+	expires(ctx, info)
+	ctx.Status(201)
+}
+
+// handleHead 返回当前偏移量，客户端据此续传
+func (s *Server) handleHead(ctx *router.Context) {
+	if !checkVersion(ctx) {
+		return
+	}
+	info, offset, err := s.load(ctx.Param("id"))
+	if err != nil {
+		s.fail(ctx, err)
+		return
+	}
+	h := ctx.Header()
+	h.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	if info.RawMeta != "" {
+		h.Set("Upload-Metadata", info.RawMeta)
+	}
+	expires(ctx, info)
+	ctx.Status(200)
+}
+
+// handlePatch 在 Upload-Offset 处追加数据
+//
+// 连接中断时已写入的数据会保留，客户端 HEAD 后从新的偏移量继续；
+// 带 Upload-Checksum 时整块数据校验通过才保留，否则回退并应答 460
+func (s *Server) handlePatch(ctx *router.Context) {
+	if !checkVersion(ctx) {
+		return
+	}
+	if ct := ctx.Headers["Content-Type"]; ct != OffsetContentType {
+		ctx.Text(415, "Content-Type must be "+OffsetContentType)
+		return
+	}
+	clientOffset, err := strconv.ParseInt(ctx.Headers["Upload-Offset"], 10, 64)
+	if err != nil || clientOffset < 0 {
+		ctx.Text(400, "Missing or invalid Upload-Offset")
+		return
+	}
+	var sum hash.Hash
+	var want []byte
+	if header, ok := ctx.Headers["Upload-Checksum"]; ok {
+		if sum, want, ok = newChecksum(header); !ok {
+			ctx.Text(400, "Unsupported or invalid Upload-Checksum")
+			return
+		}
+	}
+
+	id := ctx.Param("id")
+	if !s.lock(id) {
+		ctx.Text(409, "Upload is locked by another request")
+		return
+	}
+	defer s.unlock(id)
+	info, offset, err := s.load(id)
+	if err != nil {
+		s.fail(ctx, err)
+		return
+	}
+	if clientOffset != offset {
+		ctx.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		ctx.Text(409, "Upload-Offset does not match")
+		return
+	}
+	remaining := info.Length - offset
+	if cl, ok := ctx.Headers["Content-Length"]; ok {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n > remaining {
+			ctx.Text(413, "Chunk exceeds Upload-Length")
+			return
+		}
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		s.fail(ctx, err)
+		return
+	}
+	var w io.Writer = f
+	if sum != nil {
+		w = io.MultiWriter(f, sum)
+	}
+	n, copyErr := io.Copy(w, io.LimitReader(ctx.Body, remaining))
+	if sum != nil && (copyErr != nil || string(sum.Sum(nil)) != string(want)) {
+		// 校验失败或数据不完整时丢弃本次写入的全部数据
+		f.Truncate(offset)
+		f.Close()
+		ctx.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		if copyErr != nil {
+			talklog.Warn(talklog.GID(), "tus: upload %s interrupted: %v", id, copyErr)
+			ctx.Text(400, "Incomplete request body")
+			return
+		}
+		ctx.Data(statusChecksumMismatch, "text/plain; charset=utf-8", []byte("Checksum Mismatch"))
+		return
+	}
+	syncErr := f.Sync()
+	f.Close()
+	offset += n
+	if copyErr != nil || syncErr != nil {
+		// 已写入的部分保留，客户端通过 HEAD 获取偏移量后续传
+		talklog.Warn(talklog.GID(), "tus: upload %s interrupted at %d: %v", id, offset, errors.Join(copyErr, syncErr))
+		ctx.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		ctx.Text(400, "Incomplete request body")
+		return
+	}
+
+	info.ExpiresAt = time.Now().Add(s.expiration)
+	if err := s.save(info); err != nil {
+		s.fail(ctx, err)
+		return
+	}
+	if offset == info.Length {
+		location, err := s.finalize(info)
+		if err != nil {
+			s.fail(ctx, err)
+			return
+		}
+		ctx.Header().Set("Content-Location", location)
+	}
+	ctx.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	expires(ctx, info)
+	ctx.Status(204)
+}
+
+// handleDelete 终止上传并删除已上传的数据（termination 扩展）
+func (s *Server) handleDelete(ctx *router.Context) {
+	if !checkVersion(ctx) {
+		return
+	}
+	id := ctx.Param("id")
+	if !s.lock(id) {
+		ctx.Text(409, "Upload is locked by another request")
+		return
+	}
+	defer s.unlock(id)
+	if _, _, err := s.load(id); err != nil {
+		s.fail(ctx, err)
+		return
+	}
+	s.remove(id)
+	talklog.Info(talklog.GID(), "tus: terminated upload %s", id)
+	ctx.Status(204)
+}
+
+// fileName 从元数据中取客户端的文件名（tus-js-client 使用 filename，部分客户端使用 name）
+func fileName(meta map[string]string) (string, bool) {
+	for _, key := range []string{"filename", "name"} {
+		if v, ok := meta[key]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// finalize 把完成的上传发布到目标目录，返回文件的 URL 路径
+// 暂存目录与目标目录不在同一文件系统时先复制到目标目录下的临时文件；
+// 发布成功后才删除暂存的上传，失败时数据放回暂存目录
+func (s *Server) finalize(info *Info) (string, error) {
+	name := info.ID
+	if n, ok := fileName(info.Metadata); ok {
+		if clean := handler.SanitizeFilename(n); clean != "" {
+			name = clean
+		}
+	}
+
+	tmp, err := os.CreateTemp(s.targetDir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpName)
+	moved := os.Rename(s.dataPath(info.ID), tmpName) == nil
+	if !moved {
+		if err := copyFile(s.dataPath(info.ID), tmpName); err != nil {
+			return "", err
+		}
+	}
+	os.Chmod(tmpName, 0o644)
+
+	final, _, err := handler.PublishFile(tmpName, s.targetDir, name)
+	if err != nil {
+		if moved {
+			if err := os.Rename(tmpName, s.dataPath(info.ID)); err != nil {
+				talklog.Error(talklog.GID(), "tus: cannot restore upload %s: %v", info.ID, err)
+			}
+		}
+		return "", err
+	}
+	s.remove(info.ID)
+	rel, _ := filepath.Rel(config.Cfg.Server.Workdir, filepath.Join(s.targetDir, final))
+	location := (&url.URL{Path: path.Join("/", filepath.ToSlash(rel))}).EscapedPath()
+	talklog.Info(talklog.GID(), "tus: upload %s finalized as %s", info.ID, location)
+	return location, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// fail 把错误转换为 tus 响应
+func (s *Server) fail(ctx *router.Context, err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		ctx.Text(404, "Upload not found")
+	case errors.Is(err, handler.ErrFileExists):
+		ctx.Text(409, err.Error())
+	default:
+		talklog.Error(talklog.GID(), "tus: %v", err)
+		ctx.Text(500, "Upload storage error")
+	}
+}
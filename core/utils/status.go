@@ -10,6 +10,8 @@ const (
 	ACCEPTED                        HTTPStatus = 202
 	NO_CONTENT                      HTTPStatus = 204
 	RESET_CONTENT                   HTTPStatus = 205
+	PARTIAL_CONTENT                 HTTPStatus = 206
+	MULTI_STATUS                    HTTPStatus = 207
 	MOVED_PERMANENTLY               HTTPStatus = 301
 	FOUND                           HTTPStatus = 302
 	SEE_OTHER                       HTTPStatus = 303
@@ -24,11 +26,18 @@ const (
 	CONFLICT                        HTTPStatus = 409
 	GONE                            HTTPStatus = 410
 	LENGTH_REQUIRED                 HTTPStatus = 411
+	PRECONDITION_FAILED             HTTPStatus = 412
+	REQUEST_ENTITY_TOO_LARGE        HTTPStatus = 413
+	UNSUPPORTED_MEDIA_TYPE          HTTPStatus = 415
+	REQUESTED_RANGE_NOT_SATISFIABLE HTTPStatus = 416
+	LOCKED                          HTTPStatus = 423
+	FAILED_DEPENDENCY               HTTPStatus = 424
 	INTERNAL_SERVER_ERROR           HTTPStatus = 500
 	NOT_IMPLEMENTED                 HTTPStatus = 501
 	BAD_GATEWAY                     HTTPStatus = 502
 	SERVICE_UNAVAILABLE             HTTPStatus = 503
 	HTTP_VERSION_NOT_SUPPORTED      HTTPStatus = 505
+	INSUFFICIENT_STORAGE            HTTPStatus = 507
 	REQUEST_URI_TOO_LONG            HTTPStatus = 414
 	REQUEST_HEADER_FIELDS_TOO_LARGE HTTPStatus = 431
 	CONTINUE                        HTTPStatus = 100
@@ -41,6 +50,8 @@ var StatusMessages = map[HTTPStatus][]string{
 	ACCEPTED:                        {"Accepted", "Request accepted, processing continues"},
 	NO_CONTENT:                      {"No Content", "Request fulfilled, nothing follows"},
 	RESET_CONTENT:                   {"Reset Content", "Clear input form for further input"},
+	PARTIAL_CONTENT:                 {"Partial Content", "Partial content follows"},
+	MULTI_STATUS:                    {"Multi-Status", "Status for multiple independent operations"},
 	MOVED_PERMANENTLY:               {"Moved Permanently", "Object moved permanently"},
 	FOUND:                           {"Found", "Object moved temporarily"},
 	SEE_OTHER:                       {"See Other", "Object moved"},
@@ -54,11 +65,18 @@ var StatusMessages = map[HTTPStatus][]string{
 	CONFLICT:                        {"Conflict", "Request conflict"},
 	GONE:                            {"Gone", "URI no longer exists and has been permanently removed"},
 	LENGTH_REQUIRED:                 {"Length Required", "Client must specify Content-Length"},
+	PRECONDITION_FAILED:             {"Precondition Failed", "Precondition in headers is false"},
+	REQUEST_ENTITY_TOO_LARGE:        {"Request Entity Too Large", "Entity is too large"},
+	UNSUPPORTED_MEDIA_TYPE:          {"Unsupported Media Type", "Entity body in unsupported format"},
+	REQUESTED_RANGE_NOT_SATISFIABLE: {"Requested Range Not Satisfiable", "Cannot satisfy request range"},
+	LOCKED:                          {"Locked", "The resource is locked"},
+	FAILED_DEPENDENCY:               {"Failed Dependency", "The method could not be performed because a dependent action failed"},
 	INTERNAL_SERVER_ERROR:           {"Internal Server Error", "Server got itself in trouble"},
 	NOT_IMPLEMENTED:                 {"Not Implemented", "Server does not support this operation"},
 	BAD_GATEWAY:                     {"Bad Gateway", "Invalid responses from another server/proxy"},
 	SERVICE_UNAVAILABLE:             {"Service Unavailable", "The server cannot process the request due to a high load"},
 	HTTP_VERSION_NOT_SUPPORTED:      {"HTTP Version Not Supported", "Cannot fulfill request"},
+	INSUFFICIENT_STORAGE:            {"Insufficient Storage", "The server is unable to store the representation"},
 	REQUEST_URI_TOO_LONG:            {"Request-URI Too Long", "The URI provided was too long for the server to process"},
 	REQUEST_HEADER_FIELDS_TOO_LARGE: {"Request Header Fields Too Large", "The server refused this request because the request header fields are too large"},
 	CONTINUE:                        {"Continue", "Client should continue with request"},
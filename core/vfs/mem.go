@@ -0,0 +1,128 @@
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MemFS 内存文件系统，键为以 / 分隔的文件名，缺失的上级目录自动补全
+type MemFS map[string]*MemFile
+
+// MemFile MemFS 中的一个文件或目录（Mode 含 fs.ModeDir）
+type MemFile struct {
+	Data    []byte
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+func (m MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f := m[name]
+	if f != nil && !f.Mode.IsDir() {
+		info := &fileInfo{name: path.Base(name), size: int64(len(f.Data)), mode: f.Mode, modTime: f.ModTime}
+		return &readerFile{SectionReader: io.NewSectionReader(bytes.NewReader(f.Data), 0, info.size), info: info}, nil
+	}
+
+	// 目录：合并显式的子项与由更深的文件名推断出的子目录
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	children := make(map[string]*fileInfo)
+	for key, child := range m {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		base, _, deeper := strings.Cut(rest, "/")
+		if !deeper {
+			children[base] = &fileInfo{name: base, size: int64(len(child.Data)), mode: child.Mode, modTime: child.ModTime}
+		} else if children[base] == nil {
+			children[base] = &fileInfo{name: base, mode: fs.ModeDir | 0o555}
+		}
+	}
+	if f == nil && len(children) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	info := &fileInfo{name: path.Base(name), mode: fs.ModeDir | 0o555}
+	if f != nil {
+		info.mode, info.modTime = f.Mode, f.ModTime
+	}
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, c := range children {
+		entries = append(entries, c)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return &dirFile{path: name, info: info, entries: entries}, nil
+}
+
+// ---- 索引型文件系统共用的文件实现 ----
+
+// fileInfo 同时实现 fs.FileInfo 与 fs.DirEntry
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string               { return fi.name }
+func (fi *fileInfo) Size() int64                { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode          { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time         { return fi.modTime }
+func (fi *fileInfo) IsDir() bool                { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() any                   { return nil }
+func (fi *fileInfo) Type() fs.FileMode          { return fi.mode.Type() }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// readerFile 内容可随机访问的普通文件
+type readerFile struct {
+	*io.SectionReader
+	info *fileInfo
+}
+
+func (f *readerFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *readerFile) Close() error               { return nil }
+
+// dirFile 子项已知的目录
+type dirFile struct {
+	path    string
+	info    *fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirFile) Close() error               { return nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: fs.ErrInvalid}
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	return nextEntries(d.entries, &d.offset, n)
+}
+
+// nextEntries 按 fs.ReadDirFile 的约定从 entries 的 *offset 处返回至多 n 项
+func nextEntries(entries []fs.DirEntry, offset *int, n int) ([]fs.DirEntry, error) {
+	rest := entries[*offset:]
+	if n <= 0 {
+		*offset = len(entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	*offset += n
+	return rest[:n], nil
+}
@@ -0,0 +1,181 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// OverlayFS 按顺序叠加多个文件系统：文件取第一个包含它的层，目录合并各层的内容
+type OverlayFS struct {
+	layers []fs.FS
+}
+
+// Overlay 叠加 layers，前面的层优先；只有一层时直接返回该层
+func Overlay(layers ...fs.FS) fs.FS {
+	if len(layers) == 1 {
+		return layers[0]
+	}
+	return &OverlayFS{layers: layers}
+}
+
+// find 返回第一个包含 name 的层及其信息
+func (o *OverlayFS) find(name string) (fs.FS, fs.FileInfo, error) {
+	cause := fs.ErrNotExist
+	for _, l := range o.layers {
+		fi, err := fs.Stat(l, name)
+		if err == nil {
+			return l, fi, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			cause = err
+		}
+	}
+	return nil, nil, &fs.PathError{Op: "stat", Path: name, Err: cause}
+}
+
+func (o *OverlayFS) Stat(name string) (fs.FileInfo, error) {
+	_, fi, err := o.find(name)
+	return fi, err
+}
+
+func (o *OverlayFS) Open(name string) (fs.File, error) {
+	l, fi, err := o.find(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := l.Open(name)
+	if err != nil || !fi.IsDir() {
+		return f, err
+	}
+	entries, err := o.ReadDir(name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &overlayDir{File: f, entries: entries}, nil
+}
+
+// ReadDir 合并各层中同名目录的内容，同名的项取前面的层
+func (o *OverlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	found := false
+	for _, l := range o.layers {
+		fi, err := fs.Stat(l, name)
+		if err != nil {
+			continue
+		}
+		if !fi.IsDir() {
+			// 前面的层中的同名文件遮盖了后面的目录
+			break
+		}
+		list, err := fs.ReadDir(l, name)
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, e := range list {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Locate 返回提供 name 的层中的本地位置；各层都没有 name 时按第一个本地层报告（用于新建文件）
+func (o *OverlayFS) Locate(name string) (string, string, bool) {
+	if l, _, err := o.find(name); err == nil {
+		return Locate(l, name)
+	}
+	for _, l := range o.layers {
+		if root, p, ok := Locate(l, name); ok {
+			return root, p, true
+		}
+	}
+	return "", "", false
+}
+
+// Close 关闭各层
+func (o *OverlayFS) Close() error {
+	var errs []error
+	for _, l := range o.layers {
+		errs = append(errs, Close(l))
+	}
+	return errors.Join(errs...)
+}
+
+// overlayDir 合并后的目录
+type overlayDir struct {
+	fs.File
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	return nextEntries(d.entries, &d.offset, n)
+}
+
+// ---- 随机访问 ----
+
+// File 支持随机访问的文件，区间请求需要 ReadAt
+type File interface {
+	fs.File
+	io.ReaderAt
+}
+
+// OpenFile 打开文件并保证可以随机访问：不支持 ReadAt 的文件（如 ZIP 中压缩的条目）
+// 以顺序读取模拟，向前跳转时丢弃中间内容，向后跳转时重新打开
+func OpenFile(fsys fs.FS, name string) (File, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if rf, ok := f.(File); ok {
+		return rf, nil
+	}
+	return &seqFile{File: f, open: func() (fs.File, error) { return fsys.Open(name) }}, nil
+}
+
+// seqFile 以顺序读取模拟 ReadAt，对按偏移递增的读取（如逐个输出区间）是线性的
+type seqFile struct {
+	fs.File
+	open func() (fs.File, error)
+	pos  int64
+}
+
+func (s *seqFile) Read(p []byte) (int, error) {
+	n, err := s.File.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (s *seqFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < s.pos {
+		f, err := s.open()
+		if err != nil {
+			return 0, err
+		}
+		s.File.Close()
+		s.File, s.pos = f, 0
+	}
+	if off > s.pos {
+		n, err := io.CopyN(io.Discard, s.File, off-s.pos)
+		s.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(s, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,171 @@
+package vfs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// tarFS tar 归档：打开时只记录每个条目的数据在归档中的位置，读取时直接从归档文件中读取
+type tarFS struct {
+	f       *os.File
+	tmpName string // 解压出的临时文件未能提前删除时，关闭后删除
+	files   map[string]*tarEntry
+}
+
+// tarEntry 归档中的文件或目录，缺失的上级目录已补全
+type tarEntry struct {
+	info    *fileInfo
+	offset  int64         // 文件数据在（解压后的）归档中的位置
+	entries []fs.DirEntry // 目录的子项，按名称排序
+}
+
+// Tar 打开 tar 归档作为只读文件系统，内容不读入内存
+// .tar.gz / .tgz 先解压到临时文件；只保留普通文件与目录，符号链接等其他类型被忽略
+func Tar(name string) (fs.FS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	t := &tarFS{f: f}
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		err = t.gunzip()
+	}
+	if err == nil {
+		err = t.index()
+	}
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return t, nil
+}
+
+// gunzip 把 t.f 解压到临时文件并改为读取该文件
+// 临时文件创建后立即删除（文件句柄仍然有效），不支持时在 Close 中删除
+func (t *tarFS) gunzip() error {
+	gz, err := gzip.NewReader(t.f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tmp, err := os.CreateTemp("", "vfs-tar-*")
+	if err != nil {
+		return err
+	}
+	src := t.f
+	defer src.Close()
+	t.f = tmp
+	if os.Remove(tmp.Name()) != nil {
+		t.tmpName = tmp.Name()
+	}
+	_, err = io.Copy(tmp, gz)
+	return err
+}
+
+// countingReader 记录读取位置；实现 Seek 使 tar.Reader 跳过文件数据时不必读取
+type countingReader struct {
+	f   *os.File
+	pos int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.f.Read(p)
+	c.pos += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.f.Seek(offset, whence)
+	if err == nil {
+		c.pos = pos
+	}
+	return pos, err
+}
+
+// index 遍历归档的头部，记录每个条目的信息与数据位置
+func (t *tarFS) index() error {
+	if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	t.files = map[string]*tarEntry{".": {info: &fileInfo{name: ".", mode: fs.ModeDir | 0o555}}}
+	cr := &countingReader{f: t.f}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		p := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if !fs.ValidPath(p) || p == "." {
+			continue
+		}
+		info := &fileInfo{name: path.Base(p), mode: fs.FileMode(hdr.Mode).Perm(), modTime: hdr.ModTime}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			info.mode |= fs.ModeDir
+			t.files[p] = &tarEntry{info: info}
+		case tar.TypeReg:
+			// Next 返回后读取位置正好在文件数据的开头
+			info.size = hdr.Size
+			t.files[p] = &tarEntry{info: info, offset: cr.pos}
+		default:
+			continue
+		}
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if _, ok := t.files[dir]; ok {
+				break
+			}
+			t.files[dir] = &tarEntry{info: &fileInfo{name: path.Base(dir), mode: fs.ModeDir | 0o555}}
+		}
+	}
+
+	for p, e := range t.files {
+		if p == "." {
+			continue
+		}
+		parent := t.files[path.Dir(p)]
+		if !parent.info.IsDir() {
+			return errors.New("tar entry " + p + " is below a regular file")
+		}
+		parent.entries = append(parent.entries, e.info)
+	}
+	for _, e := range t.files {
+		sort.Slice(e.entries, func(i, j int) bool { return e.entries[i].Name() < e.entries[j].Name() })
+	}
+	return nil
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := t.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.info.IsDir() {
+		// 调用方（如 fs.ReadDir）可能就地排序返回的切片，每次打开使用副本
+		return &dirFile{path: name, info: e.info, entries: append([]fs.DirEntry(nil), e.entries...)}, nil
+	}
+	return &readerFile{SectionReader: io.NewSectionReader(t.f, e.offset, e.info.size), info: e.info}, nil
+}
+
+// Close 关闭归档文件
+func (t *tarFS) Close() error {
+	err := t.f.Close()
+	if t.tmpName != "" {
+		os.Remove(t.tmpName)
+	}
+	return err
+}
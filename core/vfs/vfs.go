@@ -0,0 +1,193 @@
+// Package vfs 提供静态文件层使用的文档根文件系统：本地目录、ZIP/tar 归档、
+// 编译进二进制的 embed.FS、内存文件系统，以及把多个文件系统按顺序叠加的 Overlay
+package vfs
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Locator 由能报告文件本地位置的文件系统实现
+// 本地文件可以使用文件缓存、sendfile 与符号链接策略
+type Locator interface {
+	// Locate 返回提供 name 的本地目录及 name 在本地的路径，文件不在本地目录中时 ok 为 false
+	Locate(name string) (root, osPath string, ok bool)
+}
+
+// Locate 报告 fsys 中的 name 是否对应本地文件
+func Locate(fsys fs.FS, name string) (root, osPath string, ok bool) {
+	if l, isLocator := fsys.(Locator); isLocator {
+		return l.Locate(name)
+	}
+	return "", "", false
+}
+
+// Close 释放 fsys 持有的资源（如归档的文件句柄），没有需要释放的资源时什么也不做
+// 替换文档根或挂载后对旧的文件系统调用
+func Close(fsys fs.FS) error {
+	if c, ok := fsys.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ---- 本地目录 ----
+
+// DirFS 本地目录，与 os.DirFS 相同但实现了 Locator
+type DirFS struct {
+	Root string
+	fsys fs.FS
+}
+
+// Dir 返回以 root 为根的本地目录文件系统
+func Dir(root string) *DirFS {
+	return &DirFS{Root: root, fsys: os.DirFS(root)}
+}
+
+func (d *DirFS) Open(name string) (fs.File, error) { return d.fsys.Open(name) }
+
+func (d *DirFS) Stat(name string) (fs.FileInfo, error) { return fs.Stat(d.fsys, name) }
+
+func (d *DirFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(d.fsys, name) }
+
+func (d *DirFS) Locate(name string) (string, string, bool) {
+	if !fs.ValidPath(name) {
+		return "", "", false
+	}
+	return d.Root, filepath.Join(d.Root, filepath.FromSlash(name)), true
+}
+
+// ---- 归档 ----
+
+// Zip 打开 ZIP 文件作为只读文件系统，文件句柄保持打开直到 Close
+func Zip(name string) (fs.FS, error) {
+	rc, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// subFS 归档中的子目录，关闭时关闭整个归档
+type subFS struct {
+	fs.FS
+	parent fs.FS
+}
+
+func (s *subFS) Stat(name string) (fs.FileInfo, error)      { return fs.Stat(s.FS, name) }
+func (s *subFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(s.FS, name) }
+func (s *subFS) Close() error                               { return Close(s.parent) }
+
+// ---- embed ----
+
+var (
+	embedMu  sync.Mutex
+	embedded = make(map[string]fs.FS)
+)
+
+// RegisterEmbed 登记编译进二进制的文件系统（通常是 embed.FS），可在配置中以 "embed:name" 引用
+func RegisterEmbed(name string, fsys fs.FS) {
+	embedMu.Lock()
+	defer embedMu.Unlock()
+	embedded[name] = fsys
+}
+
+// ---- 配置 ----
+
+// Open 按来源描述打开文件系统，"#子目录" 后缀取其中的子目录：
+//
+//	dir:/srv/www          本地目录
+//	zip:site-v3.zip#dist  ZIP 归档
+//	tar:site.tar.gz       tar 或 tar.gz 归档
+//	embed:testbench       RegisterEmbed 登记的文件系统
+//	mem:                  空的内存文件系统
+//
+// 省略前缀时按扩展名识别 .zip、.tar、.tar.gz、.tgz，其余视为目录
+func Open(spec string) (fs.FS, error) {
+	kind, src, ok := strings.Cut(spec, ":")
+	if !ok || len(kind) == 1 {
+		// 没有前缀（单个字母视为 Windows 盘符）
+		kind, src = "", spec
+	}
+	src, sub, _ := strings.Cut(src, "#")
+	if kind == "" {
+		switch lower := strings.ToLower(src); {
+		case strings.HasSuffix(lower, ".zip"):
+			kind = "zip"
+		case strings.HasSuffix(lower, ".tar"), strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+			kind = "tar"
+		default:
+			kind = "dir"
+		}
+	}
+
+	var fsys fs.FS
+	var err error
+	switch kind {
+	case "dir":
+		var fi os.FileInfo
+		if fi, err = os.Stat(src); err == nil && !fi.IsDir() {
+			err = fmt.Errorf("%s is not a directory", src)
+		}
+		fsys = Dir(src)
+	case "zip":
+		fsys, err = Zip(src)
+	case "tar":
+		fsys, err = Tar(src)
+	case "embed":
+		embedMu.Lock()
+		fsys, ok = embedded[src]
+		embedMu.Unlock()
+		if !ok {
+			err = fmt.Errorf("no embedded filesystem named %q", src)
+		}
+	case "mem":
+		fsys = MemFS{}
+	default:
+		err = fmt.Errorf("unknown docroot type %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sub = strings.Trim(sub, "/"); sub != "" {
+		if _, isDir := fsys.(*DirFS); isDir {
+			return Dir(filepath.Join(src, filepath.FromSlash(sub))), nil
+		}
+		subdir, err := fs.Sub(fsys, sub)
+		if err != nil {
+			Close(fsys)
+			return nil, err
+		}
+		if _, ok := fsys.(io.Closer); ok {
+			return &subFS{FS: subdir, parent: fsys}, nil
+		}
+		return subdir, nil
+	}
+	return fsys, nil
+}
+
+// OpenLayers 打开多个来源并按顺序叠加，前面的优先
+func OpenLayers(specs []string) (fs.FS, error) {
+	layers := make([]fs.FS, 0, len(specs))
+	for _, spec := range specs {
+		fsys, err := Open(spec)
+		if err != nil {
+			for _, l := range layers {
+				Close(l)
+			}
+			return nil, fmt.Errorf("docroot %q: %w", spec, err)
+		}
+		layers = append(layers, fsys)
+	}
+	if len(layers) == 0 {
+		return nil, errors.New("no docroot layers")
+	}
+	return Overlay(layers...), nil
+}
@@ -0,0 +1,272 @@
+package vfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// sample 测试用的文件内容，键为文件名
+var sample = map[string]string{
+	"index.html":        "<h1>home</h1>",
+	"css/site.css":      "body{}",
+	"js/app/main.js":    "console.log(1)",
+	"docs/guide/a.txt":  strings.Repeat("a", 4096),
+	"docs/guide/b.txt":  "b",
+	"docs/readme.md":    "# docs",
+	"deep/x/y/z/leaf.t": "leaf",
+}
+
+func sampleMem() MemFS {
+	m := MemFS{"empty": {Mode: fs.ModeDir | 0o755, ModTime: modTime}}
+	for name, data := range sample {
+		m[name] = &MemFile{Data: []byte(data), Mode: 0o644, ModTime: modTime}
+	}
+	return m
+}
+
+func sampleNames() []string {
+	names := make([]string, 0, len(sample))
+	for name := range sample {
+		names = append(names, name)
+	}
+	return names
+}
+
+// checkSample 检查 fsys 中的文件内容与 sample 一致
+func checkSample(t *testing.T, fsys fs.FS) {
+	t.Helper()
+	for name, want := range sample {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatalf("ReadFile(%s): %v", name, err)
+		}
+		if string(got) != want {
+			t.Fatalf("ReadFile(%s) = %q, want %q", name, got, want)
+		}
+	}
+	if err := fstest.TestFS(fsys, sampleNames()...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	m := sampleMem()
+	checkSample(t, m)
+
+	fi, err := fs.Stat(m, "docs/guide")
+	if err != nil || !fi.IsDir() {
+		t.Fatalf("Stat(docs/guide) = %v, %v; want an implied directory", fi, err)
+	}
+	fi, err = fs.Stat(m, "empty")
+	if err != nil || !fi.IsDir() || !fi.ModTime().Equal(modTime) {
+		t.Fatalf("Stat(empty) = %v, %v; want the explicit directory", fi, err)
+	}
+	entries, err := fs.ReadDir(m, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if got := strings.Join(names, ","); got != "guide,readme.md" {
+		t.Fatalf("ReadDir(docs) = %s", got)
+	}
+	for _, name := range []string{"missing", "docs/missing", "index.html/x"} {
+		if _, err := m.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open(%s) error = %v, want ErrNotExist", name, err)
+		}
+	}
+	if _, err := m.Open("../x"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open(../x) error = %v, want ErrInvalid", err)
+	}
+}
+
+func TestMemFSReadAt(t *testing.T) {
+	f, err := OpenFile(sampleMem(), "docs/guide/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(*seqFile); ok {
+		t.Fatal("MemFS files should support ReadAt directly")
+	}
+	buf := make([]byte, 4)
+	if n, err := f.ReadAt(buf, 4092); n != 4 || err != nil && err != io.EOF {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	top := MemFS{
+		"index.html":    {Data: []byte("override"), Mode: 0o644},
+		"css/extra.css": {Data: []byte("extra"), Mode: 0o644},
+	}
+	o := Overlay(top, sampleMem())
+
+	got, err := fs.ReadFile(o, "index.html")
+	if err != nil || string(got) != "override" {
+		t.Fatalf("ReadFile(index.html) = %q, %v; want the top layer", got, err)
+	}
+	got, err = fs.ReadFile(o, "docs/readme.md")
+	if err != nil || string(got) != "# docs" {
+		t.Fatalf("ReadFile(docs/readme.md) = %q, %v; want the bottom layer", got, err)
+	}
+	entries, err := fs.ReadDir(o, "css")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ReadDir(css) = %v, %v; want both layers merged", entries, err)
+	}
+	if err := fstest.TestFS(o, "index.html", "css/extra.css", "css/site.css", "docs/guide/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTar 把 sample 写成 tar 归档，gz 为真时压缩
+func writeTar(t *testing.T, name string, gz bool) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if gz {
+		zw := gzip.NewWriter(f)
+		defer zw.Close()
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	tw.WriteHeader(&tar.Header{Name: "./empty/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: modTime})
+	tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd", ModTime: modTime})
+	for name, data := range sample {
+		if err := tw.WriteHeader(&tar.Header{Name: "./" + name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data)), ModTime: modTime}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTar(t *testing.T) {
+	for _, name := range []string{"site.tar", "site.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), name)
+			writeTar(t, archive, strings.HasSuffix(name, ".gz"))
+
+			fsys, err := Open(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSample(t, fsys)
+			if fi, err := fs.Stat(fsys, "empty"); err != nil || !fi.IsDir() {
+				t.Fatalf("Stat(empty) = %v, %v", fi, err)
+			}
+			if _, err := fs.Stat(fsys, "link"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("symlinks should be skipped, Stat(link) error = %v", err)
+			}
+
+			f, err := OpenFile(fsys, "docs/guide/b.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := f.(*seqFile); ok {
+				t.Fatal("tar entries should support ReadAt directly")
+			}
+			f.Close()
+
+			if err := Close(fsys); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fs.ReadFile(fsys, "index.html"); err == nil {
+				t.Fatal("ReadFile after Close should fail")
+			}
+		})
+	}
+}
+
+// writeZip 把 sample 写成 ZIP 归档，文件位于 dist/ 之下
+func writeZip(t *testing.T, name string) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	defer zw.Close()
+	for name, data := range sample {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "dist/" + name, Method: zip.Deflate, Modified: modTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, data)
+	}
+}
+
+func TestZipSubdirClose(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	writeZip(t, archive)
+
+	fsys, err := Open("zip:" + archive + "#dist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSample(t, fsys)
+
+	// 压缩的条目不支持 ReadAt，以顺序读取模拟，包括向后跳转
+	f, err := OpenFile(fsys, "docs/guide/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	for _, off := range []int64{100, 4000, 10} {
+		if n, err := f.ReadAt(buf, off); n != 2 || err != nil || string(buf) != "aa" {
+			t.Fatalf("ReadAt(%d) = %d, %v, %q", off, n, err, buf)
+		}
+	}
+	f.Close()
+
+	if err := Close(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile(fsys, "index.html"); err == nil {
+		t.Fatal("ReadFile after Close should fail")
+	}
+}
+
+func TestOpenSpec(t *testing.T) {
+	fsys, err := Open("mem:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fsys.(MemFS); !ok {
+		t.Fatalf("Open(mem:) = %T, want MemFS", fsys)
+	}
+	RegisterEmbed("sample", sampleMem())
+	fsys, err = Open("embed:sample#docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fs.ReadFile(fsys, "readme.md"); err != nil || string(got) != "# docs" {
+		t.Fatalf("ReadFile(readme.md) = %q, %v", got, err)
+	}
+	for _, spec := range []string{"embed:missing", "bogus:x", "dir:" + filepath.Join(t.TempDir(), "missing")} {
+		if _, err := Open(spec); err == nil {
+			t.Errorf("Open(%s) should fail", spec)
+		}
+	}
+}
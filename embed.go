@@ -0,0 +1,21 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+
+	"github.com/Singert/xjtu_cnlab/core/vfs"
+)
+
+// testbench 页面编译进二进制，可在配置中以 server.Docroot: ["embed:testbench"] 使用
+//
+//go:embed testbench
+var testbenchFS embed.FS
+
+func init() {
+	sub, err := fs.Sub(testbenchFS, "testbench")
+	if err != nil {
+		panic(err)
+	}
+	vfs.RegisterEmbed("testbench", sub)
+}
@@ -3,12 +3,13 @@ module github.com/Singert/xjtu_cnlab
 go 1.23.2
 
 require (
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/joho/godotenv v1.5.1
 	github.com/spf13/viper v1.20.1
+	golang.org/x/text v0.21.0
 )
 
 require (
-	github.com/fsnotify/fsnotify v1.8.0 // indirect
 	github.com/go-viper/mapstructure/v2 v2.2.1 // indirect
 	github.com/pelletier/go-toml/v2 v2.2.3 // indirect
 	github.com/sagikazarmark/locafero v0.7.0 // indirect
@@ -20,6 +21,5 @@ require (
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.9.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )